@@ -0,0 +1,199 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// thrift-curl calls any method of a Thrift service, given only its IDL.
+// Arguments are given as JSON, and the reply is printed as JSON.
+//
+// Usage:
+//
+//     thrift-curl [flags] file.thrift
+//         Lists every service in the file, with its method signatures.
+//
+//     thrift-curl [flags] file.thrift Service.method [args]
+//         Calls a method. args is a JSON object keyed by argument name, "-"
+//         to read it from stdin, or "@path" to read it from a file. If
+//         omitted, it is "{}".
+//
+// The result is printed as {"success": ...} for methods that return a value,
+// {} for void methods, or {"name": {...}} if the method threw one of its
+// declared exceptions, in which case the exit status is 2.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema"
+)
+
+var (
+	addr        = flag.String("addr", "127.0.0.1:9090", "host:port or unix socket path of the server")
+	framed      = flag.Bool("framed", false, "use the framed transport")
+	protocol    = flag.String("protocol", "binary", "protocol to use: binary or compact")
+	timeout     = flag.Duration("timeout", 10*time.Second, "timeout for connecting, sending, and receiving")
+	multiplexed = flag.Bool("multiplexed", false, "prefix the method name with \"Service:\", for multiplexed servers")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] file.thrift [Service.method [args]]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func fatal(format string, args ...interface{}) {
+	os.Exit(failed(format, args...))
+}
+
+// Prints an error, and returns the exit status for it. Once a socket is open,
+// this is used instead of fatal(), so that the socket is closed first.
+func failed(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], fmt.Sprintf(format, args...))
+	return 1
+}
+
+func load(file string) *parser.ParseTree {
+	context := parser.NewCompileContext()
+	tree := context.ParseRecursive(file)
+	if tree == nil || !sema.Analyze(context, tree) {
+		context.PrintErrors()
+		os.Exit(1)
+	}
+	return tree
+}
+
+func list(tree *parser.ParseTree) {
+	for _, node := range tree.Nodes {
+		service, ok := node.(*parser.ServiceNode)
+		if !ok {
+			continue
+		}
+
+		if service.Extends != nil {
+			fmt.Printf("service %s extends %s\n", service.Name.Identifier(), service.Extends.String())
+		} else {
+			fmt.Printf("service %s\n", service.Name.Identifier())
+		}
+		for _, current := range service.InheritanceChain() {
+			for _, method := range current.Methods {
+				if current == service {
+					fmt.Printf("    %s\n", dynamic.MethodSignature(method))
+				} else {
+					fmt.Printf("    %s (from %s)\n", dynamic.MethodSignature(method), current.Name.Identifier())
+				}
+			}
+		}
+	}
+}
+
+func readArgs(arg string) []byte {
+	var data []byte
+	var err error
+	switch {
+	case arg == "-":
+		data, err = ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(arg, "@"):
+		data, err = ioutil.ReadFile(arg[1:])
+	default:
+		data = []byte(arg)
+	}
+	if err != nil {
+		fatal("could not read arguments: %s", err)
+	}
+	return data
+}
+
+// Calls a method, prints the result, and returns the exit status.
+func call(tree *parser.ParseTree, name string, data []byte) int {
+	service, method, err := dynamic.FindMethod(tree, name)
+	if err != nil {
+		fatal("%s", err)
+	}
+	args, err := dynamic.ArgsFromJSON(method, data)
+	if err != nil {
+		fatal("bad arguments: %s", err)
+	}
+
+	var factory thrift.TProtocolFactory
+	switch *protocol {
+	case "binary":
+		factory = thrift.NewTBinaryProtocolFactoryDefault()
+	case "compact":
+		factory = thrift.NewTCompactProtocolFactory()
+	default:
+		fatal("unknown protocol: %s", *protocol)
+	}
+
+	socket, err := frugal.NewSocket(*addr, *timeout)
+	if err != nil {
+		fatal("could not connect: %s", err)
+	}
+	defer socket.Close()
+
+	var transport thrift.TTransport = socket
+	if *framed {
+		transport = thrift.NewTFramedTransport(transport)
+	}
+
+	methodName := method.Name.Identifier()
+	if *multiplexed {
+		methodName = service.Name.Identifier() + frugal.MultiplexedSeparator + methodName
+	}
+
+	result, err := dynamic.Call(factory.GetProtocol(transport), factory.GetProtocol(transport), methodName, 1, method, args)
+	if err != nil {
+		return failed("call failed: %s", err)
+	}
+	if result == nil {
+		// Oneway calls have no reply.
+		return 0
+	}
+
+	output, err := json.MarshalIndent(dynamic.StructToJSON(result), "", "  ")
+	if err != nil {
+		return failed("could not print result: %s", err)
+	}
+	fmt.Println(string(output))
+
+	// Only declared exceptions count; unknown fields in the result do not.
+	for _, field := range result.Fields {
+		if field.Id != 0 && field.Schema != nil && !field.Unknown {
+			return 2
+		}
+	}
+	return 0
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	switch flag.NArg() {
+	case 1:
+		list(load(flag.Arg(0)))
+	case 2:
+		os.Exit(call(load(flag.Arg(0)), flag.Arg(1), []byte("{}")))
+	case 3:
+		os.Exit(call(load(flag.Arg(0)), flag.Arg(1), readArgs(flag.Arg(2))))
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
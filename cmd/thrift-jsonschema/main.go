@@ -0,0 +1,80 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// thrift-jsonschema writes a JSON Schema document for a Thrift file and each
+// file it includes.
+//
+// Usage:
+//
+//     thrift-jsonschema [flags] file.thrift
+//         Writes package.json into -out for each file, where package is the
+//         file name without ".thrift".
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/edmodo/frugal/jsonschema"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema"
+)
+
+var (
+	out     = flag.String("out", ".", "directory to write documents to")
+	baseURI = flag.String("base", "", "URI prefix for each document's $id")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] file.thrift\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	context := parser.NewCompileContext()
+	tree := context.ParseRecursive(flag.Arg(0))
+	if tree == nil || !sema.Analyze(context, tree) {
+		context.PrintErrors()
+		os.Exit(1)
+	}
+
+	documents := jsonschema.Generate(tree, &jsonschema.Options{BaseURI: *baseURI})
+	for name, document := range documents {
+		data, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			fatal("%s: %s", name, err)
+		}
+		path := filepath.Join(*out, name)
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			fatal("%s", err)
+		}
+		fmt.Println(path)
+	}
+}
@@ -0,0 +1,85 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// thrift-openapi prints an OpenAPI description of the services in a Thrift
+// file.
+//
+// Usage:
+//
+//     thrift-openapi [flags] file.thrift
+//         Prints the document as JSON. By default, every service in the file
+//         is described; -services selects others, such as "common.Base".
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/edmodo/frugal/openapi"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema"
+)
+
+var (
+	title    = flag.String("title", "", "title of the API (default: the service names)")
+	version  = flag.String("version", "", "version of the API (default: 1.0.0)")
+	services = flag.String("services", "", "comma-separated services to describe")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] file.thrift\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	context := parser.NewCompileContext()
+	tree := context.ParseRecursive(flag.Arg(0))
+	if tree == nil || !sema.Analyze(context, tree) {
+		context.PrintErrors()
+		os.Exit(1)
+	}
+
+	options := &openapi.Options{
+		Title:   *title,
+		Version: *version,
+	}
+	if *services != "" {
+		options.Services = strings.Split(*services, ",")
+	}
+
+	document, err := openapi.Generate(tree, options)
+	if err != nil {
+		fatal("%s", err)
+	}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		fatal("%s", err)
+	}
+	fmt.Println(string(data))
+}
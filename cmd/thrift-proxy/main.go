@@ -0,0 +1,213 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// thrift-proxy records Thrift traffic between clients and a service, and
+// replays it against another endpoint.
+//
+// Usage:
+//
+//     thrift-proxy [flags] -upstream host:port -log calls.jsonl
+//         Listens on -listen, passes every message on to the upstream
+//         service, and appends each message and reply to the log.
+//
+//     thrift-proxy [flags] -upstream host:port -replay calls.jsonl
+//         Re-issues every recorded call against the upstream service, and
+//         prints how each reply differs from the recorded one. The exit
+//         status is 2 if any reply differs.
+//
+// With -idl and -service, message bodies are decoded with the schema, so
+// records and differences have field names. Without them, fields are keyed by
+// id.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/proxy"
+	"github.com/edmodo/frugal/sema"
+)
+
+var (
+	listen      = flag.String("listen", "127.0.0.1:9091", "host:port or unix socket path to listen on")
+	upstream    = flag.String("upstream", "", "host:port of the service to pass messages on to")
+	logPath     = flag.String("log", "-", "file to append records to, or - for stdout")
+	replayPath  = flag.String("replay", "", "replay the calls in this file instead of proxying")
+	idl         = flag.String("idl", "", "thrift file describing the service")
+	serviceName = flag.String("service", "", "name of the service in the thrift file")
+	framed      = flag.Bool("framed", false, "use the framed transport")
+	protocol    = flag.String("protocol", "binary", "protocol to use: binary or compact")
+	timeout     = flag.Duration("timeout", 10*time.Second, "timeout for connecting, sending, and receiving")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] -upstream host:port (-log file | -replay file)\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func loadService() *parser.ServiceNode {
+	if *idl == "" {
+		if *serviceName != "" {
+			fatal("-service requires -idl")
+		}
+		return nil
+	}
+	if *serviceName == "" {
+		fatal("-idl requires -service")
+	}
+
+	context := parser.NewCompileContext()
+	tree := context.ParseRecursive(*idl)
+	if tree == nil || !sema.Analyze(context, tree) {
+		context.PrintErrors()
+		os.Exit(1)
+	}
+	service, err := dynamic.FindService(tree, *serviceName)
+	if err != nil {
+		fatal("%s", err)
+	}
+	return service
+}
+
+// Wraps each transport in a framed transport.
+type framedProtocolFactory struct {
+	factory thrift.TProtocolFactory
+}
+
+func (this *framedProtocolFactory) GetProtocol(transport thrift.TTransport) thrift.TProtocol {
+	return this.factory.GetProtocol(thrift.NewTFramedTransport(transport))
+}
+
+func protocolFactory() thrift.TProtocolFactory {
+	var factory thrift.TProtocolFactory
+	switch *protocol {
+	case "binary":
+		factory = thrift.NewTBinaryProtocolFactoryDefault()
+	case "compact":
+		factory = thrift.NewTCompactProtocolFactory()
+	default:
+		fatal("unknown protocol: %s", *protocol)
+	}
+	if *framed {
+		factory = &framedProtocolFactory{factory}
+	}
+	return factory
+}
+
+// Connects to the upstream service.
+type upstreamFactory struct {
+	factory thrift.TProtocolFactory
+}
+
+func (this *upstreamFactory) Connect() (*frugal.Connection, error) {
+	socket, err := frugal.NewSocket(*upstream, *timeout)
+	if err != nil {
+		return nil, err
+	}
+	return frugal.NewConnectionFromFactory(socket, this.factory), nil
+}
+
+func record(service *parser.ServiceNode, factory thrift.TProtocolFactory) {
+	var log io.Writer = os.Stdout
+	if *logPath != "-" {
+		file, err := os.OpenFile(*logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fatal("could not open log: %s", err)
+		}
+		defer file.Close()
+		log = file
+	}
+
+	recorder := proxy.NewProxy(&upstreamFactory{factory}, &proxy.Options{
+		Log:             log,
+		Service:         service,
+		ProtocolFactory: factory,
+		LogError: func(context string, err error) {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", os.Args[0], context, err)
+		},
+	})
+	defer recorder.Close()
+
+	server, err := frugal.NewServer(recorder, &frugal.ServerOptions{
+		ListenAddr:    *listen,
+		ClientTimeout: *timeout,
+	})
+	if err != nil {
+		fatal("could not listen: %s", err)
+	}
+	if err := server.Serve(); err != nil {
+		fatal("%s", err)
+	}
+}
+
+func replay(service *parser.ServiceNode, factory thrift.TProtocolFactory) {
+	records, err := proxy.LoadRecords(*replayPath)
+	if err != nil {
+		fatal("could not read recording: %s", err)
+	}
+
+	differ, failed := 0, 0
+	results := proxy.Replay(&upstreamFactory{factory}, records, service)
+	for _, result := range results {
+		call := result.Call
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("%s #%d: failed: %s\n", call.Method, call.Request, result.Err)
+		case len(result.Differences) > 0:
+			differ++
+			fmt.Printf("%s #%d: %d difference(s)\n", call.Method, call.Request, len(result.Differences))
+			for _, difference := range result.Differences {
+				fmt.Printf("    %s\n", difference.String())
+			}
+		}
+	}
+	fmt.Printf("%d call(s) replayed, %d differ, %d failed\n", len(results), differ, failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+	if differ > 0 {
+		os.Exit(2)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 0 || *upstream == "" {
+		usage()
+		os.Exit(1)
+	}
+
+	service := loadService()
+	factory := protocolFactory()
+	if *replayPath != "" {
+		replay(service, factory)
+	} else {
+		record(service, factory)
+	}
+}
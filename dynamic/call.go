@@ -0,0 +1,68 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Calls a method and decodes its result struct. The name is sent as the
+// method name, so it can carry a multiplexing prefix ("Service:method").
+//
+// Oneway methods return a nil result as soon as the call is sent. If the
+// server replies with an exception message, it is returned as a
+// thrift.TApplicationException. Exceptions declared by the method are fields
+// of the result, not errors.
+func Call(iprot thrift.TProtocol, oprot thrift.TProtocol, name string, seqId int32, method *parser.ServiceMethod, args *Struct) (*Struct, error) {
+	msgType := thrift.CALL
+	if method.OneWay != nil {
+		msgType = thrift.ONEWAY
+	}
+	if err := EncodeMessage(oprot, name, msgType, seqId, args); err != nil {
+		return nil, err
+	}
+	if method.OneWay != nil {
+		return nil, nil
+	}
+
+	_, msgType, replySeqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+	if replySeqId != seqId {
+		return nil, fmt.Errorf("expected a reply to call %d, got %d", seqId, replySeqId)
+	}
+
+	switch msgType {
+	case thrift.EXCEPTION:
+		exception, err := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "").Read(iprot)
+		if err != nil {
+			return nil, err
+		}
+		if err := iprot.ReadMessageEnd(); err != nil {
+			return nil, err
+		}
+		return nil, exception
+	case thrift.REPLY:
+		result, err := DecodeResult(iprot, method)
+		if err != nil {
+			return nil, err
+		}
+		return result, iprot.ReadMessageEnd()
+	}
+	return nil, fmt.Errorf("unexpected message type: %d", msgType)
+}
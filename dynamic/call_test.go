@@ -0,0 +1,159 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Answers one message on a socket by passing the decoded message to reply,
+// which writes the reply body.
+func ServeTestMessage(socket *frugal.Socket, service *parser.ServiceNode, reply func(message *Message, oprot thrift.TProtocol)) {
+	proto := thrift.NewTBinaryProtocolTransport(socket)
+	message, err := DecodeMessage(proto, service)
+	if err != nil {
+		return
+	}
+	reply(message, proto)
+	proto.Flush()
+}
+
+func MarshalTestJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	Expect(err).To(BeNil())
+	return string(data)
+}
+
+var _ = Describe("Call", func() {
+	var tree *parser.ParseTree
+	var service *parser.ServiceNode
+	var client, server *frugal.Socket
+	var proto thrift.TProtocol
+	var serving sync.WaitGroup
+
+	BeforeEach(func() {
+		tree = ParseDefaultTestSchema()
+		service, _ = FindService(tree, "Users")
+		client, server = frugal.NewMemorySocketPair(time.Second)
+		proto = thrift.NewTBinaryProtocolTransport(client)
+	})
+
+	// Sockets are not safe to close while in use, so the server side is closed
+	// only once it is done replying.
+	AfterEach(func() {
+		client.Close()
+		serving.Wait()
+		server.Close()
+	})
+
+	serve := func(reply func(message *Message, oprot thrift.TProtocol)) {
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			ServeTestMessage(server, service, reply)
+		}()
+	}
+
+	It("calls methods and decodes results", func() {
+		serve(func(message *Message, oprot thrift.TProtocol) {
+			result, _ := ResultFromJSON(message.Method, []byte(`{"success": {"id": 7, "name": "Ada", "favorite": 2}}`))
+			EncodeMessage(oprot, message.Name, thrift.REPLY, message.SeqId, result)
+		})
+
+		_, method, _ := FindMethod(tree, "Users.getUser")
+		args, _ := ArgsFromJSON(method, []byte(`{"id": 7}`))
+		result, err := Call(proto, proto, "getUser", 5, method, args)
+		Expect(err).To(BeNil())
+		Expect(MarshalTestJSON(StructToJSON(result))).To(Equal(
+			`{"success":{"id":7,"name":"Ada","favorite":"GREEN","active":true}}`,
+		))
+	})
+
+	It("returns declared exceptions as result fields", func() {
+		serve(func(message *Message, oprot thrift.TProtocol) {
+			result, _ := ResultFromJSON(message.Method, []byte(`{"notFound": {"message": "gone"}}`))
+			EncodeMessage(oprot, message.Name, thrift.REPLY, message.SeqId, result)
+		})
+
+		_, method, _ := FindMethod(tree, "Users.getUser")
+		args, _ := ArgsFromJSON(method, []byte(`{"id": 7}`))
+		result, err := Call(proto, proto, "getUser", 1, method, args)
+		Expect(err).To(BeNil())
+		Expect(MarshalTestJSON(StructToJSON(result))).To(Equal(`{"notFound":{"message":"gone"}}`))
+	})
+
+	It("returns application exceptions as errors", func() {
+		serve(func(message *Message, oprot thrift.TProtocol) {
+			oprot.WriteMessageBegin(message.Name, thrift.EXCEPTION, message.SeqId)
+			thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "no ping here").Write(oprot)
+			oprot.WriteMessageEnd()
+		})
+
+		_, method, _ := FindMethod(tree, "Users.ping")
+		args, _ := ArgsFromJSON(method, []byte(`{}`))
+		_, err := Call(proto, proto, "ping", 1, method, args)
+		Expect(err).NotTo(BeNil())
+		Expect(err.(thrift.TApplicationException).TypeId()).To(Equal(int32(thrift.UNKNOWN_METHOD)))
+	})
+
+	It("does not wait for oneway replies", func() {
+		received := make(chan *Message, 1)
+		serve(func(message *Message, oprot thrift.TProtocol) {
+			received <- message
+		})
+
+		_, method, _ := FindMethod(tree, "Users.touch")
+		args, _ := ArgsFromJSON(method, []byte(`{"id": 3}`))
+		result, err := Call(proto, proto, "touch", 1, method, args)
+		Expect(err).To(BeNil())
+		Expect(result).To(BeNil())
+
+		var message *Message
+		Eventually(received).Should(Receive(&message))
+		Expect(message.Type).To(Equal(thrift.ONEWAY))
+		Expect(message.Body.Field("id").Value).To(Equal(int64(3)))
+	})
+
+	It("prints values as JSON", func() {
+		proto, _ := NewTestProtocol()
+		WriteTestUser(proto)
+		user, _ := FindStruct(tree, "User")
+		decoded, err := DecodeStruct(proto, user)
+		Expect(err).To(BeNil())
+
+		Expect(MarshalTestJSON(StructToJSON(decoded))).To(Equal(
+			`{"id":42,"name":"Ada","favorite":"BLUE","palette":["GREEN",7],` +
+				`"places":{"home":{"x":3,"y":4}},"score":1.5,"grid":[[1,2]],"99":["x"]}`,
+		))
+
+		// Maps with struct keys become arrays of pairs.
+		value := &Map{Entries: []*MapEntry{{&Struct{Fields: []*Field{}}, int32(1)}}}
+		Expect(MarshalTestJSON(ValueToJSON(value))).To(Equal(`[[{},1]]`))
+	})
+
+	It("prints method signatures", func() {
+		_, method, _ := FindMethod(tree, "Users.getUser")
+		Expect(MethodSignature(method)).To(Equal("User getUser(1: UserId id) throws (1: NotFound notFound)"))
+		_, method, _ = FindMethod(tree, "Users.touch")
+		Expect(MethodSignature(method)).To(Equal("oneway void touch(1: UserId id)"))
+	})
+})
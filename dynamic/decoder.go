@@ -0,0 +1,367 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"fmt"
+	"strings"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Values nested deeper than this are rejected, so that a malicious or
+// corrupt payload cannot exhaust the stack.
+const MaxDepth = 64
+
+type decoder struct {
+	iprot thrift.TProtocol
+	depth int
+}
+
+// Decodes a struct using the given schema. The schema must have been through
+// semantic analysis.
+func DecodeStruct(iprot thrift.TProtocol, node *parser.StructNode) (*Struct, error) {
+	decoder := &decoder{iprot: iprot}
+	return decoder.readStruct(node)
+}
+
+// Decodes a single value of the given type. The type must have been through
+// semantic analysis. If the type is nil, the value is decoded from the wire
+// type alone.
+func DecodeValue(iprot thrift.TProtocol, wire thrift.TType, ttype parser.Type) (interface{}, error) {
+	decoder := &decoder{iprot: iprot}
+	return decoder.read(wire, ttype)
+}
+
+// Decodes the arguments struct of a CALL or ONEWAY message.
+func DecodeArgs(iprot thrift.TProtocol, method *parser.ServiceMethod) (*Struct, error) {
+	return DecodeStruct(iprot, ArgsStruct(method))
+}
+
+// Decodes the result struct of a REPLY message.
+func DecodeResult(iprot thrift.TProtocol, method *parser.ServiceMethod) (*Struct, error) {
+	return DecodeStruct(iprot, ResultStruct(method))
+}
+
+// A decoded message.
+type Message struct {
+	Name  string
+	Type  thrift.TMessageType
+	SeqId int32
+
+	// The method named by the message, or nil if the service has no such
+	// method. In that case, the body is decoded without a schema.
+	Method *parser.ServiceMethod
+
+	// The arguments of a CALL or ONEWAY message, or the result of a REPLY
+	// message. This is nil for EXCEPTION messages.
+	Body *Struct
+
+	// The exception sent in an EXCEPTION message.
+	Exception thrift.TApplicationException
+}
+
+// Decodes a whole message sent to or from the given service. Methods of base
+// services are found as well. If the method name has a multiplexing prefix
+// ("Service:method"), the prefix is ignored.
+func DecodeMessage(iprot thrift.TProtocol, service *parser.ServiceNode) (*Message, error) {
+	name, msgType, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		Name:  name,
+		Type:  msgType,
+		SeqId: seqId,
+	}
+	if index := strings.LastIndex(name, ":"); index != -1 {
+		name = name[index+1:]
+	}
+	message.Method = FindServiceMethod(service, name)
+
+	var node *parser.StructNode
+	switch msgType {
+	case thrift.CALL, thrift.ONEWAY:
+		if message.Method != nil {
+			node = ArgsStruct(message.Method)
+		}
+	case thrift.REPLY:
+		if message.Method != nil {
+			node = ResultStruct(message.Method)
+		}
+	case thrift.EXCEPTION:
+		exception, err := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "").Read(iprot)
+		if err != nil {
+			return nil, err
+		}
+		message.Exception = exception
+		return message, iprot.ReadMessageEnd()
+	default:
+		return nil, fmt.Errorf("unknown message type: %d", msgType)
+	}
+
+	if message.Body, err = DecodeStruct(iprot, node); err != nil {
+		return nil, err
+	}
+	return message, iprot.ReadMessageEnd()
+}
+
+func (this *decoder) enter() error {
+	this.depth++
+	if this.depth > MaxDepth {
+		return fmt.Errorf("values are nested more than %d deep", MaxDepth)
+	}
+	return nil
+}
+
+func (this *decoder) leave() {
+	this.depth--
+}
+
+// Reads a struct. If the schema is nil, every field is unknown.
+func (this *decoder) readStruct(node *parser.StructNode) (*Struct, error) {
+	if err := this.enter(); err != nil {
+		return nil, err
+	}
+	defer this.leave()
+
+	// Index the schema by field id.
+	schema := map[int16]*parser.StructField{}
+	if node != nil {
+		for _, field := range node.Fields {
+			schema[FieldId(field.Order)] = field
+		}
+	}
+
+	result := &Struct{
+		Node:   node,
+		Fields: []*Field{},
+	}
+	if _, err := this.iprot.ReadStructBegin(); err != nil {
+		return nil, err
+	}
+	for {
+		_, wire, id, err := this.iprot.ReadFieldBegin()
+		if err != nil {
+			return nil, err
+		}
+		if wire == thrift.STOP {
+			break
+		}
+
+		field := &Field{
+			Id:     id,
+			Type:   wire,
+			Schema: schema[id],
+		}
+
+		var ttype parser.Type
+		if field.Schema != nil {
+			field.Name = field.Schema.Name.Identifier()
+			ttype = field.Schema.Type
+		}
+		if ttype == nil || TTypeOf(ttype) != wire {
+			field.Unknown = true
+			ttype = nil
+		}
+
+		if field.Value, err = this.read(wire, ttype); err != nil {
+			if field.Name != "" {
+				return nil, fmt.Errorf("%s: %v", field.Name, err)
+			}
+			return nil, fmt.Errorf("field %d: %v", id, err)
+		}
+		if err := this.iprot.ReadFieldEnd(); err != nil {
+			return nil, err
+		}
+		result.Fields = append(result.Fields, field)
+	}
+	if err := this.iprot.ReadStructEnd(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Reads a value. The schema type, if not nil, must match the wire type. If
+// it is nil, the value is read from the wire type alone.
+func (this *decoder) read(wire thrift.TType, ttype parser.Type) (interface{}, error) {
+	var node parser.Node
+	if ttype != nil {
+		ttype, node = ttype.Resolve()
+	}
+
+	switch wire {
+	case thrift.BOOL:
+		return this.iprot.ReadBool()
+	case thrift.BYTE:
+		return this.iprot.ReadByte()
+	case thrift.I16:
+		return this.iprot.ReadI16()
+	case thrift.I32:
+		value, err := this.iprot.ReadI32()
+		if err != nil {
+			return nil, err
+		}
+		if enum, ok := node.(*parser.EnumNode); ok {
+			return newEnum(enum, value), nil
+		}
+		return value, nil
+	case thrift.I64:
+		return this.iprot.ReadI64()
+	case thrift.DOUBLE:
+		return this.iprot.ReadDouble()
+	case thrift.STRING:
+		return this.iprot.ReadString()
+	case thrift.STRUCT:
+		structNode, _ := node.(*parser.StructNode)
+		return this.readStruct(structNode)
+	case thrift.LIST, thrift.SET:
+		list, ok := ttype.(*parser.ListType)
+		if !ok {
+			return this.readUnknownList(wire)
+		}
+		return this.readList(wire, list.Inner)
+	case thrift.MAP:
+		var key, value parser.Type
+		if mapType, ok := ttype.(*parser.MapType); ok {
+			key, value = mapType.Key, mapType.Value
+		}
+		return this.readMap(key, value)
+	}
+	return nil, fmt.Errorf("unknown wire type: %d", wire)
+}
+
+func newEnum(node *parser.EnumNode, value int32) *Enum {
+	enum := &Enum{
+		Node:  node,
+		Value: value,
+	}
+	for _, entry := range node.Entries {
+		if entry.ConstVal == value {
+			enum.Entry = entry
+			break
+		}
+	}
+	return enum
+}
+
+// Returns the schema type to use for elements of the given wire type, or nil
+// if the schema does not match the wire.
+func elementType(wire thrift.TType, ttype parser.Type) parser.Type {
+	if ttype == nil || TTypeOf(ttype) != wire {
+		return nil
+	}
+	return ttype
+}
+
+func (this *decoder) readList(wire thrift.TType, inner parser.Type) (interface{}, error) {
+	_, values, err := this.readElems(wire, inner)
+	return values, err
+}
+
+// Reads a list or set that the schema does not describe.
+func (this *decoder) readUnknownList(wire thrift.TType) (interface{}, error) {
+	elemWire, values, err := this.readElems(wire, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &List{
+		WireType:     wire,
+		ElemWireType: elemWire,
+		Elems:        values,
+	}, nil
+}
+
+// Reads the elements of a list or set, and returns their wire type.
+func (this *decoder) readElems(wire thrift.TType, inner parser.Type) (thrift.TType, []interface{}, error) {
+	if err := this.enter(); err != nil {
+		return 0, nil, err
+	}
+	defer this.leave()
+
+	var elemWire thrift.TType
+	var size int
+	var err error
+	if wire == thrift.SET {
+		elemWire, size, err = this.iprot.ReadSetBegin()
+	} else {
+		elemWire, size, err = this.iprot.ReadListBegin()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if size < 0 {
+		return 0, nil, fmt.Errorf("negative list size: %d", size)
+	}
+
+	inner = elementType(elemWire, inner)
+	values := []interface{}{}
+	for i := 0; i < size; i++ {
+		value, err := this.read(elemWire, inner)
+		if err != nil {
+			return 0, nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+		values = append(values, value)
+	}
+
+	if wire == thrift.SET {
+		err = this.iprot.ReadSetEnd()
+	} else {
+		err = this.iprot.ReadListEnd()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return elemWire, values, nil
+}
+
+func (this *decoder) readMap(key parser.Type, value parser.Type) (interface{}, error) {
+	if err := this.enter(); err != nil {
+		return nil, err
+	}
+	defer this.leave()
+
+	keyWire, valueWire, size, err := this.iprot.ReadMapBegin()
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("negative map size: %d", size)
+	}
+
+	key = elementType(keyWire, key)
+	value = elementType(valueWire, value)
+	result := &Map{
+		KeyType:       key,
+		KeyWireType:   keyWire,
+		ValueWireType: valueWire,
+		Entries:       []*MapEntry{},
+	}
+	for i := 0; i < size; i++ {
+		entry := &MapEntry{}
+		if entry.Key, err = this.read(keyWire, key); err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		if entry.Value, err = this.read(valueWire, value); err != nil {
+			return nil, fmt.Errorf("value %d: %v", i, err)
+		}
+		result.Entries = append(result.Entries, entry)
+	}
+	if err := this.iprot.ReadMapEnd(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
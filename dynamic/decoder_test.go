@@ -0,0 +1,288 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Writes a User struct by hand, the way generated code would, plus an extra
+// field 99 that is not in the schema.
+func WriteTestUser(oprot thrift.TProtocol) {
+	oprot.WriteStructBegin("User")
+
+	oprot.WriteFieldBegin("id", thrift.I64, 1)
+	oprot.WriteI64(42)
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("name", thrift.STRING, 2)
+	oprot.WriteString("Ada")
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("favorite", thrift.I32, 3)
+	oprot.WriteI32(3)
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("palette", thrift.LIST, 4)
+	oprot.WriteListBegin(thrift.I32, 2)
+	oprot.WriteI32(2)
+	oprot.WriteI32(7)
+	oprot.WriteListEnd()
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("places", thrift.MAP, 5)
+	oprot.WriteMapBegin(thrift.STRING, thrift.STRUCT, 1)
+	oprot.WriteString("home")
+	oprot.WriteStructBegin("Point")
+	oprot.WriteFieldBegin("x", thrift.I32, 1)
+	oprot.WriteI32(3)
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldBegin("y", thrift.I32, 2)
+	oprot.WriteI32(4)
+	oprot.WriteFieldEnd()
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	oprot.WriteMapEnd()
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("score", thrift.DOUBLE, 6)
+	oprot.WriteDouble(1.5)
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("grid", thrift.LIST, 8)
+	oprot.WriteListBegin(thrift.LIST, 1)
+	oprot.WriteListBegin(thrift.I16, 2)
+	oprot.WriteI16(1)
+	oprot.WriteI16(2)
+	oprot.WriteListEnd()
+	oprot.WriteListEnd()
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldBegin("extra", thrift.LIST, 99)
+	oprot.WriteListBegin(thrift.STRING, 1)
+	oprot.WriteString("x")
+	oprot.WriteListEnd()
+	oprot.WriteFieldEnd()
+
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+}
+
+func NewTestProtocol() (thrift.TProtocol, *thrift.TMemoryBuffer) {
+	buffer := thrift.NewTMemoryBuffer()
+	return thrift.NewTBinaryProtocolTransport(buffer), buffer
+}
+
+var _ = Describe("Decoder", func() {
+	var tree *parser.ParseTree
+
+	BeforeEach(func() {
+		tree = ParseDefaultTestSchema()
+	})
+
+	It("decodes structs by schema", func() {
+		node, err := FindStruct(tree, "User")
+		Expect(err).To(BeNil())
+
+		proto, _ := NewTestProtocol()
+		WriteTestUser(proto)
+
+		user, err := DecodeStruct(proto, node)
+		Expect(err).To(BeNil())
+		Expect(user.Node).To(Equal(node))
+		Expect(user.Field("id").Value).To(Equal(int64(42)))
+		Expect(user.Field("name").Value).To(Equal("Ada"))
+		Expect(user.Field("score").Value).To(Equal(1.5))
+
+		// Absent fields are not filled in.
+		Expect(user.Field("active")).To(BeNil())
+
+		// Enums carry their entry, if the value is known.
+		favorite := user.Field("favorite").Value.(*Enum)
+		Expect(favorite.Name()).To(Equal("BLUE"))
+		Expect(favorite.Value).To(Equal(int32(3)))
+
+		// Typedefs of lists are resolved, and so are enums inside them.
+		palette := user.Field("palette").Value.([]interface{})
+		Expect(palette).To(HaveLen(2))
+		Expect(palette[0].(*Enum).Name()).To(Equal("GREEN"))
+		Expect(palette[1].(*Enum).Entry).To(BeNil())
+		Expect(palette[1].(*Enum).Value).To(Equal(int32(7)))
+
+		// Structs from other packages are decoded with their schema.
+		places := user.Field("places").Value.(*Map)
+		Expect(places.Entries).To(HaveLen(1))
+		Expect(places.Entries[0].Key).To(Equal("home"))
+		point := places.Entries[0].Value.(*Struct)
+		Expect(point.Node.Name.Identifier()).To(Equal("Point"))
+		Expect(point.Field("x").Value).To(Equal(int32(3)))
+		Expect(point.Field("y").Value).To(Equal(int32(4)))
+
+		grid := user.Field("grid").Value.([]interface{})
+		Expect(grid).To(Equal([]interface{}{[]interface{}{int16(1), int16(2)}}))
+	})
+
+	It("preserves unknown fields", func() {
+		node, _ := FindStruct(tree, "User")
+		proto, _ := NewTestProtocol()
+		WriteTestUser(proto)
+
+		user, err := DecodeStruct(proto, node)
+		Expect(err).To(BeNil())
+
+		unknown := user.UnknownFields()
+		Expect(unknown).To(HaveLen(1))
+		Expect(unknown[0].Id).To(Equal(int16(99)))
+		Expect(unknown[0].Name).To(Equal(""))
+		Expect(unknown[0].Type).To(Equal(thrift.TType(thrift.LIST)))
+		Expect(unknown[0].Value).To(Equal(&List{
+			WireType:     thrift.LIST,
+			ElemWireType: thrift.STRING,
+			Elems:        []interface{}{"x"},
+		}))
+		Expect(user.FieldById(99)).To(Equal(unknown[0]))
+	})
+
+	It("flags fields whose wire type does not match the schema", func() {
+		node, _ := FindStruct(tree, "User")
+		proto, _ := NewTestProtocol()
+		proto.WriteStructBegin("User")
+		proto.WriteFieldBegin("id", thrift.STRING, 1)
+		proto.WriteString("not a number")
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+
+		user, err := DecodeStruct(proto, node)
+		Expect(err).To(BeNil())
+		id := user.Field("id")
+		Expect(id.Unknown).To(BeTrue())
+		Expect(id.Schema).To(Equal(node.Names["id"]))
+		Expect(id.Value).To(Equal("not a number"))
+	})
+
+	It("decodes structs from included packages by name", func() {
+		node, err := FindStruct(tree, "common.Point")
+		Expect(err).To(BeNil())
+		Expect(node.Name.Identifier()).To(Equal("Point"))
+
+		_, err = FindStruct(tree, "Color")
+		Expect(err).NotTo(BeNil())
+		_, err = FindStruct(tree, "nope.Point")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("decodes method arguments and results", func() {
+		service, method, err := FindMethod(tree, "Users.getUser")
+		Expect(err).To(BeNil())
+		Expect(service.Name.Identifier()).To(Equal("Users"))
+
+		proto, _ := NewTestProtocol()
+		proto.WriteStructBegin("getUser_args")
+		proto.WriteFieldBegin("id", thrift.I64, 1)
+		proto.WriteI64(42)
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+
+		args, err := DecodeArgs(proto, method)
+		Expect(err).To(BeNil())
+		Expect(args.Node.Name.Identifier()).To(Equal("getUser_args"))
+		Expect(args.Field("id").Value).To(Equal(int64(42)))
+
+		proto.WriteStructBegin("getUser_result")
+		proto.WriteFieldBegin("notFound", thrift.STRUCT, 1)
+		proto.WriteStructBegin("NotFound")
+		proto.WriteFieldBegin("message", thrift.STRING, 1)
+		proto.WriteString("no such user")
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+
+		result, err := DecodeResult(proto, method)
+		Expect(err).To(BeNil())
+		notFound := result.Field("notFound").Value.(*Struct)
+		Expect(notFound.Node.Name.Identifier()).To(Equal("NotFound"))
+		Expect(notFound.Field("message").Value).To(Equal("no such user"))
+	})
+
+	It("decodes whole messages, including inherited methods", func() {
+		service, err := FindService(tree, "Users")
+		Expect(err).To(BeNil())
+
+		proto, _ := NewTestProtocol()
+		proto.WriteMessageBegin("ping", thrift.REPLY, 7)
+		proto.WriteStructBegin("ping_result")
+		proto.WriteFieldBegin("success", thrift.BOOL, 0)
+		proto.WriteBool(true)
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+		proto.WriteMessageEnd()
+
+		message, err := DecodeMessage(proto, service)
+		Expect(err).To(BeNil())
+		Expect(message.SeqId).To(Equal(int32(7)))
+		Expect(message.Method.Name.Identifier()).To(Equal("ping"))
+		Expect(message.Body.Field("success").Value).To(Equal(true))
+
+		// Multiplexed names and exceptions.
+		proto.WriteMessageBegin("Users:getUser", thrift.EXCEPTION, 8)
+		thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "oops").Write(proto)
+		proto.WriteMessageEnd()
+
+		message, err = DecodeMessage(proto, service)
+		Expect(err).To(BeNil())
+		Expect(message.Method.Name.Identifier()).To(Equal("getUser"))
+		Expect(message.Body).To(BeNil())
+		Expect(message.Exception.TypeId()).To(Equal(int32(thrift.INTERNAL_ERROR)))
+		Expect(message.Exception.Error()).To(Equal("oops"))
+	})
+
+	It("decodes unknown methods without a schema", func() {
+		service, _ := FindService(tree, "Users")
+		proto, _ := NewTestProtocol()
+		proto.WriteMessageBegin("mystery", thrift.CALL, 1)
+		proto.WriteStructBegin("mystery_args")
+		proto.WriteFieldBegin("a", thrift.I32, 1)
+		proto.WriteI32(5)
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+		proto.WriteMessageEnd()
+
+		message, err := DecodeMessage(proto, service)
+		Expect(err).To(BeNil())
+		Expect(message.Method).To(BeNil())
+		Expect(message.Body.Node).To(BeNil())
+		Expect(message.Body.Fields[0].Unknown).To(BeTrue())
+		Expect(message.Body.Fields[0].Value).To(Equal(int32(5)))
+	})
+
+	It("rejects deeply nested values", func() {
+		proto, _ := NewTestProtocol()
+		for i := 0; i < MaxDepth+1; i++ {
+			proto.WriteListBegin(thrift.LIST, 1)
+		}
+
+		_, err := DecodeValue(proto, thrift.LIST, nil)
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,65 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"testing"
+
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dynamic encoding testing")
+}
+
+const TestSchema = `
+include "common.thrift"
+
+enum Color {
+	RED = 1
+	GREEN = 2
+	BLUE
+}
+
+typedef i64 UserId
+typedef list<Color> Palette
+
+struct User {
+	1: required UserId id
+	2: required string name
+	3: optional Color favorite
+	4: optional Palette palette
+	5: optional map<string, common.Point> places
+	6: optional double score
+	7: optional bool active = true
+	8: optional list<list<i16>> grid
+}
+
+service Users extends Base {
+	User getUser(1: UserId id) throws (1: NotFound notFound)
+	oneway void touch(1: UserId id)
+}
+` + sematest.UsersSchema
+
+// Compiles TestSchema along with the common.thrift it includes.
+func ParseDefaultTestSchema() *parser.ParseTree {
+	return sematest.Compile(map[string]string{
+		"test.thrift":   TestSchema,
+		"common.thrift": sematest.CommonSchema,
+	})
+}
@@ -0,0 +1,264 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Encodes a struct. Fields are written in order, using their schema if they
+// have one, and otherwise their wire type and value. Values built by
+// StructFromJSON() and values read by DecodeStruct() can both be encoded.
+//
+// Containers without a schema take their element types from their first
+// element. Sets nested inside unknown fields are written as lists.
+func EncodeStruct(oprot thrift.TProtocol, value *Struct) error {
+	name := ""
+	if value.Node != nil {
+		name = value.Node.Name.Identifier()
+	}
+	if err := oprot.WriteStructBegin(name); err != nil {
+		return err
+	}
+
+	for _, field := range value.Fields {
+		var ttype parser.Type
+		if field.Schema != nil && !field.Unknown {
+			ttype = field.Schema.Type
+		}
+
+		wire := field.Type
+		if ttype != nil {
+			wire = TTypeOf(ttype)
+		} else if wire == thrift.STOP {
+			wire = wireTypeOf(field.Value)
+		}
+
+		if err := oprot.WriteFieldBegin(field.Name, wire, field.Id); err != nil {
+			return err
+		}
+		if err := writeValue(oprot, wire, ttype, field.Value); err != nil {
+			if field.Name != "" {
+				return fmt.Errorf("%s: %v", field.Name, err)
+			}
+			return fmt.Errorf("field %d: %v", field.Id, err)
+		}
+		if err := oprot.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+// Encodes a single value. If the type is nil, the wire type is taken from the
+// value.
+func EncodeValue(oprot thrift.TProtocol, ttype parser.Type, value interface{}) error {
+	if ttype == nil {
+		return writeValue(oprot, wireTypeOf(value), nil, value)
+	}
+	return writeValue(oprot, TTypeOf(ttype), ttype, value)
+}
+
+// Encodes a whole message with the given body.
+func EncodeMessage(oprot thrift.TProtocol, name string, msgType thrift.TMessageType, seqId int32, body *Struct) error {
+	if err := oprot.WriteMessageBegin(name, msgType, seqId); err != nil {
+		return err
+	}
+	if err := EncodeStruct(oprot, body); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+// Encodes a struct to bytes, with any protocol.
+func Marshal(factory thrift.TProtocolFactory, value *Struct) ([]byte, error) {
+	buffer := thrift.NewTMemoryBuffer()
+	oprot := factory.GetProtocol(buffer)
+	if err := EncodeStruct(oprot, value); err != nil {
+		return nil, err
+	}
+	if err := oprot.Flush(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Returns the wire type for a value without a schema.
+func wireTypeOf(value interface{}) thrift.TType {
+	switch value.(type) {
+	case bool:
+		return thrift.BOOL
+	case byte:
+		return thrift.BYTE
+	case int16:
+		return thrift.I16
+	case int32, *Enum:
+		return thrift.I32
+	case int64:
+		return thrift.I64
+	case float64:
+		return thrift.DOUBLE
+	case string:
+		return thrift.STRING
+	case []interface{}:
+		return thrift.LIST
+	case *List:
+		return value.(*List).WireType
+	case *Map:
+		return thrift.MAP
+	case *Struct:
+		return thrift.STRUCT
+	}
+	return thrift.STOP
+}
+
+// Returns the wire type for the elements of a container without a schema.
+// Empty containers can claim any element type, so they use strings.
+func elementWireType(values []interface{}) thrift.TType {
+	if len(values) == 0 {
+		return thrift.STRING
+	}
+	return wireTypeOf(values[0])
+}
+
+func mismatch(wire thrift.TType, value interface{}) error {
+	return fmt.Errorf("cannot write %T as wire type %d", value, wire)
+}
+
+func writeValue(oprot thrift.TProtocol, wire thrift.TType, ttype parser.Type, value interface{}) error {
+	if ttype != nil {
+		ttype, _ = ttype.Resolve()
+	}
+
+	switch wire {
+	case thrift.BOOL:
+		if value, ok := value.(bool); ok {
+			return oprot.WriteBool(value)
+		}
+	case thrift.BYTE:
+		if value, ok := value.(byte); ok {
+			return oprot.WriteByte(value)
+		}
+	case thrift.I16:
+		if value, ok := value.(int16); ok {
+			return oprot.WriteI16(value)
+		}
+	case thrift.I32:
+		switch value.(type) {
+		case int32:
+			return oprot.WriteI32(value.(int32))
+		case *Enum:
+			return oprot.WriteI32(value.(*Enum).Value)
+		}
+	case thrift.I64:
+		if value, ok := value.(int64); ok {
+			return oprot.WriteI64(value)
+		}
+	case thrift.DOUBLE:
+		if value, ok := value.(float64); ok {
+			return oprot.WriteDouble(value)
+		}
+	case thrift.STRING:
+		if value, ok := value.(string); ok {
+			return oprot.WriteString(value)
+		}
+	case thrift.STRUCT:
+		if value, ok := value.(*Struct); ok {
+			return EncodeStruct(oprot, value)
+		}
+	case thrift.LIST, thrift.SET:
+		switch value.(type) {
+		case []interface{}:
+			return writeList(oprot, wire, ttype, elementWireType(value.([]interface{})), value.([]interface{}))
+		case *List:
+			list := value.(*List)
+			return writeList(oprot, wire, ttype, list.ElemWireType, list.Elems)
+		}
+	case thrift.MAP:
+		if value, ok := value.(*Map); ok {
+			return writeMap(oprot, ttype, value)
+		}
+	}
+	return mismatch(wire, value)
+}
+
+// Writes a list or set. The element wire type is used if there is no schema.
+func writeList(oprot thrift.TProtocol, wire thrift.TType, ttype parser.Type, elemWire thrift.TType, values []interface{}) error {
+	var inner parser.Type
+	if list, ok := ttype.(*parser.ListType); ok {
+		inner = list.Inner
+		elemWire = TTypeOf(inner)
+	}
+
+	var err error
+	if wire == thrift.SET {
+		err = oprot.WriteSetBegin(elemWire, len(values))
+	} else {
+		err = oprot.WriteListBegin(elemWire, len(values))
+	}
+	if err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		if err := writeValue(oprot, elemWire, inner, value); err != nil {
+			return fmt.Errorf("[%d]: %v", i, err)
+		}
+	}
+
+	if wire == thrift.SET {
+		return oprot.WriteSetEnd()
+	}
+	return oprot.WriteListEnd()
+}
+
+func writeMap(oprot thrift.TProtocol, ttype parser.Type, value *Map) error {
+	var keyType, valueType parser.Type
+	var keyWire, valueWire thrift.TType
+	if mapType, ok := ttype.(*parser.MapType); ok {
+		keyType, valueType = mapType.Key, mapType.Value
+		keyWire, valueWire = TTypeOf(keyType), TTypeOf(valueType)
+	} else if value.KeyWireType != thrift.STOP && value.ValueWireType != thrift.STOP {
+		keyWire, valueWire = value.KeyWireType, value.ValueWireType
+	} else {
+		keyWire, valueWire = thrift.STRING, thrift.STRING
+		if len(value.Entries) > 0 {
+			keyWire = wireTypeOf(value.Entries[0].Key)
+			valueWire = wireTypeOf(value.Entries[0].Value)
+		}
+	}
+
+	if err := oprot.WriteMapBegin(keyWire, valueWire, len(value.Entries)); err != nil {
+		return err
+	}
+	for i, entry := range value.Entries {
+		if err := writeValue(oprot, keyWire, keyType, entry.Key); err != nil {
+			return fmt.Errorf("key %d: %v", i, err)
+		}
+		if err := writeValue(oprot, valueWire, valueType, entry.Value); err != nil {
+			return fmt.Errorf("value %d: %v", i, err)
+		}
+	}
+	return oprot.WriteMapEnd()
+}
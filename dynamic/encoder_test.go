@@ -0,0 +1,242 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Returns the JSON pointer of an error from StructFromJSON().
+func PointerOfTestError(err error) string {
+	Expect(err).To(BeAssignableToTypeOf(&JSONError{}))
+	return err.(*JSONError).Pointer
+}
+
+var _ = Describe("Encoder", func() {
+	var tree *parser.ParseTree
+	var user *parser.StructNode
+
+	BeforeEach(func() {
+		tree = ParseDefaultTestSchema()
+		user, _ = FindStruct(tree, "User")
+	})
+
+	It("encodes JSON that decodes back to the same values", func() {
+		value, err := StructFromJSON(user, []byte(`{
+			"id": 9007199254740993,
+			"name": "Ada",
+			"favorite": "BLUE",
+			"palette": ["RED", 2],
+			"places": {"home": {"x": 3, "y": 4}},
+			"score": 2.5,
+			"grid": [[1, 2], []]
+		}`))
+		Expect(err).To(BeNil())
+
+		data, err := Marshal(thrift.NewTBinaryProtocolFactoryDefault(), value)
+		Expect(err).To(BeNil())
+
+		buffer := thrift.NewTMemoryBuffer()
+		buffer.Write(data)
+		decoded, err := DecodeStruct(thrift.NewTBinaryProtocolTransport(buffer), user)
+		Expect(err).To(BeNil())
+		Expect(decoded.UnknownFields()).To(BeEmpty())
+
+		// 64-bit integers are not rounded through float64.
+		Expect(decoded.Field("id").Value).To(Equal(int64(9007199254740993)))
+		Expect(decoded.Field("name").Value).To(Equal("Ada"))
+		Expect(decoded.Field("favorite").Value.(*Enum).Value).To(Equal(int32(3)))
+		palette := decoded.Field("palette").Value.([]interface{})
+		Expect(palette[0].(*Enum).Name()).To(Equal("RED"))
+		Expect(palette[1].(*Enum).Name()).To(Equal("GREEN"))
+		point := decoded.Field("places").Value.(*Map).Entries[0].Value.(*Struct)
+		Expect(point.Field("y").Value).To(Equal(int32(4)))
+		Expect(decoded.Field("score").Value).To(Equal(2.5))
+		Expect(decoded.Field("grid").Value).To(Equal([]interface{}{
+			[]interface{}{int16(1), int16(2)},
+			[]interface{}{},
+		}))
+
+		// The default was filled in.
+		Expect(decoded.Field("active").Value).To(Equal(true))
+	})
+
+	It("re-encodes decoded structs, including unknown fields", func() {
+		proto, _ := NewTestProtocol()
+		WriteTestUser(proto)
+		decoded, err := DecodeStruct(proto, user)
+		Expect(err).To(BeNil())
+
+		Expect(EncodeStruct(proto, decoded)).To(Succeed())
+		again, err := DecodeStruct(proto, user)
+		Expect(err).To(BeNil())
+		Expect(again).To(Equal(decoded))
+	})
+
+	It("re-encodes containers without a schema as they were read", func() {
+		proto, buffer := NewTestProtocol()
+		proto.WriteStructBegin("")
+		proto.WriteFieldBegin("", thrift.LIST, 1)
+		proto.WriteListBegin(thrift.I32, 0)
+		proto.WriteListEnd()
+		proto.WriteFieldEnd()
+		proto.WriteFieldBegin("", thrift.MAP, 2)
+		proto.WriteMapBegin(thrift.I64, thrift.DOUBLE, 0)
+		proto.WriteMapEnd()
+		proto.WriteFieldEnd()
+		proto.WriteFieldBegin("", thrift.LIST, 3)
+		proto.WriteListBegin(thrift.SET, 1)
+		proto.WriteSetBegin(thrift.I32, 2)
+		proto.WriteI32(1)
+		proto.WriteI32(2)
+		proto.WriteSetEnd()
+		proto.WriteListEnd()
+		proto.WriteFieldEnd()
+		proto.WriteFieldStop()
+		proto.WriteStructEnd()
+		written := append([]byte(nil), buffer.Bytes()...)
+
+		decoded, err := DecodeStruct(proto, nil)
+		Expect(err).To(BeNil())
+		data, err := Marshal(thrift.NewTBinaryProtocolFactoryDefault(), decoded)
+		Expect(err).To(BeNil())
+		Expect(data).To(Equal(written))
+	})
+
+	It("reports missing required fields", func() {
+		_, err := StructFromJSON(user, []byte(`{"id": 1}`))
+		Expect(err).NotTo(BeNil())
+		Expect(PointerOfTestError(err)).To(Equal(""))
+		Expect(err.Error()).To(ContainSubstring("name"))
+
+		_, err = StructFromJSON(user, []byte(`{"id": 1, "name": "x", "places": {"a": {"x": 1}}}`))
+		Expect(PointerOfTestError(err)).To(Equal("/places/a"))
+	})
+
+	It("reports type errors with JSON pointers", func() {
+		cases := map[string]string{
+			`{"id": "1", "name": "x"}`:                              "/id",
+			`{"id": 1, "name": "x", "favorite": "PURPLE"}`:          "/favorite",
+			`{"id": 1, "name": "x", "favorite": 9}`:                 "/favorite",
+			`{"id": 1, "name": "x", "palette": ["RED", true]}`:      "/palette/1",
+			`{"id": 1, "name": "x", "grid": [[1, 70000]]}`:          "/grid/0/1",
+			`{"id": 1, "name": "x", "places": {"a/b": {"x": 1.5}}}`: "/places/a~1b/x",
+			`{"id": 1, "name": "x", "nickname": "y"}`:               "/nickname",
+		}
+		for input, pointer := range cases {
+			_, err := StructFromJSON(user, []byte(input))
+			Expect(err).NotTo(BeNil(), input)
+			Expect(PointerOfTestError(err)).To(Equal(pointer), input)
+		}
+
+		_, err := StructFromJSON(user, []byte(`[]`))
+		Expect(PointerOfTestError(err)).To(Equal(""))
+
+		_, err = StructFromJSON(user, []byte(`{"id": 1, "name": "x"} {}`))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("accepts maps as arrays of pairs", func() {
+		schema := sematest.CompileSchema(`
+				enum Kind {
+					A = 1
+					B = 2
+				}
+				struct Lookup {
+					1: required map<Kind, i64> byKind
+					2: optional map<i32, string> byId
+				}
+		`)
+		node, err := FindStruct(schema, "Lookup")
+		Expect(err).To(BeNil())
+
+		value, err := StructFromJSON(node, []byte(`{
+			"byKind": {"B": 2, "1": 1},
+			"byId": [[7, "seven"]]
+		}`))
+		Expect(err).To(BeNil())
+
+		byKind := value.Field("byKind").Value.(*Map)
+		Expect(byKind.Entries).To(HaveLen(2))
+		Expect(byKind.Entries[0].Key.(*Enum).Name()).To(Equal("A"))
+		Expect(byKind.Entries[1].Key.(*Enum).Name()).To(Equal("B"))
+		byId := value.Field("byId").Value.(*Map)
+		Expect(byId.Entries[0].Key).To(Equal(int32(7)))
+		Expect(byId.Entries[0].Value).To(Equal("seven"))
+
+		_, err = StructFromJSON(node, []byte(`{"byKind": [[1]]}`))
+		Expect(PointerOfTestError(err)).To(Equal("/byKind/0"))
+	})
+
+	It("fills in struct and container defaults", func() {
+		schema := sematest.CompileSchema(`
+				enum Kind {
+					A = 1
+					B = 2
+				}
+				struct Inner {
+					1: required i32 a
+					2: optional string b = "bee"
+				}
+				struct Outer {
+					1: optional Inner inner = {"a": 5}
+					2: optional list<Kind> kinds = [Kind.B, Kind.A]
+					3: required map<string, i16> counts = {"x": 1}
+				}
+		`)
+		node, _ := FindStruct(schema, "Outer")
+
+		value, err := StructFromJSON(node, []byte(`{}`))
+		Expect(err).To(BeNil())
+
+		inner := value.Field("inner").Value.(*Struct)
+		Expect(inner.Field("a").Value).To(Equal(int32(5)))
+		Expect(inner.Field("b").Value).To(Equal("bee"))
+		kinds := value.Field("kinds").Value.([]interface{})
+		Expect(kinds[0].(*Enum).Name()).To(Equal("B"))
+		counts := value.Field("counts").Value.(*Map)
+		Expect(counts.Entries[0].Key).To(Equal("x"))
+		Expect(counts.Entries[0].Value).To(Equal(int16(1)))
+
+		data, err := Marshal(thrift.NewTCompactProtocolFactory(), value)
+		Expect(err).To(BeNil())
+		Expect(data).NotTo(BeEmpty())
+	})
+
+	It("encodes method arguments and results", func() {
+		_, method, _ := FindMethod(tree, "Users.getUser")
+
+		args, err := ArgsFromJSON(method, []byte(`{"id": 42}`))
+		Expect(err).To(BeNil())
+		_, err = ArgsFromJSON(method, []byte(`{}`))
+		Expect(err).NotTo(BeNil())
+
+		proto, _ := NewTestProtocol()
+		Expect(EncodeMessage(proto, "getUser", thrift.CALL, 3, args)).To(Succeed())
+
+		service, _ := FindService(tree, "Users")
+		message, err := DecodeMessage(proto, service)
+		Expect(err).To(BeNil())
+		Expect(message.Body.Field("id").Value).To(Equal(int64(42)))
+
+		result, err := ResultFromJSON(method, []byte(`{"notFound": {"message": "gone"}}`))
+		Expect(err).To(BeNil())
+		Expect(result.Fields).To(HaveLen(1))
+		Expect(result.Fields[0].Id).To(Equal(int16(1)))
+	})
+})
@@ -0,0 +1,525 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edmodo/frugal/parser"
+)
+
+// An error in a JSON document, at the given JSON pointer (RFC 6901). The
+// pointer is "" for the document itself.
+type JSONError struct {
+	Pointer string
+	Message string
+}
+
+func (this *JSONError) Error() string {
+	if this.Pointer == "" {
+		return fmt.Sprintf("(root): %s", this.Message)
+	}
+	return fmt.Sprintf("%s: %s", this.Pointer, this.Message)
+}
+
+func jsonError(pointer string, format string, args ...interface{}) error {
+	return &JSONError{
+		Pointer: pointer,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Appends a key or index to a JSON pointer (RFC 6901), escaping it as needed.
+func AppendPointer(pointer string, token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	token = strings.Replace(token, "/", "~1", -1)
+	return pointer + "/" + token
+}
+
+// Parses a JSON document, keeping numbers as json.Number so that 64-bit
+// integers are exact.
+func ParseJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON document")
+	}
+	return value, nil
+}
+
+// Builds a struct from a JSON object, keyed by field name. The result can be
+// passed to EncodeStruct().
+//
+// Required fields must be present, unless they have a default. Missing fields
+// with defaults are filled in from the schema. Enums can be given by name or
+// by value, and maps can be given either as objects (for scalar keys) or as
+// arrays of [key, value] pairs. Fields that are null are treated as missing.
+//
+// Errors are *JSONErrors, pointing to the offending value.
+func StructFromJSON(node *parser.StructNode, data []byte) (*Struct, error) {
+	value, err := ParseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return structFromJSON(node, value, "")
+}
+
+// Like StructFromJSON(), but for a JSON value that has already been parsed by
+// ParseJSON() or json.Unmarshal().
+func StructFromValue(node *parser.StructNode, value interface{}) (*Struct, error) {
+	return structFromJSON(node, value, "")
+}
+
+// Builds a method's argument struct from a JSON object.
+func ArgsFromJSON(method *parser.ServiceMethod, data []byte) (*Struct, error) {
+	return StructFromJSON(ArgsStruct(method), data)
+}
+
+// Builds a method's result struct from a JSON object. This should have one
+// field: "success" for the return value, or the name of an exception.
+func ResultFromJSON(method *parser.ServiceMethod, data []byte) (*Struct, error) {
+	return StructFromJSON(ResultStruct(method), data)
+}
+
+// Converts a value, as returned by ParseJSON() or json.Unmarshal(), to the
+// given type. The result can be passed to EncodeValue().
+func ValueFromJSON(ttype parser.Type, value interface{}) (interface{}, error) {
+	return valueFromJSON(ttype, value, "")
+}
+
+func structFromJSON(node *parser.StructNode, value interface{}, pointer string) (*Struct, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, jsonError(pointer, "expected an object for %s", node.Name.Identifier())
+	}
+
+	for name, _ := range object {
+		if _, ok := node.Names[name]; !ok {
+			return nil, jsonError(AppendPointer(pointer, name), "%s has no field %s", node.Name.Identifier(), name)
+		}
+	}
+
+	result := &Struct{
+		Node:   node,
+		Fields: []*Field{},
+	}
+	for _, field := range node.Fields {
+		name := field.Name.Identifier()
+
+		var fieldValue interface{}
+		if value, ok := object[name]; ok && value != nil {
+			var err error
+			fieldValue, err = valueFromJSON(field.Type, value, AppendPointer(pointer, name))
+			if err != nil {
+				return nil, err
+			}
+		} else if field.Default != nil {
+			fieldValue = ValueFromNode(field.Type, field.Default.(*parser.ValueNode))
+		} else if field.Spec == nil || field.Spec.Kind == parser.TOK_REQUIRED {
+			return nil, jsonError(pointer, "missing required field %s", name)
+		} else {
+			continue
+		}
+
+		result.Fields = append(result.Fields, &Field{
+			Id:     FieldId(field.Order),
+			Type:   TTypeOf(field.Type),
+			Name:   name,
+			Schema: field,
+			Value:  fieldValue,
+		})
+	}
+	return result, nil
+}
+
+func valueFromJSON(ttype parser.Type, value interface{}, pointer string) (interface{}, error) {
+	ttype, node := ttype.Resolve()
+	switch ttype.(type) {
+	case *parser.BuiltinType:
+		return builtinFromJSON(ttype.(*parser.BuiltinType), value, pointer)
+	case *parser.ListType:
+		ttype := ttype.(*parser.ListType)
+		array, ok := value.([]interface{})
+		if !ok {
+			return nil, jsonError(pointer, "expected an array")
+		}
+		values := []interface{}{}
+		for i, elem := range array {
+			elem, err := valueFromJSON(ttype.Inner, elem, AppendPointer(pointer, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elem)
+		}
+		return values, nil
+	case *parser.MapType:
+		return mapFromJSON(ttype.(*parser.MapType), value, pointer)
+	}
+
+	switch node.(type) {
+	case *parser.EnumNode:
+		return enumFromJSON(node.(*parser.EnumNode), value, pointer)
+	case *parser.StructNode:
+		return structFromJSON(node.(*parser.StructNode), value, pointer)
+	}
+	return nil, jsonError(pointer, "unsupported type %s", ttype.String())
+}
+
+// Converts a JSON number to an integer with the given number of bits.
+func intFromJSON(value interface{}, bits int, pointer string) (int64, error) {
+	var result int64
+	switch value.(type) {
+	case json.Number:
+		var err error
+		if result, err = strconv.ParseInt(string(value.(json.Number)), 10, bits); err != nil {
+			return 0, jsonError(pointer, "expected a %d-bit integer, got %s", bits, value)
+		}
+	case float64:
+		number := value.(float64)
+		limit := math.Ldexp(1, bits-1)
+		if number != math.Trunc(number) || number < -limit || number >= limit {
+			return 0, jsonError(pointer, "expected a %d-bit integer, got %v", bits, number)
+		}
+		result = int64(number)
+	default:
+		return 0, jsonError(pointer, "expected a %d-bit integer", bits)
+	}
+	return result, nil
+}
+
+func builtinFromJSON(ttype *parser.BuiltinType, value interface{}, pointer string) (interface{}, error) {
+	switch ttype.Tok.Kind {
+	case parser.TOK_BOOL:
+		if value, ok := value.(bool); ok {
+			return value, nil
+		}
+		return nil, jsonError(pointer, "expected a boolean")
+	case parser.TOK_I16:
+		result, err := intFromJSON(value, 16, pointer)
+		return int16(result), err
+	case parser.TOK_I32:
+		result, err := intFromJSON(value, 32, pointer)
+		return int32(result), err
+	case parser.TOK_I64:
+		return intFromJSON(value, 64, pointer)
+	case parser.TOK_DOUBLE:
+		switch value.(type) {
+		case json.Number:
+			result, err := value.(json.Number).Float64()
+			if err != nil {
+				return nil, jsonError(pointer, "expected a number, got %s", value)
+			}
+			return result, nil
+		case float64:
+			return value, nil
+		}
+		return nil, jsonError(pointer, "expected a number")
+	case parser.TOK_STRING:
+		if value, ok := value.(string); ok {
+			return value, nil
+		}
+		return nil, jsonError(pointer, "expected a string")
+	}
+	return nil, jsonError(pointer, "unsupported type %s", ttype.String())
+}
+
+func enumFromJSON(node *parser.EnumNode, value interface{}, pointer string) (*Enum, error) {
+	if name, ok := value.(string); ok {
+		entry, ok := node.Names[name]
+		if !ok {
+			return nil, jsonError(pointer, "%s is not a member of enum %s", name, node.Name.Identifier())
+		}
+		return &Enum{Node: node, Entry: entry, Value: entry.ConstVal}, nil
+	}
+
+	number, err := intFromJSON(value, 32, pointer)
+	if err != nil {
+		return nil, jsonError(pointer, "expected a member of enum %s", node.Name.Identifier())
+	}
+	enum := newEnum(node, int32(number))
+	if enum.Entry == nil {
+		return nil, jsonError(pointer, "%d is not a member of enum %s", number, node.Name.Identifier())
+	}
+	return enum, nil
+}
+
+// Converts an object key to a JSON value of the given scalar type, so it can
+// go through valueFromJSON().
+func keyFromJSON(ttype parser.Type, key string) interface{} {
+	resolved, node := ttype.Resolve()
+	if _, ok := node.(*parser.EnumNode); ok {
+		if _, err := strconv.ParseInt(key, 10, 32); err == nil {
+			return json.Number(key)
+		}
+		return key
+	}
+	if builtin, ok := resolved.(*parser.BuiltinType); ok {
+		switch builtin.Tok.Kind {
+		case parser.TOK_I16, parser.TOK_I32, parser.TOK_I64, parser.TOK_DOUBLE:
+			return json.Number(key)
+		case parser.TOK_BOOL:
+			if value, err := strconv.ParseBool(key); err == nil {
+				return value
+			}
+		}
+	}
+	return key
+}
+
+func mapFromJSON(ttype *parser.MapType, value interface{}, pointer string) (*Map, error) {
+	result := &Map{
+		KeyType: ttype.Key,
+		Entries: []*MapEntry{},
+	}
+
+	switch value.(type) {
+	case map[string]interface{}:
+		object := value.(map[string]interface{})
+
+		// Go randomizes map order, but encoding should be deterministic.
+		keys := []string{}
+		for key, _ := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			entryPointer := AppendPointer(pointer, key)
+			keyValue, err := valueFromJSON(ttype.Key, keyFromJSON(ttype.Key, key), entryPointer)
+			if err != nil {
+				return nil, err
+			}
+			entryValue, err := valueFromJSON(ttype.Value, object[key], entryPointer)
+			if err != nil {
+				return nil, err
+			}
+			result.Entries = append(result.Entries, &MapEntry{keyValue, entryValue})
+		}
+	case []interface{}:
+		for i, pair := range value.([]interface{}) {
+			entryPointer := AppendPointer(pointer, strconv.Itoa(i))
+			pair, ok := pair.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, jsonError(entryPointer, "expected a [key, value] pair")
+			}
+			keyValue, err := valueFromJSON(ttype.Key, pair[0], AppendPointer(entryPointer, "0"))
+			if err != nil {
+				return nil, err
+			}
+			entryValue, err := valueFromJSON(ttype.Value, pair[1], AppendPointer(entryPointer, "1"))
+			if err != nil {
+				return nil, err
+			}
+			result.Entries = append(result.Entries, &MapEntry{keyValue, entryValue})
+		}
+	default:
+		return nil, jsonError(pointer, "expected an object or an array of [key, value] pairs")
+	}
+	return result, nil
+}
+
+// Converts a constant evaluated by semantic analysis, such as a field
+// default, to a value of the given type. ValueToJSON() gives its JSON form.
+func ValueFromNode(ttype parser.Type, value *parser.ValueNode) interface{} {
+	ttype, node := ttype.Resolve()
+	switch value.Type {
+	case parser.TOK_LIST:
+		list := ttype.(*parser.ListType)
+		values := []interface{}{}
+		for _, elem := range value.Result.(*parser.ListNode).Values {
+			values = append(values, ValueFromNode(list.Inner, elem))
+		}
+		return values
+	case parser.TOK_MAP:
+		mapType := ttype.(*parser.MapType)
+		result := &Map{
+			KeyType: mapType.Key,
+			Entries: []*MapEntry{},
+		}
+		for _, entry := range value.Result.(*parser.MapNode).Entries {
+			result.Entries = append(result.Entries, &MapEntry{
+				Key:   ValueFromNode(mapType.Key, entry.KeyVal),
+				Value: ValueFromNode(mapType.Value, entry.ValueVal),
+			})
+		}
+		return result
+	case parser.TOK_ENUM:
+		entry := value.Result.(*parser.EnumEntry)
+		return &Enum{Node: node.(*parser.EnumNode), Entry: entry, Value: entry.ConstVal}
+	case parser.TOK_STRUCT:
+		return structFromInitializer(node.(*parser.StructNode), value.Result.(parser.StructInitializer))
+	}
+	return value.Result
+}
+
+func structFromInitializer(node *parser.StructNode, init parser.StructInitializer) *Struct {
+	result := &Struct{
+		Node:   node,
+		Fields: []*Field{},
+	}
+	for _, field := range node.Fields {
+		value, ok := init[field]
+		if !ok {
+			if field.Default == nil {
+				continue
+			}
+			value = field.Default.(*parser.ValueNode)
+		}
+		result.Fields = append(result.Fields, &Field{
+			Id:     FieldId(field.Order),
+			Type:   TTypeOf(field.Type),
+			Name:   field.Name.Identifier(),
+			Schema: field,
+			Value:  ValueFromNode(field.Type, value),
+		})
+	}
+	return result
+}
+
+// A JSON object that keeps its members in order, so that struct fields print
+// in schema order.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+func (this jsonObject) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString("{")
+	for i, member := range this {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		key, err := json.Marshal(member.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(member.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteString(":")
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// Converts a struct to a value that encoding/json can marshal, in the same
+// format StructFromJSON() accepts. Unknown fields are keyed by their id.
+func StructToJSON(value *Struct) interface{} {
+	object := jsonObject{}
+	for _, field := range value.Fields {
+		key := field.Name
+		if key == "" {
+			key = strconv.Itoa(int(field.Id))
+		}
+		object = append(object, jsonMember{key, ValueToJSON(field.Value)})
+	}
+	return object
+}
+
+// Converts a decoded value to a value that encoding/json can marshal. Enums
+// become their names (or numbers, if they are not in the enum), and maps whose
+// key type is a string, integer, bool, or enum become objects. Other maps
+// become arrays of [key, value] pairs, even when empty. Maps decoded without
+// a schema are objects if all of their keys can be object keys.
+func ValueToJSON(value interface{}) interface{} {
+	switch value.(type) {
+	case *Struct:
+		return StructToJSON(value.(*Struct))
+	case *Enum:
+		enum := value.(*Enum)
+		if enum.Entry == nil {
+			return enum.Value
+		}
+		return enum.Name()
+	case []interface{}:
+		values := []interface{}{}
+		for _, elem := range value.([]interface{}) {
+			values = append(values, ValueToJSON(elem))
+		}
+		return values
+	case *List:
+		return ValueToJSON(value.(*List).Elems)
+	case *Map:
+		return mapToJSON(value.(*Map))
+	}
+	return value
+}
+
+// Returns the object key for a map key, or false if it cannot be one.
+func jsonKey(key interface{}) (string, bool) {
+	switch key.(type) {
+	case string:
+		return key.(string), true
+	case *Enum:
+		return fmt.Sprintf("%v", ValueToJSON(key)), true
+	case bool, byte, int16, int32, int64:
+		return fmt.Sprintf("%v", key), true
+	}
+	return "", false
+}
+
+// Returns whether maps with the given key type are JSON objects.
+func isObjectKeyType(ttype parser.Type) bool {
+	resolved, node := ttype.Resolve()
+	if _, ok := node.(*parser.EnumNode); ok {
+		return true
+	}
+	if builtin, ok := resolved.(*parser.BuiltinType); ok {
+		switch builtin.Tok.Kind {
+		case parser.TOK_STRING, parser.TOK_I16, parser.TOK_I32, parser.TOK_I64, parser.TOK_BOOL:
+			return true
+		}
+	}
+	return false
+}
+
+func mapToJSON(value *Map) interface{} {
+	if value.KeyType == nil || isObjectKeyType(value.KeyType) {
+		object := jsonObject{}
+		for _, entry := range value.Entries {
+			key, ok := jsonKey(entry.Key)
+			if !ok {
+				object = nil
+				break
+			}
+			object = append(object, jsonMember{key, ValueToJSON(entry.Value)})
+		}
+		if object != nil {
+			return object
+		}
+	}
+
+	pairs := []interface{}{}
+	for _, entry := range value.Entries {
+		pairs = append(pairs, []interface{}{ValueToJSON(entry.Key), ValueToJSON(entry.Value)})
+	}
+	return pairs
+}
@@ -0,0 +1,250 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Options for NewRandom(). Zero values select the defaults.
+type RandomOptions struct {
+	// The chance, from 0 to 1, that an optional field is set. The default is
+	// 0.5. If negative, optional fields are never set.
+	OptionalRate float64
+
+	// The most elements in a list or map. The default is 4.
+	MaxElements int
+
+	// The longest string, in characters. The default is 16.
+	MaxStringLength int
+
+	// How deeply lists, maps, and structs may nest before lists and maps are
+	// left empty and optional fields are left unset. Other fields are always
+	// set, which is safe since sema rejects cyclic structs. The default is 4.
+	MaxDepth int
+}
+
+// Generates random values that match a schema, for fuzzing servers and
+// testing serializers. Fields not marked optional are always set, as Thrift
+// writes them, and optional fields are set at random. Enums only take
+// values from their entries, map keys are distinct, and strings are valid
+// UTF-8. Doubles are always finite, so that values compare equal after a
+// round trip.
+//
+// The same seed and options always generate the same values. A Random is not
+// safe for concurrent use.
+type Random struct {
+	rand    *rand.Rand
+	options RandomOptions
+}
+
+func NewRandom(seed int64, options *RandomOptions) *Random {
+	random := &Random{
+		rand:    rand.New(rand.NewSource(seed)),
+		options: *options,
+	}
+	if random.options.OptionalRate == 0 {
+		random.options.OptionalRate = 0.5
+	}
+	if random.options.MaxElements == 0 {
+		random.options.MaxElements = 4
+	}
+	if random.options.MaxStringLength == 0 {
+		random.options.MaxStringLength = 16
+	}
+	if random.options.MaxDepth == 0 {
+		random.options.MaxDepth = 4
+	}
+	return random
+}
+
+// Returns a random struct. Only valid after semantic analysis.
+func (this *Random) Struct(node *parser.StructNode) *Struct {
+	return this.randomStruct(node, 0)
+}
+
+// Returns a random value of the given type, which must not be void.
+func (this *Random) Value(ttype parser.Type) interface{} {
+	return this.randomValue(ttype, 0)
+}
+
+// Returns random arguments for a method.
+func (this *Random) Args(method *parser.ServiceMethod) *Struct {
+	return this.Struct(ArgsStruct(method))
+}
+
+// Returns a random result for a method: either a return value or one of its
+// exceptions. The result of a void method that throws nothing is empty.
+func (this *Random) Result(method *parser.ServiceMethod) *Struct {
+	node := ResultStruct(method)
+	result := &Struct{
+		Node:   node,
+		Fields: []*Field{},
+	}
+	if len(node.Fields) > 0 {
+		field := node.Fields[this.rand.Intn(len(node.Fields))]
+		result.Fields = append(result.Fields, this.randomField(field, 0))
+	}
+	return result
+}
+
+// Returns a random struct, encoded with the given protocol.
+func (this *Random) Marshal(factory thrift.TProtocolFactory, node *parser.StructNode) ([]byte, error) {
+	return Marshal(factory, this.Struct(node))
+}
+
+func (this *Random) randomStruct(node *parser.StructNode, depth int) *Struct {
+	result := &Struct{
+		Node:   node,
+		Fields: []*Field{},
+	}
+	for _, field := range node.Fields {
+		if field.Spec != nil && field.Spec.Kind == parser.TOK_OPTIONAL {
+			if depth >= this.options.MaxDepth || this.rand.Float64() >= this.options.OptionalRate {
+				continue
+			}
+		}
+		result.Fields = append(result.Fields, this.randomField(field, depth))
+	}
+	return result
+}
+
+func (this *Random) randomField(field *parser.StructField, depth int) *Field {
+	return &Field{
+		Id:     FieldId(field.Order),
+		Type:   TTypeOf(field.Type),
+		Name:   field.Name.Identifier(),
+		Schema: field,
+		Value:  this.randomValue(field.Type, depth+1),
+	}
+}
+
+func (this *Random) randomValue(ttype parser.Type, depth int) interface{} {
+	ttype, node := ttype.Resolve()
+	switch ttype.(type) {
+	case *parser.BuiltinType:
+		return this.randomBuiltin(ttype.(*parser.BuiltinType))
+
+	case *parser.ListType:
+		ttype := ttype.(*parser.ListType)
+		values := []interface{}{}
+		for i := this.randomLength(depth); i > 0; i-- {
+			values = append(values, this.randomValue(ttype.Inner, depth+1))
+		}
+		return values
+
+	case *parser.MapType:
+		return this.randomMap(ttype.(*parser.MapType), depth)
+	}
+
+	switch node.(type) {
+	case *parser.EnumNode:
+		node := node.(*parser.EnumNode)
+		if len(node.Entries) == 0 {
+			return newEnum(node, 0)
+		}
+		entry := node.Entries[this.rand.Intn(len(node.Entries))]
+		return &Enum{Node: node, Entry: entry, Value: entry.ConstVal}
+
+	case *parser.StructNode:
+		return this.randomStruct(node.(*parser.StructNode), depth)
+	}
+	return nil
+}
+
+// Returns a number of elements for a list or map.
+func (this *Random) randomLength(depth int) int {
+	if depth >= this.options.MaxDepth {
+		return 0
+	}
+	return this.rand.Intn(this.options.MaxElements + 1)
+}
+
+// Keys that come up equal are dropped, so a map may have fewer entries than
+// were asked for. Keys are compared by their JSON form, since they may be
+// structs or lists.
+func (this *Random) randomMap(ttype *parser.MapType, depth int) *Map {
+	result := &Map{
+		KeyType: ttype.Key,
+		Entries: []*MapEntry{},
+	}
+	seen := map[string]bool{}
+	for i := this.randomLength(depth); i > 0; i-- {
+		key := this.randomValue(ttype.Key, depth+1)
+		value := this.randomValue(ttype.Value, depth+1)
+
+		data, _ := json.Marshal(ValueToJSON(key))
+		if seen[string(data)] {
+			continue
+		}
+		seen[string(data)] = true
+		result.Entries = append(result.Entries, &MapEntry{Key: key, Value: value})
+	}
+	return result
+}
+
+// Characters for random strings: ASCII, plus a few that take more than one
+// byte in UTF-8.
+var randomRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-.é日本😀")
+
+func (this *Random) randomBuiltin(ttype *parser.BuiltinType) interface{} {
+	switch ttype.Tok.Kind {
+	case parser.TOK_BOOL:
+		return this.rand.Intn(2) == 1
+	case parser.TOK_I16:
+		return int16(this.randomInt(16))
+	case parser.TOK_I32:
+		return int32(this.randomInt(32))
+	case parser.TOK_I64:
+		return this.randomInt(64)
+	case parser.TOK_DOUBLE:
+		return this.randomDouble()
+	case parser.TOK_STRING:
+		runes := make([]rune, this.rand.Intn(this.options.MaxStringLength+1))
+		for i := range runes {
+			runes[i] = randomRunes[this.rand.Intn(len(randomRunes))]
+		}
+		return string(runes)
+	}
+	return nil
+}
+
+// Returns a random integer that fits in the given number of bits. Edge cases
+// are picked a quarter of the time, since they are where serializers tend to
+// break. Otherwise, small numbers are as likely as large ones.
+func (this *Random) randomInt(bits int) int64 {
+	if this.rand.Intn(4) == 0 {
+		min := int64(-1) << uint(bits-1)
+		edges := []int64{0, 1, -1, min, -(min + 1)}
+		return edges[this.rand.Intn(len(edges))]
+	}
+	value := this.rand.Int63() >> uint(63-this.rand.Intn(bits))
+	if this.rand.Intn(2) == 0 {
+		value = -value
+	}
+	return value
+}
+
+func (this *Random) randomDouble() float64 {
+	if this.rand.Intn(4) == 0 {
+		edges := []float64{0, 1, -1, 0.5, math.MaxFloat64, -math.MaxFloat64, math.SmallestNonzeroFloat64}
+		return edges[this.rand.Intn(len(edges))]
+	}
+	return this.rand.NormFloat64() * math.Pow(10, float64(this.rand.Intn(12)))
+}
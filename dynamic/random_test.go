@@ -0,0 +1,146 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Returns the JSON form of a value, for comparing values.
+func RandomTestJSON(value interface{}) string {
+	data, err := json.Marshal(ValueToJSON(value))
+	Expect(err).To(BeNil())
+	return string(data)
+}
+
+var _ = Describe("Random", func() {
+	var tree *parser.ParseTree
+	var user *parser.StructNode
+
+	BeforeEach(func() {
+		tree = ParseDefaultTestSchema()
+		user, _ = FindStruct(tree, "User")
+	})
+
+	It("is deterministic from the seed", func() {
+		a := NewRandom(42, &RandomOptions{})
+		b := NewRandom(42, &RandomOptions{})
+		c := NewRandom(43, &RandomOptions{})
+
+		same, different := true, false
+		for i := 0; i < 20; i++ {
+			x, y, z := RandomTestJSON(a.Struct(user)), RandomTestJSON(b.Struct(user)), RandomTestJSON(c.Struct(user))
+			same = same && x == y
+			different = different || x != z
+		}
+		Expect(same).To(BeTrue())
+		Expect(different).To(BeTrue())
+	})
+
+	It("generates values that match the schema", func() {
+		random := NewRandom(1, &RandomOptions{})
+		set := map[string]int{}
+		for i := 0; i < 200; i++ {
+			value := random.Struct(user)
+			Expect(value.Field("id").Value).To(BeAssignableToTypeOf(int64(0)))
+			Expect(utf8.ValidString(value.Field("name").Value.(string))).To(BeTrue())
+			for _, field := range value.Fields {
+				set[field.Name]++
+			}
+
+			if field := value.Field("favorite"); field != nil {
+				Expect(field.Value.(*Enum).Entry).NotTo(BeNil())
+			}
+			if field := value.Field("palette"); field != nil {
+				Expect(len(field.Value.([]interface{}))).To(BeNumerically("<=", 4))
+				for _, color := range field.Value.([]interface{}) {
+					Expect(color.(*Enum).Entry).NotTo(BeNil())
+				}
+			}
+			if field := value.Field("places"); field != nil {
+				for _, entry := range field.Value.(*Map).Entries {
+					point := entry.Value.(*Struct)
+					Expect(point.Field("x")).NotTo(BeNil())
+					Expect(point.Field("y")).NotTo(BeNil())
+				}
+			}
+
+			// Every value can be encoded, and decodes back to the same value.
+			data, err := Marshal(thrift.NewTCompactProtocolFactory(), value)
+			Expect(err).To(BeNil())
+			buffer := thrift.NewTMemoryBuffer()
+			buffer.Write(data)
+			decoded, err := DecodeStruct(thrift.NewTCompactProtocol(buffer), user)
+			Expect(err).To(BeNil())
+			Expect(RandomTestJSON(decoded)).To(Equal(RandomTestJSON(value)))
+		}
+
+		// Required fields are always set, and optional fields sometimes.
+		Expect(set["id"]).To(Equal(200))
+		Expect(set["name"]).To(Equal(200))
+		Expect(set["score"]).To(BeNumerically(">", 50))
+		Expect(set["score"]).To(BeNumerically("<", 150))
+	})
+
+	It("bounds nesting", func() {
+		random := NewRandom(7, &RandomOptions{MaxDepth: 2, MaxElements: 10})
+		for i := 0; i < 50; i++ {
+			value := random.Struct(user)
+			if field := value.Field("grid"); field != nil {
+				for _, row := range field.Value.([]interface{}) {
+					Expect(row).To(BeEmpty())
+				}
+			}
+		}
+
+		random = NewRandom(7, &RandomOptions{OptionalRate: -1})
+		value := random.Struct(user)
+		Expect(value.Fields).To(HaveLen(2))
+	})
+
+	It("generates method arguments and results", func() {
+		_, method, err := FindMethod(tree, "Users.getUser")
+		Expect(err).To(BeNil())
+
+		random := NewRandom(3, &RandomOptions{})
+		args := random.Args(method)
+		Expect(args.Field("id").Value).To(BeAssignableToTypeOf(int64(0)))
+
+		names := map[string]bool{}
+		for i := 0; i < 20; i++ {
+			result := random.Result(method)
+			Expect(result.Fields).To(HaveLen(1))
+			names[result.Fields[0].Name] = true
+		}
+		Expect(names).To(Equal(map[string]bool{"success": true, "notFound": true}))
+
+		_, touch, err := FindMethod(tree, "Users.touch")
+		Expect(err).To(BeNil())
+		Expect(random.Result(touch).Fields).To(BeEmpty())
+	})
+
+	It("encodes random values", func() {
+		data, err := NewRandom(5, &RandomOptions{}).Marshal(thrift.NewTBinaryProtocolFactoryDefault(), user)
+		Expect(err).To(BeNil())
+		again, err := NewRandom(5, &RandomOptions{}).Marshal(thrift.NewTBinaryProtocolFactoryDefault(), user)
+		Expect(err).To(BeNil())
+		Expect(data).To(Equal(again))
+	})
+})
@@ -0,0 +1,253 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"fmt"
+	"strings"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Returns the wire type of a type expression, looking past typedefs. Enums
+// are sent as i32s. Only valid after semantic analysis.
+func TTypeOf(ttype parser.Type) thrift.TType {
+	ttype, node := ttype.Resolve()
+	switch ttype.(type) {
+	case *parser.BuiltinType:
+		ttype := ttype.(*parser.BuiltinType)
+		switch ttype.Tok.Kind {
+		case parser.TOK_BOOL:
+			return thrift.BOOL
+		case parser.TOK_DOUBLE:
+			return thrift.DOUBLE
+		case parser.TOK_I16:
+			return thrift.I16
+		case parser.TOK_I32:
+			return thrift.I32
+		case parser.TOK_I64:
+			return thrift.I64
+		case parser.TOK_STRING:
+			return thrift.STRING
+		case parser.TOK_VOID:
+			return thrift.VOID
+		}
+	case *parser.ListType:
+		return thrift.LIST
+	case *parser.MapType:
+		return thrift.MAP
+	}
+
+	switch node.(type) {
+	case *parser.EnumNode:
+		return thrift.I32
+	case *parser.StructNode:
+		return thrift.STRUCT
+	}
+	return thrift.STOP
+}
+
+// Returns the id of a struct field or method argument.
+func FieldId(order *parser.Token) int16 {
+	return int16(order.IntLiteral())
+}
+
+// Finds a named definition, either in the tree itself ("Name") or in one of
+// its includes ("package.Name").
+func lookup(tree *parser.ParseTree, name string) (parser.Node, error) {
+	if index := strings.Index(name, "."); index != -1 {
+		include, ok := tree.Includes[name[:index]]
+		if !ok || include.Tree == nil {
+			return nil, fmt.Errorf("unknown package: %s", name[:index])
+		}
+		tree, name = include.Tree, name[index+1:]
+	}
+
+	node, ok := tree.Names[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown name: %s", name)
+	}
+	return node, nil
+}
+
+// Finds a struct or exception by name. Only valid after semantic analysis.
+func FindStruct(tree *parser.ParseTree, name string) (*parser.StructNode, error) {
+	node, err := lookup(tree, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Allow typedefs of structs, too.
+	if typedef, ok := node.(*parser.TypedefNode); ok {
+		_, node = typedef.Type.Resolve()
+	}
+	structNode, ok := node.(*parser.StructNode)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a struct or exception", name)
+	}
+	return structNode, nil
+}
+
+// Finds a service by name. Only valid after semantic analysis.
+func FindService(tree *parser.ParseTree, name string) (*parser.ServiceNode, error) {
+	node, err := lookup(tree, name)
+	if err != nil {
+		return nil, err
+	}
+	service, ok := node.(*parser.ServiceNode)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", name)
+	}
+	return service, nil
+}
+
+// Finds a method in a service, including methods inherited from base
+// services.
+func FindServiceMethod(service *parser.ServiceNode, name string) *parser.ServiceMethod {
+	for _, current := range service.InheritanceChain() {
+		for _, method := range current.Methods {
+			if method.Name.Identifier() == name {
+				return method
+			}
+		}
+	}
+	return nil
+}
+
+// Finds a method given as "Service.method" (or "package.Service.method").
+// Only valid after semantic analysis.
+func FindMethod(tree *parser.ParseTree, name string) (*parser.ServiceNode, *parser.ServiceMethod, error) {
+	index := strings.LastIndex(name, ".")
+	if index == -1 {
+		return nil, nil, fmt.Errorf("expected Service.method, got: %s", name)
+	}
+
+	service, err := FindService(tree, name[:index])
+	if err != nil {
+		return nil, nil, err
+	}
+	method := FindServiceMethod(service, name[index+1:])
+	if method == nil {
+		return nil, nil, fmt.Errorf("service %s has no method %s", name[:index], name[index+1:])
+	}
+	return service, method, nil
+}
+
+func newIdentifier(loc parser.Location, name string) *parser.Token {
+	return &parser.Token{Kind: parser.TOK_IDENTIFIER, Data: name, Loc: loc}
+}
+
+// Returns a struct describing a method's arguments, as they are sent in a
+// CALL or ONEWAY message. The struct is named "method_args", like in
+// Thrift-generated code.
+func ArgsStruct(method *parser.ServiceMethod) *parser.StructNode {
+	loc := method.Name.Loc
+	fields := []*parser.StructField{}
+	for _, arg := range method.Args {
+		fields = append(fields, &parser.StructField{
+			Order:       arg.Order,
+			Type:        arg.Type,
+			Name:        arg.Name,
+			Annotations: arg.Annotations,
+			Doc:         arg.Doc,
+		})
+	}
+	return newStruct(loc, method.Name.Identifier()+"_args", fields)
+}
+
+// Returns a struct describing a method's result, as it is sent in a REPLY
+// message. The return value is field 0, named "success", unless the method
+// returns void; each exception the method throws is a field after that.
+// Every field is optional, since only one is ever set.
+func ResultStruct(method *parser.ServiceMethod) *parser.StructNode {
+	loc := method.Name.Loc
+	optional := &parser.Token{Kind: parser.TOK_OPTIONAL, Loc: loc}
+
+	fields := []*parser.StructField{}
+	if !method.ReturnsVoid() {
+		fields = append(fields, &parser.StructField{
+			Order: &parser.Token{Kind: parser.TOK_LITERAL_INT, Data: int64(0), Loc: loc},
+			Spec:  optional,
+			Type:  method.ReturnType,
+			Name:  newIdentifier(loc, "success"),
+		})
+	}
+	for _, throws := range method.Throws {
+		fields = append(fields, &parser.StructField{
+			Order: throws.Order,
+			Spec:  optional,
+			Type:  throws.Type,
+			Name:  throws.Name,
+		})
+	}
+	return newStruct(loc, method.Name.Identifier()+"_result", fields)
+}
+
+// Returns a struct describing the body of an EXCEPTION message, which is a
+// TApplicationException: a message (field 1) and an exception type (field 2).
+func ExceptionStruct() *parser.StructNode {
+	loc := parser.Location{}
+	optional := &parser.Token{Kind: parser.TOK_OPTIONAL, Loc: loc}
+	fields := []*parser.StructField{
+		&parser.StructField{
+			Order: &parser.Token{Kind: parser.TOK_LITERAL_INT, Data: int64(1), Loc: loc},
+			Spec:  optional,
+			Type:  &parser.BuiltinType{Tok: &parser.Token{Kind: parser.TOK_STRING, Loc: loc}},
+			Name:  newIdentifier(loc, "message"),
+		},
+		&parser.StructField{
+			Order: &parser.Token{Kind: parser.TOK_LITERAL_INT, Data: int64(2), Loc: loc},
+			Spec:  optional,
+			Type:  &parser.BuiltinType{Tok: &parser.Token{Kind: parser.TOK_I32, Loc: loc}},
+			Name:  newIdentifier(loc, "type"),
+		},
+	}
+	return newStruct(loc, "TApplicationException", fields)
+}
+
+func newStruct(loc parser.Location, name string, fields []*parser.StructField) *parser.StructNode {
+	node := parser.NewStructNode(
+		loc,
+		&parser.Token{Kind: parser.TOK_STRUCT, Loc: loc},
+		newIdentifier(loc, name),
+		fields,
+	)
+	for _, field := range fields {
+		node.Names[field.Name.Identifier()] = field
+	}
+	return node
+}
+
+func argsSignature(args []*parser.ServiceMethodArg) string {
+	parts := []string{}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprintf("%d: %s %s", FieldId(arg.Order), arg.Type.String(), arg.Name.Identifier()))
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// Returns a method's signature as it would be written in IDL, for example:
+//
+//     User getUser(1: UserId id) throws (1: NotFound notFound)
+func MethodSignature(method *parser.ServiceMethod) string {
+	signature := method.ReturnType.String() + " " + method.Name.Identifier() + argsSignature(method.Args)
+	if method.OneWay != nil {
+		signature = "oneway " + signature
+	}
+	if len(method.Throws) > 0 {
+		signature += " throws " + argsSignature(method.Throws)
+	}
+	return signature
+}
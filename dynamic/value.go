@@ -0,0 +1,145 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package dynamic
+
+import (
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A decoded value is always one of:
+//   bool
+//   byte
+//   int16
+//   int32
+//   int64
+//   float64
+//   string
+//   *Enum
+//   []interface{} (for lists and sets)
+//   *List (for lists and sets decoded without a schema)
+//   *Map
+//   *Struct
+
+// A decoded struct, exception, or method argument/result struct.
+type Struct struct {
+	// The schema of the struct, or nil if the struct was not described by the
+	// schema (for example, if it was nested inside an unknown field).
+	Node *parser.StructNode
+
+	// Fields in the order they were read.
+	Fields []*Field
+}
+
+// Returns the field with the given name, or nil if it was not present.
+func (this *Struct) Field(name string) *Field {
+	for _, field := range this.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// Returns the field with the given id, or nil if it was not present.
+func (this *Struct) FieldById(id int16) *Field {
+	for _, field := range this.Fields {
+		if field.Id == id {
+			return field
+		}
+	}
+	return nil
+}
+
+// Returns the fields that were not described by the schema.
+func (this *Struct) UnknownFields() []*Field {
+	fields := []*Field{}
+	for _, field := range this.Fields {
+		if field.Unknown {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+type Field struct {
+	Id int16
+
+	// The type of the field on the wire.
+	Type thrift.TType
+
+	// The field name and schema, or "" and nil if the id is not in the schema.
+	Name   string
+	Schema *parser.StructField
+
+	// True if the field could not be decoded with the schema, either because
+	// the id is not in the schema or because the wire type does not match it.
+	// The value is then decoded from the wire types alone, so it contains no
+	// enums and no struct schemas.
+	Unknown bool
+
+	Value interface{}
+}
+
+// A decoded enum value.
+type Enum struct {
+	// The enum type.
+	Node *parser.EnumNode
+
+	// The matching entry, or nil if the value is not in the enum.
+	Entry *parser.EnumEntry
+
+	Value int32
+}
+
+// Returns the name of the entry, or "" if the value is not in the enum.
+func (this *Enum) Name() string {
+	if this.Entry == nil {
+		return ""
+	}
+	return this.Entry.Name.Identifier()
+}
+
+// A list or set decoded without a schema. The wire types are kept, so that
+// it can be encoded again as it was read, even when empty.
+type List struct {
+	// thrift.LIST or thrift.SET.
+	WireType thrift.TType
+
+	// The type of the elements on the wire.
+	ElemWireType thrift.TType
+
+	Elems []interface{}
+}
+
+// A decoded map. Entries are kept in the order they were read, since keys
+// may not be comparable.
+type Map struct {
+	// The key type, or nil if the map was decoded without a schema. It decides
+	// the map's JSON form (see ValueToJSON()).
+	KeyType parser.Type
+
+	// The key and value types on the wire, if the map was decoded. Without a
+	// schema, they are encoded as they were read; if they are zero
+	// (thrift.STOP), they are guessed from the entries.
+	KeyWireType   thrift.TType
+	ValueWireType thrift.TType
+
+	Entries []*MapEntry
+}
+
+type MapEntry struct {
+	Key   interface{}
+	Value interface{}
+}
@@ -0,0 +1,232 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package jsonschema
+
+import (
+	"math"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A JSON Schema, or part of one, ready to be passed to json.Marshal().
+type Schema map[string]interface{}
+
+// Returns the "$ref" for a struct, enum, or typedef, given the tree it is
+// defined in.
+type RefFunc func(tree *parser.ParseTree, name string) string
+
+// Builds schemas for the definitions and types in one parse tree. Schemas
+// describe values as the dynamic package reads and writes them in JSON:
+//
+//     struct, exception   object, keyed by field name
+//     enum                string, the name of an entry
+//     list                array
+//     map                 object, if keys are strings, integers, bools, or
+//                         enums; otherwise, an array of [key, value] pairs
+//
+// References to named types go through a RefFunc, so that definitions can
+// live wherever the document keeps them.
+type Builder struct {
+	tree *parser.ParseTree
+	ref  RefFunc
+}
+
+// The tree must have been through semantic analysis.
+func NewBuilder(tree *parser.ParseTree, ref RefFunc) *Builder {
+	return &Builder{
+		tree: tree,
+		ref:  ref,
+	}
+}
+
+// Returns a schema for each struct, exception, enum, and typedef in the
+// tree, keyed by name. Doc comments become descriptions.
+func (this *Builder) Definitions() map[string]Schema {
+	defs := map[string]Schema{}
+	for _, node := range this.tree.Nodes {
+		switch node.(type) {
+		case *parser.StructNode:
+			node := node.(*parser.StructNode)
+			defs[node.Name.Identifier()] = this.Struct(node)
+
+		case *parser.EnumNode:
+			node := node.(*parser.EnumNode)
+			defs[node.Name.Identifier()] = this.Enum(node)
+
+		case *parser.TypedefNode:
+			node := node.(*parser.TypedefNode)
+			defs[node.Name.Identifier()] = describe(this.Type(node.Type), node.Doc)
+		}
+	}
+	return defs
+}
+
+// Returns the schema of a struct or exception. Fields that Thrift writes
+// unless they are marked optional are required, unless they have a default.
+// Defaults are included. Fields not in the struct are not allowed.
+func (this *Builder) Struct(node *parser.StructNode) Schema {
+	properties := Schema{}
+	required := []string{}
+	for _, field := range node.Fields {
+		name := field.Name.Identifier()
+		property := this.Type(field.Type)
+		if value, ok := field.Default.(*parser.ValueNode); ok && value != nil {
+			property["default"] = dynamic.ValueToJSON(dynamic.ValueFromNode(field.Type, value))
+		} else if field.Spec == nil || field.Spec.Kind == parser.TOK_REQUIRED {
+			required = append(required, name)
+		}
+		properties[name] = describe(property, field.Doc)
+	}
+
+	schema := Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return describe(schema, node.Doc)
+}
+
+// Returns the schema of an enum, whose values are entry names.
+func (this *Builder) Enum(node *parser.EnumNode) Schema {
+	schema := Schema{
+		"type": "string",
+		"enum": enumNames(node),
+	}
+	return describe(schema, node.Doc)
+}
+
+// Adds a description to a schema, if there is one.
+func describe(schema Schema, doc string) Schema {
+	if doc != "" {
+		schema["description"] = doc
+	}
+	return schema
+}
+
+func enumNames(node *parser.EnumNode) []string {
+	names := []string{}
+	for _, entry := range node.Entries {
+		names = append(names, entry.Name.Identifier())
+	}
+	return names
+}
+
+// Returns the schema of a type expression. Named types become references.
+// Void becomes {"type": "null"}.
+func (this *Builder) Type(ttype parser.Type) Schema {
+	switch ttype.(type) {
+	case *parser.BuiltinType:
+		return builtinSchema(ttype.(*parser.BuiltinType))
+
+	case *parser.ListType:
+		ttype := ttype.(*parser.ListType)
+		return Schema{
+			"type":  "array",
+			"items": this.Type(ttype.Inner),
+		}
+
+	case *parser.MapType:
+		return this.mapSchema(ttype.(*parser.MapType))
+
+	case *parser.NameProxyNode:
+		ttype := ttype.(*parser.NameProxyNode)
+		tree := ttype.Import
+		if tree == nil {
+			tree = this.tree
+		}
+		return Schema{
+			"$ref": this.ref(tree, definitionName(ttype.Binding)),
+		}
+	}
+	return Schema{}
+}
+
+// Returns the name of a struct, enum, or typedef.
+func definitionName(node parser.Node) string {
+	switch node.(type) {
+	case *parser.StructNode:
+		return node.(*parser.StructNode).Name.Identifier()
+	case *parser.EnumNode:
+		return node.(*parser.EnumNode).Name.Identifier()
+	case *parser.TypedefNode:
+		return node.(*parser.TypedefNode).Name.Identifier()
+	}
+	return ""
+}
+
+func builtinSchema(ttype *parser.BuiltinType) Schema {
+	switch ttype.Tok.Kind {
+	case parser.TOK_BOOL:
+		return Schema{"type": "boolean"}
+	case parser.TOK_I16:
+		return Schema{"type": "integer", "minimum": math.MinInt16, "maximum": math.MaxInt16}
+	case parser.TOK_I32:
+		return Schema{"type": "integer", "minimum": math.MinInt32, "maximum": math.MaxInt32}
+	case parser.TOK_I64:
+		return Schema{"type": "integer", "minimum": int64(math.MinInt64), "maximum": int64(math.MaxInt64)}
+	case parser.TOK_DOUBLE:
+		return Schema{"type": "number"}
+	case parser.TOK_STRING:
+		return Schema{"type": "string"}
+	case parser.TOK_VOID:
+		return Schema{"type": "null"}
+	}
+	return Schema{}
+}
+
+// Maps with scalar keys are objects, with property names constrained to the
+// key type. Other maps are arrays of [key, value] pairs.
+func (this *Builder) mapSchema(ttype *parser.MapType) Schema {
+	value := this.Type(ttype.Value)
+
+	var names Schema
+	resolved, node := ttype.Key.Resolve()
+	if enum, ok := node.(*parser.EnumNode); ok {
+		names = Schema{"enum": enumNames(enum)}
+	} else if builtin, ok := resolved.(*parser.BuiltinType); ok {
+		switch builtin.Tok.Kind {
+		case parser.TOK_STRING:
+			names = Schema{}
+		case parser.TOK_I16, parser.TOK_I32, parser.TOK_I64:
+			names = Schema{"pattern": "^-?[0-9]+$"}
+		case parser.TOK_BOOL:
+			names = Schema{"enum": []string{"true", "false"}}
+		}
+	}
+
+	if names == nil {
+		return Schema{
+			"type": "array",
+			"items": Schema{
+				"type":        "array",
+				"prefixItems": []Schema{this.Type(ttype.Key), value},
+				"items":       false,
+				"minItems":    2,
+			},
+		}
+	}
+
+	schema := Schema{
+		"type":                 "object",
+		"additionalProperties": value,
+	}
+	if len(names) > 0 {
+		schema["propertyNames"] = names
+	}
+	return schema
+}
@@ -0,0 +1,63 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// Generates JSON Schema (draft 2020-12) documents from analyzed parse trees.
+package jsonschema
+
+import (
+	"github.com/edmodo/frugal/parser"
+)
+
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Options for Generate().
+type Options struct {
+	// Prepended to each document's file name to form its "$id", for example
+	// "https://example.com/schemas/". If empty, ids are just file names.
+	BaseURI string
+}
+
+// Returns the file name of the document for a parse tree: its package name,
+// plus ".json".
+func FileName(tree *parser.ParseTree) string {
+	return tree.Package + ".json"
+}
+
+// Generates a document for a tree and each file it includes, keyed by file
+// name (see FileName()). Each document has the definitions of its file in
+// "$defs", and refers to definitions in other files by their relative URI,
+// for example "common.json#/$defs/Point".
+//
+// The tree must have been through semantic analysis.
+func Generate(tree *parser.ParseTree, options *Options) map[string]Schema {
+	documents := map[string]Schema{}
+	for _, file := range parser.FlattenTrees(tree) {
+		documents[FileName(file)] = generateDocument(file, options)
+	}
+	return documents
+}
+
+func generateDocument(file *parser.ParseTree, options *Options) Schema {
+	ref := func(tree *parser.ParseTree, name string) string {
+		if tree == file {
+			return "#/$defs/" + name
+		}
+		return FileName(tree) + "#/$defs/" + name
+	}
+
+	return Schema{
+		"$schema": Draft,
+		"$id":     options.BaseURI + FileName(file),
+		"$defs":   NewBuilder(file, ref).Definitions(),
+	}
+}
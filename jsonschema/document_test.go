@@ -0,0 +1,121 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package jsonschema
+
+import (
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generate", func() {
+	var documents map[string]Schema
+
+	BeforeEach(func() {
+		documents = Generate(ParseTestTree(), &Options{BaseURI: "https://example.com/"})
+	})
+
+	It("generates a document per file", func() {
+		Expect(documents).To(HaveLen(2))
+		Expect(ToTestJSON(documents["common.json"])).To(Equal(sematest.ParseJSON(`{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$id": "https://example.com/common.json",
+			"$defs": {
+				"Point": {
+					"type": "object",
+					"properties": {
+						"x": {"type": "integer", "minimum": -2147483648, "maximum": 2147483647},
+						"y": {"type": "integer", "minimum": -2147483648, "maximum": 2147483647}
+					},
+					"required": ["x", "y"],
+					"additionalProperties": false
+				}
+			}
+		}`)))
+	})
+
+	It("converts enums and typedefs", func() {
+		defs := ToTestJSON(documents["test.json"]["$defs"])
+		Expect(defs).To(HaveKeyWithValue("Color", sematest.ParseJSON(`{"type": "string", "enum": ["RED", "GREEN", "BLUE"]}`)))
+		Expect(defs).To(HaveKeyWithValue("UserId", sematest.ParseJSON(`{
+			"type": "integer",
+			"minimum": -9223372036854775808,
+			"maximum": 9223372036854775807
+		}`)))
+	})
+
+	It("converts structs", func() {
+		user := ToTestJSON(documents["test.json"]["$defs"].(map[string]Schema)["User"]).(map[string]interface{})
+		Expect(user["required"]).To(Equal(sematest.ParseJSON(`["id", "name"]`)))
+		Expect(user["additionalProperties"]).To(Equal(false))
+
+		properties := user["properties"].(map[string]interface{})
+		Expect(properties["id"]).To(Equal(sematest.ParseJSON(`{"$ref": "#/$defs/UserId"}`)))
+		Expect(properties["name"]).To(Equal(sematest.ParseJSON(`{"type": "string"}`)))
+		Expect(properties["active"]).To(Equal(sematest.ParseJSON(`{"type": "boolean", "default": true}`)))
+
+		// Defaults are in the same form as the dynamic package's JSON.
+		Expect(properties["favorite"]).To(Equal(sematest.ParseJSON(`{"$ref": "#/$defs/Color", "default": "GREEN"}`)))
+		Expect(properties["palette"]).To(Equal(sematest.ParseJSON(`{
+			"type": "array",
+			"items": {"$ref": "#/$defs/Color"},
+			"default": ["RED"]
+		}`)))
+	})
+
+	It("converts maps", func() {
+		user := ToTestJSON(documents["test.json"]["$defs"].(map[string]Schema)["User"]).(map[string]interface{})
+		properties := user["properties"].(map[string]interface{})
+
+		Expect(properties["places"]).To(Equal(sematest.ParseJSON(`{
+			"type": "object",
+			"additionalProperties": {"$ref": "common.json#/$defs/Point"}
+		}`)))
+		Expect(properties["scores"]).To(Equal(sematest.ParseJSON(`{
+			"type": "object",
+			"propertyNames": {"enum": ["RED", "GREEN", "BLUE"]},
+			"additionalProperties": {"type": "integer", "minimum": -2147483648, "maximum": 2147483647},
+			"default": {"BLUE": 1}
+		}`)))
+		Expect(properties["flags"]).To(Equal(sematest.ParseJSON(`{
+			"type": "object",
+			"propertyNames": {"pattern": "^-?[0-9]+$"},
+			"additionalProperties": {"type": "boolean"}
+		}`)))
+
+		// Maps with struct keys are arrays of pairs, even when empty.
+		Expect(properties["labels"]).To(Equal(sematest.ParseJSON(`{
+			"type": "array",
+			"items": {
+				"type": "array",
+				"prefixItems": [{"$ref": "common.json#/$defs/Point"}, {"type": "string"}],
+				"items": false,
+				"minItems": 2
+			},
+			"default": []
+		}`)))
+	})
+
+	It("places references with a RefFunc", func() {
+		tree := ParseTestTree()
+		builder := NewBuilder(tree, func(tree *parser.ParseTree, name string) string {
+			return "#/components/schemas/" + tree.Package + "." + name
+		})
+		Expect(ToTestJSON(builder.Type(tree.Names["User"].(*parser.StructNode).Fields[4].Type))).To(Equal(sematest.ParseJSON(`{
+			"type": "object",
+			"additionalProperties": {"$ref": "#/components/schemas/common.Point"}
+		}`)))
+	})
+})
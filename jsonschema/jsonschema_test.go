@@ -0,0 +1,70 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package jsonschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "JSON Schema testing")
+}
+
+const TestSchema = `
+include "common.thrift"
+
+enum Color {
+	RED = 1
+	GREEN = 2
+	BLUE
+}
+
+typedef i64 UserId
+
+struct User {
+	1: required UserId id
+	2: string name
+	3: optional Color favorite = Color.GREEN
+	4: optional list<Color> palette = [Color.RED]
+	5: optional map<string, common.Point> places
+	6: optional map<Color, i32> scores = {Color.BLUE: 1}
+	7: optional map<i16, bool> flags
+	8: optional map<common.Point, string> labels = {}
+	9: required bool active = true
+}
+`
+
+// Returns the analyzed tree for the test schema.
+func ParseTestTree() *parser.ParseTree {
+	return sematest.Compile(map[string]string{
+		"test.thrift":   TestSchema,
+		"common.thrift": sematest.CommonSchema,
+	})
+}
+
+// Round-trips a schema through JSON, so it can be compared with parsed JSON.
+func ToTestJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	Expect(err).To(BeNil())
+	var result interface{}
+	Expect(json.Unmarshal(data, &result)).To(Succeed())
+	return result
+}
@@ -0,0 +1,177 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package jsonschema
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Checks parsed JSON against a generated schema, and returns a description of
+// each problem. This covers only the keywords the builder emits.
+type TestValidator struct {
+	// Generated documents, as parsed JSON, keyed by file name.
+	documents map[string]interface{}
+	errors    []string
+}
+
+func (this *TestValidator) Validate(file string, schema interface{}, value interface{}, pointer string) {
+	object := schema.(map[string]interface{})
+	fail := func(format string, args ...interface{}) {
+		this.errors = append(this.errors, pointer+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := object["$ref"].(string); ok {
+		parts := strings.SplitN(ref, "#/$defs/", 2)
+		if parts[0] != "" {
+			file = parts[0]
+		}
+		defs := this.documents[file].(map[string]interface{})["$defs"].(map[string]interface{})
+		this.Validate(file, defs[parts[1]], value, pointer)
+	}
+
+	if enum, ok := object["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			found = found || option == value
+		}
+		if !found {
+			fail("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch object["type"] {
+	case "null":
+		if value != nil {
+			fail("expected null")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("expected a boolean")
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			fail("expected a string")
+		}
+	case "integer", "number":
+		number, ok := value.(float64)
+		if !ok {
+			fail("expected a number")
+			break
+		}
+		if min, ok := object["minimum"].(float64); ok && number < min {
+			fail("%v is below %v", number, min)
+		}
+		if max, ok := object["maximum"].(float64); ok && number > max {
+			fail("%v is above %v", number, max)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fail("expected an array")
+			break
+		}
+		if min, ok := object["minItems"].(float64); ok && len(array) < int(min) {
+			fail("expected at least %v items", min)
+		}
+		prefix, _ := object["prefixItems"].([]interface{})
+		for i, item := range array {
+			itemPointer := fmt.Sprintf("%s/%d", pointer, i)
+			if i < len(prefix) {
+				this.Validate(file, prefix[i], item, itemPointer)
+			} else if object["items"] == false {
+				fail("unexpected item %d", i)
+			} else if items, ok := object["items"]; ok {
+				this.Validate(file, items, item, itemPointer)
+			}
+		}
+	case "object":
+		members, ok := value.(map[string]interface{})
+		if !ok {
+			fail("expected an object")
+			break
+		}
+		if required, ok := object["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := members[name.(string)]; !ok {
+					fail("missing %s", name)
+				}
+			}
+		}
+		properties, _ := object["properties"].(map[string]interface{})
+		for name, member := range members {
+			memberPointer := pointer + "/" + name
+			if names, ok := object["propertyNames"].(map[string]interface{}); ok {
+				this.Validate(file, names, name, memberPointer)
+				if pattern, ok := names["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(name) {
+					fail("%s does not match %s", name, pattern)
+				}
+			}
+			if property, ok := properties[name]; ok {
+				this.Validate(file, property, member, memberPointer)
+			} else if object["additionalProperties"] == false {
+				fail("unexpected property %s", name)
+			} else if additional, ok := object["additionalProperties"]; ok {
+				this.Validate(file, additional, member, memberPointer)
+			}
+		}
+	}
+}
+
+var _ = Describe("Generated values", func() {
+	It("match the generated schema", func() {
+		tree := ParseTestTree()
+		validator := &TestValidator{documents: map[string]interface{}{}}
+		for name, document := range Generate(tree, &Options{}) {
+			validator.documents[name] = ToTestJSON(document)
+		}
+
+		random := dynamic.NewRandom(1, &dynamic.RandomOptions{})
+		for _, file := range parser.FlattenTrees(tree) {
+			for _, node := range file.Nodes {
+				node, ok := node.(*parser.StructNode)
+				if !ok {
+					continue
+				}
+				schema := sematest.ParseJSON(fmt.Sprintf(`{"$ref": "%s#/$defs/%s"}`, FileName(file), node.Name.Identifier()))
+
+				for i := 0; i < 100; i++ {
+					value := ToTestJSON(dynamic.StructToJSON(random.Struct(node)))
+					validator.Validate(FileName(file), schema, value, "")
+				}
+
+				// Defaults match their own schemas.
+				for _, field := range node.Fields {
+					defaultValue, ok := field.Default.(*parser.ValueNode)
+					if !ok || defaultValue == nil {
+						continue
+					}
+					value := ToTestJSON(dynamic.ValueToJSON(dynamic.ValueFromNode(field.Type, defaultValue)))
+					property := ToTestJSON(NewBuilder(file, func(tree *parser.ParseTree, name string) string {
+						return FileName(tree) + "#/$defs/" + name
+					}).Type(field.Type))
+					validator.Validate(FileName(file), property, value, "/"+field.Name.Identifier())
+				}
+			}
+		}
+		Expect(validator.errors).To(BeEmpty())
+	})
+})
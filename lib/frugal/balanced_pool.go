@@ -0,0 +1,416 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrNoEndpoints = errors.New("no endpoints are available")
+
+// Strategies for picking an endpoint in a BalancedPool.
+type BalanceStrategy int
+
+const (
+	// Cycle through endpoints in order.
+	BalanceRoundRobin BalanceStrategy = iota
+
+	// Pick the endpoint with the fewest connections checked out.
+	BalanceLeastOutstanding
+
+	// Pick two endpoints at random, and use the one with fewer connections
+	// checked out.
+	BalancePowerOfTwoChoices
+)
+
+// Returns the current list of endpoints ("host:port" strings, or unix socket
+// paths) for a service.
+type Resolver interface {
+	Resolve() ([]string, error)
+}
+
+// Creates the service factory used to connect to a single endpoint.
+type EndpointFactory func(hostAndPort string) ServiceFactory
+
+// Options that can be passed to NewBalancedPool().
+type BalancedPoolOptions struct {
+	// The initial list of endpoints. Ignored if Resolver is set.
+	Endpoints []string
+
+	// If set, endpoints are fetched from the resolver when the pool is
+	// created, and then every ResolveInterval.
+	Resolver        Resolver
+	ResolveInterval time.Duration
+
+	// How to pick an endpoint for each call to Get().
+	Strategy BalanceStrategy
+
+	// Options for the socket pool kept for each endpoint.
+	PoolOptions SocketPoolOptions
+
+	// Number of consecutive dial or request failures after which an endpoint is
+	// ejected. If zero, this defaults to 5.
+	FailureThreshold int
+
+	// How long an endpoint is ejected for the first time. Each consecutive
+	// ejection doubles this, up to MaxEjectionTime. If zero, these default to
+	// one second and one minute.
+	EjectionTime    time.Duration
+	MaxEjectionTime time.Duration
+}
+
+// A single endpoint in a BalancedPool.
+type balancedEndpoint struct {
+	address string
+	pool    *SocketPool
+
+	// Connections currently checked out.
+	outstanding int
+
+	// Consecutive failures, and consecutive ejections.
+	failures  int
+	ejections int
+
+	// The endpoint is skipped until this time.
+	ejectedUntil time.Time
+}
+
+// A BalancedPool spreads connections over several replicas of a service. It
+// keeps a SocketPool for each endpoint, and has the same Get/Put/Close API.
+// Endpoints that keep failing are ejected for a while, with exponential
+// backoff.
+type BalancedPool struct {
+	factory EndpointFactory
+	options BalancedPoolOptions
+
+	lock      sync.Mutex
+	endpoints []*balancedEndpoint
+	closed    bool
+
+	// The endpoint each checked-out connection came from.
+	owners map[*Connection]*balancedEndpoint
+
+	// Round-robin position, and randomness for power-of-two choices.
+	next   int
+	random *rand.Rand
+
+	// Signals the resolver goroutine to exit, and is closed when it has.
+	stopResolver chan struct{}
+	resolverDone chan struct{}
+}
+
+// Creates a new balanced pool. If a resolver is given, it is queried once
+// before returning, and any error is returned.
+func NewBalancedPool(factory EndpointFactory, options *BalancedPoolOptions) (*BalancedPool, error) {
+	pool := &BalancedPool{
+		factory: factory,
+		options: *options,
+		owners:  map[*Connection]*balancedEndpoint{},
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if pool.options.FailureThreshold == 0 {
+		pool.options.FailureThreshold = 5
+	}
+	if pool.options.EjectionTime == 0 {
+		pool.options.EjectionTime = time.Second
+	}
+	if pool.options.MaxEjectionTime == 0 {
+		pool.options.MaxEjectionTime = time.Minute
+	}
+
+	if options.Resolver == nil {
+		pool.UpdateEndpoints(options.Endpoints)
+		return pool, nil
+	}
+
+	if err := pool.Refresh(); err != nil {
+		return nil, err
+	}
+	if options.ResolveInterval > 0 {
+		pool.stopResolver = make(chan struct{})
+		pool.resolverDone = make(chan struct{})
+		go pool.resolve(options.ResolveInterval)
+	}
+	return pool, nil
+}
+
+// Queries the resolver and updates the endpoint list.
+func (this *BalancedPool) Refresh() error {
+	if this.options.Resolver == nil {
+		return nil
+	}
+
+	addresses, err := this.options.Resolver.Resolve()
+	if err != nil {
+		return err
+	}
+	this.UpdateEndpoints(addresses)
+	return nil
+}
+
+func (this *BalancedPool) resolve(interval time.Duration) {
+	defer close(this.resolverDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := this.Refresh(); err != nil {
+				log.Printf("endpoint resolution error: %s\n", err.Error())
+			}
+		case <-this.stopResolver:
+			return
+		}
+	}
+}
+
+// Replaces the endpoint list. Endpoints that are kept retain their pools and
+// failure state. Pools for removed endpoints are closed; connections checked
+// out from them are closed when they are returned.
+func (this *BalancedPool) UpdateEndpoints(addresses []string) {
+	// Closing a pool waits for its background dials, so removed pools are
+	// closed after the lock is released, so as not to stall Get().
+	for _, endpoint := range this.replaceEndpoints(addresses) {
+		endpoint.pool.Close()
+	}
+}
+
+// Swaps in a new endpoint list, and returns the endpoints that were removed.
+func (this *BalancedPool) replaceEndpoints(addresses []string) []*balancedEndpoint {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	existing := map[string]*balancedEndpoint{}
+	for _, endpoint := range this.endpoints {
+		existing[endpoint.address] = endpoint
+	}
+
+	endpoints := []*balancedEndpoint{}
+	for _, address := range addresses {
+		if endpoint, ok := existing[address]; ok {
+			endpoints = append(endpoints, endpoint)
+			delete(existing, address)
+			continue
+		}
+
+		options := this.options.PoolOptions
+		endpoints = append(endpoints, &balancedEndpoint{
+			address: address,
+			pool:    NewSocketPoolWithOptions(this.factory(address), &options),
+		})
+	}
+
+	removed := []*balancedEndpoint{}
+	for _, endpoint := range existing {
+		removed = append(removed, endpoint)
+	}
+	this.endpoints = endpoints
+	return removed
+}
+
+// Returns the addresses of all endpoints, including ejected ones.
+func (this *BalancedPool) Endpoints() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	addresses := []string{}
+	for _, endpoint := range this.endpoints {
+		addresses = append(addresses, endpoint.address)
+	}
+	return addresses
+}
+
+// Returns the address of the endpoint a checked-out connection belongs to.
+func (this *BalancedPool) EndpointOf(conn *Connection) string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if endpoint, ok := this.owners[conn]; ok {
+		return endpoint.address
+	}
+	return ""
+}
+
+// Returns the endpoints that are not currently ejected. The lock must be held.
+func (this *BalancedPool) available(now time.Time, exclude map[*balancedEndpoint]bool) []*balancedEndpoint {
+	available := []*balancedEndpoint{}
+	for _, endpoint := range this.endpoints {
+		if exclude[endpoint] || now.Before(endpoint.ejectedUntil) {
+			continue
+		}
+		available = append(available, endpoint)
+	}
+	return available
+}
+
+// Picks an endpoint according to the balancing strategy.
+func (this *BalancedPool) pick(exclude map[*balancedEndpoint]bool) (*balancedEndpoint, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil, ErrPoolClosed
+	}
+
+	candidates := this.available(time.Now(), exclude)
+	if len(candidates) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	switch this.options.Strategy {
+	case BalanceLeastOutstanding:
+		// Start from the round-robin position so ties are spread out.
+		start := this.next % len(candidates)
+		this.next++
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			candidate := candidates[(start+i)%len(candidates)]
+			if candidate.outstanding < best.outstanding {
+				best = candidate
+			}
+		}
+		return best, nil
+
+	case BalancePowerOfTwoChoices:
+		if len(candidates) == 1 {
+			return candidates[0], nil
+		}
+		first := this.random.Intn(len(candidates))
+		second := this.random.Intn(len(candidates) - 1)
+		if second >= first {
+			second++
+		}
+		if candidates[second].outstanding < candidates[first].outstanding {
+			return candidates[second], nil
+		}
+		return candidates[first], nil
+
+	default:
+		endpoint := candidates[this.next%len(candidates)]
+		this.next++
+		return endpoint, nil
+	}
+}
+
+// Records the outcome of using an endpoint, ejecting it if it has failed too
+// many times in a row. The lock must be held.
+func (this *BalancedPool) record(endpoint *balancedEndpoint, err error) {
+	if err == nil {
+		endpoint.failures = 0
+		endpoint.ejections = 0
+		return
+	}
+
+	endpoint.failures++
+	if endpoint.failures < this.options.FailureThreshold {
+		return
+	}
+
+	backoff := this.options.EjectionTime
+	for i := 0; i < endpoint.ejections && backoff < this.options.MaxEjectionTime; i++ {
+		backoff *= 2
+	}
+	if backoff > this.options.MaxEjectionTime {
+		backoff = this.options.MaxEjectionTime
+	}
+
+	log.Printf("ejecting endpoint %s for %s: %s\n", endpoint.address, backoff, err.Error())
+	endpoint.ejectedUntil = time.Now().Add(backoff)
+	endpoint.ejections++
+	endpoint.failures = 0
+}
+
+// Returns a connection to one of the endpoints. If an endpoint fails to
+// provide a connection, the failure is recorded and other endpoints are
+// tried. Connections must be returned with Put().
+func (this *BalancedPool) Get() (*Connection, error) {
+	tried := map[*balancedEndpoint]bool{}
+	lastErr := ErrNoEndpoints
+	for {
+		endpoint, err := this.pick(tried)
+		if err == ErrNoEndpoints {
+			return nil, lastErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		tried[endpoint] = true
+
+		conn, err := endpoint.pool.Get()
+
+		this.lock.Lock()
+		if err != nil {
+			if err != ErrPoolClosed && err != ErrPoolExhausted {
+				this.record(endpoint, err)
+			}
+			this.lock.Unlock()
+			lastErr = err
+			continue
+		}
+		endpoint.outstanding++
+		this.owners[conn] = endpoint
+		this.lock.Unlock()
+		return conn, nil
+	}
+}
+
+// Returns a connection to its endpoint's pool. As with SocketPool, a non-nil
+// error closes the connection; it also counts as a failure of the endpoint.
+func (this *BalancedPool) Put(conn *Connection, err *error) {
+	this.lock.Lock()
+	endpoint, ok := this.owners[conn]
+	if ok {
+		delete(this.owners, conn)
+		endpoint.outstanding--
+		this.record(endpoint, *err)
+	}
+	this.lock.Unlock()
+
+	if !ok {
+		conn.transport.Close()
+		return
+	}
+	endpoint.pool.Put(conn, err)
+}
+
+// Closes every endpoint's pool and stops the resolver.
+func (this *BalancedPool) Close() {
+	this.lock.Lock()
+	if this.closed {
+		this.lock.Unlock()
+		return
+	}
+	this.closed = true
+	endpoints := this.endpoints
+	this.endpoints = nil
+	this.lock.Unlock()
+
+	if this.stopResolver != nil {
+		close(this.stopResolver)
+		<-this.resolverDone
+	}
+	for _, endpoint := range endpoints {
+		endpoint.pool.Close()
+	}
+}
@@ -0,0 +1,248 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A service factory that dials a fixed address.
+type TestAddressFactory struct {
+	address string
+}
+
+func NewTestAddressFactory(address string) ServiceFactory {
+	return &TestAddressFactory{address}
+}
+
+func (this *TestAddressFactory) Connect() (*Connection, error) {
+	socket, err := NewSocket(this.address, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnectionFromFactory(socket, thrift.NewTBinaryProtocolFactoryDefault()), nil
+}
+
+// A service factory whose dials block until released. Each dial is announced
+// on dialing.
+type TestStalledFactory struct {
+	dialing chan struct{}
+	release chan struct{}
+}
+
+func (this *TestStalledFactory) Connect() (*Connection, error) {
+	this.dialing <- struct{}{}
+	<-this.release
+	return nil, errors.New("dial abandoned")
+}
+
+// A resolver whose endpoint list can be changed by tests.
+type TestResolver struct {
+	lock      sync.Mutex
+	addresses []string
+}
+
+func (this *TestResolver) Set(addresses ...string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.addresses = addresses
+}
+
+func (this *TestResolver) Resolve() ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.addresses, nil
+}
+
+// Returns an address that nothing is listening on.
+func UnusedAddress() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+var _ = Describe("BalancedPool", func() {
+	var first, second *Server
+
+	BeforeEach(func() {
+		first = StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		second = StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+	})
+
+	AfterEach(func() {
+		first.Stop()
+		second.Stop()
+	})
+
+	It("Cycles through endpoints in round-robin order", func() {
+		addresses := []string{first.Addr().String(), second.Addr().String()}
+		pool, err := NewBalancedPool(NewTestAddressFactory, &BalancedPoolOptions{
+			Endpoints:   addresses,
+			PoolOptions: SocketPoolOptions{MaxIdle: 2},
+		})
+		Expect(err).To(BeNil())
+		defer pool.Close()
+
+		seen := []string{}
+		for i := 0; i < 4; i++ {
+			conn, err := pool.Get()
+			Expect(err).To(BeNil())
+			seen = append(seen, pool.EndpointOf(conn))
+			pool.Put(conn, &err)
+		}
+		Expect(seen).To(Equal([]string{addresses[0], addresses[1], addresses[0], addresses[1]}))
+	})
+
+	It("Prefers endpoints with fewer outstanding connections", func() {
+		for _, strategy := range []BalanceStrategy{BalanceLeastOutstanding, BalancePowerOfTwoChoices} {
+			pool, err := NewBalancedPool(NewTestAddressFactory, &BalancedPoolOptions{
+				Endpoints:   []string{first.Addr().String(), second.Addr().String()},
+				Strategy:    strategy,
+				PoolOptions: SocketPoolOptions{MaxIdle: 2},
+			})
+			Expect(err).To(BeNil())
+
+			// Holding one connection should steer the next one elsewhere.
+			held, err := pool.Get()
+			Expect(err).To(BeNil())
+			for i := 0; i < 4; i++ {
+				conn, err := pool.Get()
+				Expect(err).To(BeNil())
+				Expect(pool.EndpointOf(conn)).NotTo(Equal(pool.EndpointOf(held)))
+				pool.Put(conn, &err)
+			}
+			pool.Put(held, &err)
+			pool.Close()
+		}
+	})
+
+	It("Does not block Get while closing removed endpoints", func() {
+		stalled := &TestStalledFactory{
+			dialing: make(chan struct{}, 1),
+			release: make(chan struct{}),
+		}
+		defer close(stalled.release)
+
+		factory := func(address string) ServiceFactory {
+			if address == "stalled" {
+				return stalled
+			}
+			return NewTestAddressFactory(address)
+		}
+		pool, err := NewBalancedPool(factory, &BalancedPoolOptions{
+			Endpoints:   []string{"stalled", first.Addr().String()},
+			PoolOptions: SocketPoolOptions{MaxIdle: 1, MinIdle: 1},
+		})
+		Expect(err).To(BeNil())
+		defer pool.Close()
+
+		// The stalled endpoint's pool is pre-warming, so closing it waits.
+		Eventually(stalled.dialing).Should(Receive())
+		go pool.UpdateEndpoints([]string{first.Addr().String()})
+		Eventually(pool.Endpoints).Should(Equal([]string{first.Addr().String()}))
+
+		got := make(chan error, 1)
+		go func() {
+			conn, err := pool.Get()
+			if err == nil {
+				pool.Put(conn, &err)
+			}
+			got <- err
+		}()
+		Eventually(got).Should(Receive(BeNil()))
+	})
+
+	It("Ejects failing endpoints with exponential backoff", func() {
+		dead := UnusedAddress()
+		pool, err := NewBalancedPool(NewTestAddressFactory, &BalancedPoolOptions{
+			Endpoints:        []string{dead, first.Addr().String()},
+			FailureThreshold: 1,
+			EjectionTime:     50 * time.Millisecond,
+			PoolOptions:      SocketPoolOptions{MaxIdle: 2},
+		})
+		Expect(err).To(BeNil())
+		defer pool.Close()
+
+		// The dead endpoint fails to dial, so Get() falls over to the live one.
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(pool.EndpointOf(conn)).To(Equal(first.Addr().String()))
+		pool.Put(conn, &err)
+
+		// While ejected, only the live endpoint is used.
+		for i := 0; i < 3; i++ {
+			conn, err := pool.Get()
+			Expect(err).To(BeNil())
+			Expect(pool.EndpointOf(conn)).To(Equal(first.Addr().String()))
+			pool.Put(conn, &err)
+		}
+
+		// Request failures count too.
+		conn, err = pool.Get()
+		Expect(err).To(BeNil())
+		failed := errors.New("request failed")
+		pool.Put(conn, &failed)
+
+		// Everything is now ejected (or, if the dead endpoint's ejection has
+		// already run out, fails to dial).
+		_, err = pool.Get()
+		Expect(err).NotTo(BeNil())
+
+		// After the backoff, the live endpoint is available again.
+		Eventually(func() error {
+			conn, err := pool.Get()
+			if err == nil {
+				pool.Put(conn, &err)
+			}
+			return err
+		}).Should(BeNil())
+	})
+
+	It("Picks up endpoint changes from the resolver", func() {
+		resolver := &TestResolver{}
+		resolver.Set(first.Addr().String())
+
+		pool, err := NewBalancedPool(NewTestAddressFactory, &BalancedPoolOptions{
+			Resolver:        resolver,
+			ResolveInterval: 10 * time.Millisecond,
+			PoolOptions:     SocketPoolOptions{MaxIdle: 2},
+		})
+		Expect(err).To(BeNil())
+		defer pool.Close()
+		Expect(pool.Endpoints()).To(Equal([]string{first.Addr().String()}))
+
+		held, err := pool.Get()
+		Expect(err).To(BeNil())
+
+		resolver.Set(second.Addr().String())
+		Eventually(pool.Endpoints).Should(Equal([]string{second.Addr().String()}))
+
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(pool.EndpointOf(conn)).To(Equal(second.Addr().String()))
+		pool.Put(conn, &err)
+
+		// Connections from removed endpoints are closed when returned.
+		pool.Put(held, &err)
+		Expect(held.Transport().IsOpen()).To(BeFalse())
+	})
+})
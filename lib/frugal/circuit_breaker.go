@@ -0,0 +1,312 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker.
+type CircuitState int
+
+const (
+	// Connections are allowed; failures are being counted.
+	CircuitClosed CircuitState = iota
+
+	// Connections fail immediately until the cool-down expires.
+	CircuitOpen
+
+	// A limited number of trial connections are allowed. Success closes the
+	// circuit, and failure opens it again.
+	CircuitHalfOpen
+)
+
+func (this CircuitState) String() string {
+	switch this {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Returned by CircuitBreaker.Connect() while the circuit is open.
+type CircuitOpenError struct {
+	// When the circuit will next allow a trial connection.
+	RetryAt time.Time
+}
+
+func (this *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker is open until %s", this.RetryAt.Format(time.RFC3339Nano))
+}
+
+// Optional interface for service factories that want to know how their
+// connections fared. SocketPool calls this from Put(), with the error that
+// was passed in.
+type ConnectionObserver interface {
+	ConnectionReleased(conn *Connection, err error)
+}
+
+// Options that can be passed to NewCircuitBreaker().
+type CircuitBreakerOptions struct {
+	// Number of consecutive failures that opens the circuit. If zero, this
+	// defaults to 5.
+	FailureThreshold int
+
+	// How long the circuit stays open before allowing trial connections. If
+	// zero, this defaults to ten seconds.
+	CoolDown time.Duration
+
+	// Maximum number of trial connections allowed while half-open. If zero,
+	// this defaults to 1. Only trials decide whether the circuit closes or
+	// opens again. A trial that has not been returned to its pool after
+	// CoolDown (for example, one leaked by a caller, or pre-warmed into the
+	// idle list and then evicted) stops being a trial, and no longer counts
+	// towards this limit.
+	HalfOpenMaxTrials int
+
+	// If non-nil, called (outside of any locks) whenever the state changes.
+	OnStateChange func(from CircuitState, to CircuitState)
+}
+
+// A service factory that wraps another factory with a circuit breaker.
+// Failures from Connect(), and errors passed to SocketPool.Put() for its
+// connections, count towards opening the circuit. While open, Connect()
+// fails immediately with a *CircuitOpenError instead of dialing.
+type CircuitBreaker struct {
+	factory ServiceFactory
+	options CircuitBreakerOptions
+
+	lock     sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	// Counts each time the circuit becomes half-open, so that trial dials
+	// that finish after it has changed state again can be told apart.
+	halfOpens int
+
+	// While half-open, the number of trial dials in progress, and the trial
+	// connections they made, with when each was made.
+	dialing int
+	trials  map[*Connection]time.Time
+}
+
+func NewCircuitBreaker(factory ServiceFactory, options *CircuitBreakerOptions) *CircuitBreaker {
+	breaker := &CircuitBreaker{
+		factory: factory,
+		options: *options,
+		state:   CircuitClosed,
+	}
+	if breaker.options.FailureThreshold == 0 {
+		breaker.options.FailureThreshold = 5
+	}
+	if breaker.options.CoolDown == 0 {
+		breaker.options.CoolDown = 10 * time.Second
+	}
+	if breaker.options.HalfOpenMaxTrials == 0 {
+		breaker.options.HalfOpenMaxTrials = 1
+	}
+	return breaker
+}
+
+// Returns the current state of the circuit.
+func (this *CircuitBreaker) State() CircuitState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// Report a cooled-down circuit as half-open, even if nothing has tried to
+	// connect yet.
+	if this.state == CircuitOpen && time.Since(this.openedAt) >= this.options.CoolDown {
+		return CircuitHalfOpen
+	}
+	return this.state
+}
+
+// Changes state. The lock must be held; the returned function, if non-nil,
+// must be called after releasing it to run the callback.
+func (this *CircuitBreaker) setState(state CircuitState) func() {
+	old := this.state
+	if old == state {
+		return nil
+	}
+
+	this.state = state
+	this.failures = 0
+	this.dialing = 0
+	this.trials = nil
+	switch state {
+	case CircuitOpen:
+		this.openedAt = time.Now()
+	case CircuitHalfOpen:
+		this.halfOpens++
+		this.trials = map[*Connection]time.Time{}
+	}
+
+	if this.options.OnStateChange == nil {
+		return nil
+	}
+	return func() {
+		this.options.OnStateChange(old, state)
+	}
+}
+
+func runCallback(callback func()) {
+	if callback != nil {
+		callback()
+	}
+}
+
+// Decides whether a connection attempt may proceed. While half-open, the
+// attempt is a trial, and the half-open period it belongs to is returned;
+// otherwise, the period is zero.
+func (this *CircuitBreaker) allow() (int, func(), error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	var callback func()
+	now := time.Now()
+	if this.state == CircuitOpen {
+		retryAt := this.openedAt.Add(this.options.CoolDown)
+		if now.Before(retryAt) {
+			return 0, nil, &CircuitOpenError{retryAt}
+		}
+		callback = this.setState(CircuitHalfOpen)
+	}
+	if this.state != CircuitHalfOpen {
+		return 0, callback, nil
+	}
+
+	// Trials that never reported back expire, so that they can't keep the
+	// circuit half-open forever.
+	var retryAt time.Time
+	for conn, madeAt := range this.trials {
+		expiresAt := madeAt.Add(this.options.CoolDown)
+		if !now.Before(expiresAt) {
+			delete(this.trials, conn)
+		} else if retryAt.IsZero() || expiresAt.Before(retryAt) {
+			retryAt = expiresAt
+		}
+	}
+	if this.dialing+len(this.trials) >= this.options.HalfOpenMaxTrials {
+		// If every trial is still dialing, one will report back shortly.
+		if retryAt.IsZero() {
+			retryAt = now
+		}
+		return 0, callback, &CircuitOpenError{retryAt}
+	}
+	this.dialing++
+	return this.halfOpens, callback, nil
+}
+
+// Records the outcome of a dial. While closed, only failures count, since a
+// connection has not proven itself until it is returned. While half-open,
+// only trial dials count.
+func (this *CircuitBreaker) dialed(trial int, conn *Connection, err error) {
+	this.lock.Lock()
+
+	var callback func()
+	switch this.state {
+	case CircuitClosed:
+		if err != nil {
+			callback = this.recordClosed(err)
+		}
+
+	case CircuitHalfOpen:
+		if trial != this.halfOpens {
+			break
+		}
+		this.dialing--
+		if err != nil {
+			callback = this.setState(CircuitOpen)
+		} else {
+			this.trials[conn] = time.Now()
+		}
+	}
+
+	this.lock.Unlock()
+	runCallback(callback)
+}
+
+// Records the outcome of a connection returned to its pool. While half-open,
+// only trial connections count; older ones say nothing about whether the
+// service has recovered.
+func (this *CircuitBreaker) released(conn *Connection, err error) {
+	this.lock.Lock()
+
+	var callback func()
+	switch this.state {
+	case CircuitClosed:
+		callback = this.recordClosed(err)
+
+	case CircuitHalfOpen:
+		if _, ok := this.trials[conn]; !ok {
+			break
+		}
+		delete(this.trials, conn)
+		if err == nil {
+			callback = this.setState(CircuitClosed)
+		} else {
+			callback = this.setState(CircuitOpen)
+		}
+	}
+
+	this.lock.Unlock()
+	runCallback(callback)
+}
+
+// Counts a failure or success while closed. The lock must be held.
+func (this *CircuitBreaker) recordClosed(err error) func() {
+	if err == nil {
+		this.failures = 0
+		return nil
+	}
+	this.failures++
+	if this.failures >= this.options.FailureThreshold {
+		return this.setState(CircuitOpen)
+	}
+	return nil
+}
+
+// Implements ServiceFactory.Connect. A successful dial while half-open does
+// not close the circuit by itself; that happens when the connection is
+// returned to its pool without an error.
+func (this *CircuitBreaker) Connect() (*Connection, error) {
+	trial, callback, err := this.allow()
+	runCallback(callback)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := this.factory.Connect()
+	this.dialed(trial, conn, err)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Implements ConnectionObserver.ConnectionReleased.
+func (this *CircuitBreaker) ConnectionReleased(conn *Connection, err error) {
+	this.released(conn, err)
+
+	if observer, ok := this.factory.(ConnectionObserver); ok {
+		observer.ConnectionReleased(conn, err)
+	}
+}
@@ -0,0 +1,230 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A service factory that can be told to fail.
+type TestFlakyFactory struct {
+	inner ServiceFactory
+
+	lock  sync.Mutex
+	fail  bool
+	dials int
+}
+
+func (this *TestFlakyFactory) SetFailing(fail bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.fail = fail
+}
+
+func (this *TestFlakyFactory) Dials() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.dials
+}
+
+func (this *TestFlakyFactory) Connect() (*Connection, error) {
+	this.lock.Lock()
+	this.dials++
+	fail := this.fail
+	this.lock.Unlock()
+
+	if fail {
+		return nil, errors.New("dial failed")
+	}
+	return this.inner.Connect()
+}
+
+var _ = Describe("CircuitBreaker", func() {
+	var server *Server
+	var factory *TestFlakyFactory
+	var transitions []string
+	var lock sync.Mutex
+
+	BeforeEach(func() {
+		server = StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		factory = &TestFlakyFactory{inner: &TestServerFactory{server}}
+		transitions = nil
+	})
+
+	AfterEach(func() {
+		server.Stop()
+	})
+
+	newBreaker := func() *CircuitBreaker {
+		return NewCircuitBreaker(factory, &CircuitBreakerOptions{
+			FailureThreshold: 2,
+			CoolDown:         30 * time.Millisecond,
+			OnStateChange: func(from CircuitState, to CircuitState) {
+				lock.Lock()
+				defer lock.Unlock()
+				transitions = append(transitions, from.String()+"->"+to.String())
+			},
+		})
+	}
+
+	getTransitions := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), transitions...)
+	}
+
+	It("Opens after repeated dial failures and fails fast", func() {
+		breaker := newBreaker()
+		pool := NewSocketPool(breaker, 1)
+		defer pool.Close()
+
+		factory.SetFailing(true)
+		for i := 0; i < 2; i++ {
+			_, err := pool.Get()
+			Expect(err).To(MatchError("dial failed"))
+		}
+		Expect(breaker.State()).To(Equal(CircuitOpen))
+		Expect(getTransitions()).To(Equal([]string{"closed->open"}))
+
+		// While open, nothing is dialed.
+		_, err := pool.Get()
+		_, isOpen := err.(*CircuitOpenError)
+		Expect(isOpen).To(BeTrue())
+		Expect(factory.Dials()).To(Equal(2))
+
+		// After the cool-down, a successful trial closes the circuit.
+		factory.SetFailing(false)
+		Eventually(breaker.State).Should(Equal(CircuitHalfOpen))
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+
+		// Only one trial is allowed at once.
+		_, err = pool.Get()
+		_, isOpen = err.(*CircuitOpenError)
+		Expect(isOpen).To(BeTrue())
+
+		var ok error
+		pool.Put(conn, &ok)
+		Expect(breaker.State()).To(Equal(CircuitClosed))
+		Expect(getTransitions()).To(Equal([]string{"closed->open", "open->half-open", "half-open->closed"}))
+	})
+
+	It("Counts request errors passed to Put", func() {
+		breaker := newBreaker()
+		pool := NewSocketPool(breaker, 1)
+		defer pool.Close()
+
+		failed := errors.New("request failed")
+		for i := 0; i < 2; i++ {
+			conn, err := pool.Get()
+			Expect(err).To(BeNil())
+			pool.Put(conn, &failed)
+		}
+		Expect(breaker.State()).To(Equal(CircuitOpen))
+
+		// A failed trial re-opens the circuit.
+		Eventually(breaker.State).Should(Equal(CircuitHalfOpen))
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		pool.Put(conn, &failed)
+		Expect(breaker.State()).To(Equal(CircuitOpen))
+		Expect(getTransitions()).To(Equal([]string{"closed->open", "open->half-open", "half-open->open"}))
+	})
+
+	It("Ignores connections from before the trial while half-open", func() {
+		breaker := newBreaker()
+		pool := NewSocketPool(breaker, 2)
+		defer pool.Close()
+
+		old, err := pool.Get()
+		Expect(err).To(BeNil())
+		factory.SetFailing(true)
+		for i := 0; i < 2; i++ {
+			_, err := pool.Get()
+			Expect(err).To(MatchError("dial failed"))
+		}
+		Expect(breaker.State()).To(Equal(CircuitOpen))
+
+		factory.SetFailing(false)
+		Eventually(breaker.State).Should(Equal(CircuitHalfOpen))
+		trial, err := pool.Get()
+		Expect(err).To(BeNil())
+
+		// The connection dialed before the circuit opened has no say.
+		failed := errors.New("request failed")
+		pool.Put(old, &failed)
+		Expect(breaker.State()).To(Equal(CircuitHalfOpen))
+
+		var ok error
+		pool.Put(trial, &ok)
+		Expect(breaker.State()).To(Equal(CircuitClosed))
+		Expect(getTransitions()).To(Equal([]string{"closed->open", "open->half-open", "half-open->closed"}))
+	})
+
+	It("Expires trials that are never returned", func() {
+		breaker := newBreaker()
+
+		// Trial connections dialed into the idle list expire before anything
+		// takes them, so they are never returned with Put.
+		pool := NewSocketPoolWithOptions(breaker, &SocketPoolOptions{
+			MaxIdle:     1,
+			MinIdle:     1,
+			IdleTimeout: 10 * time.Millisecond,
+		})
+		defer pool.Close()
+
+		factory.SetFailing(true)
+		Eventually(breaker.State).Should(Equal(CircuitOpen))
+		factory.SetFailing(false)
+
+		// The first trial is pre-warmed, and later evicted.
+		Eventually(factory.Dials).Should(BeNumerically(">", 2))
+		Eventually(pool.Idle).Should(Equal(0))
+
+		// Once it expires, callers get trials of their own.
+		var conn *Connection
+		Eventually(func() error {
+			var err error
+			conn, err = pool.Get()
+			return err
+		}).Should(BeNil())
+
+		var ok error
+		pool.Put(conn, &ok)
+		Expect(breaker.State()).To(Equal(CircuitClosed))
+	})
+
+	It("Resets the failure count on success", func() {
+		breaker := newBreaker()
+		pool := NewSocketPool(breaker, 0)
+		defer pool.Close()
+
+		failed := errors.New("request failed")
+		for i := 0; i < 3; i++ {
+			conn, err := pool.Get()
+			Expect(err).To(BeNil())
+			pool.Put(conn, &failed)
+
+			conn, err = pool.Get()
+			Expect(err).To(BeNil())
+			pool.Put(conn, &err)
+		}
+		Expect(breaker.State()).To(Equal(CircuitClosed))
+	})
+})
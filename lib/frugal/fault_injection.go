@@ -0,0 +1,348 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Which direction of a connection a fault applies to.
+type FaultOp int
+
+const (
+	FaultRead FaultOp = iota
+	FaultWrite
+)
+
+func (this FaultOp) String() string {
+	if this == FaultWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// What happens when a fault fires.
+type FaultKind int
+
+const (
+	// Sleep for the fault's Delay, then carry on normally.
+	FaultLatency FaultKind = iota
+
+	// Cut the stream off. Reads return io.EOF from then on, and writes appear
+	// to succeed but are discarded, as if the connection dropped in flight.
+	FaultTruncate
+
+	// Fail with ECONNRESET from then on, and close the underlying connection.
+	FaultReset
+
+	// Fail with EPIPE from then on, and close the underlying connection.
+	FaultBrokenPipe
+
+	// Fail this operation with a timeout error. Later operations are not
+	// affected.
+	FaultTimeout
+)
+
+// A single scripted or random fault. Exactly one trigger should be used:
+//
+//   - Call fires on the Nth read or write (counting from 1).
+//   - Probability fires on each read or write with that chance.
+//   - Otherwise, the fault fires once Offset bytes have been transferred in
+//     that direction. An operation that would cross the offset is cut short
+//     at it, so the fault lands exactly on that byte.
+//
+// Call and Offset faults fire once; Probability faults can fire many times.
+type Fault struct {
+	Op   FaultOp
+	Kind FaultKind
+
+	Call        int
+	Offset      int64
+	Probability float64
+
+	// How long FaultLatency sleeps.
+	Delay time.Duration
+}
+
+// A set of faults to inject into a connection. The same plan with the same
+// seed always injects the same faults for the same sequence of operations.
+type FaultPlan struct {
+	Seed   int64
+	Faults []Fault
+}
+
+// Returned for FaultTimeout.
+type faultTimeoutError struct{}
+
+func (this faultTimeoutError) Error() string   { return "i/o timeout (injected)" }
+func (this faultTimeoutError) Timeout() bool   { return true }
+func (this faultTimeoutError) Temporary() bool { return true }
+
+// The per-direction state of a faulted connection.
+type faultDirection struct {
+	calls int
+	bytes int64
+
+	// Once set, every later operation fails with this error.
+	broken error
+
+	// True once the stream has been truncated.
+	truncated bool
+}
+
+// Decides which faults to inject for one connection.
+type faultInjector struct {
+	lock       sync.Mutex
+	random     *rand.Rand
+	faults     []Fault
+	fired      []bool
+	directions [2]faultDirection
+
+	// Every fault that has fired, in order.
+	history []Fault
+}
+
+func newFaultInjector(plan *FaultPlan) *faultInjector {
+	return &faultInjector{
+		random: rand.New(rand.NewSource(plan.Seed)),
+		faults: plan.Faults,
+		fired:  make([]bool, len(plan.Faults)),
+	}
+}
+
+// What to do for a single read or write.
+type faultAction struct {
+	delay time.Duration
+
+	// If non-negative, transfer at most this many bytes.
+	limit int
+
+	// If set, fail with this error instead of transferring anything more.
+	err error
+
+	// If true, pretend writes succeed without sending anything, or make reads
+	// return EOF.
+	truncate bool
+
+	// If true, close the underlying connection.
+	close bool
+}
+
+// Returns the action for an operation of the given size.
+func (this *faultInjector) next(op FaultOp, size int) faultAction {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	direction := &this.directions[op]
+	direction.calls++
+
+	action := faultAction{limit: -1}
+	if direction.broken != nil {
+		action.err = direction.broken
+		return action
+	}
+	if direction.truncated {
+		action.truncate = true
+		return action
+	}
+
+	for i, fault := range this.faults {
+		if fault.Op != op {
+			continue
+		}
+
+		var fire bool
+		switch {
+		case fault.Call > 0:
+			fire = !this.fired[i] && direction.calls == fault.Call
+		case fault.Probability > 0:
+			fire = this.random.Float64() < fault.Probability
+		default:
+			if this.fired[i] {
+				continue
+			}
+			if direction.bytes < fault.Offset {
+				// Stop short of the offset, so the fault fires on the next call.
+				if remaining := fault.Offset - direction.bytes; remaining < int64(size) {
+					if action.limit < 0 || int(remaining) < action.limit {
+						action.limit = int(remaining)
+					}
+				}
+				continue
+			}
+			fire = true
+		}
+		if !fire {
+			continue
+		}
+
+		this.fired[i] = true
+		this.history = append(this.history, fault)
+		switch fault.Kind {
+		case FaultLatency:
+			action.delay += fault.Delay
+			continue
+		case FaultTruncate:
+			direction.truncated = true
+			action.truncate = true
+		case FaultReset:
+			direction.broken = faultSyscallError(op, syscall.ECONNRESET)
+			action.err = direction.broken
+			action.close = true
+		case FaultBrokenPipe:
+			direction.broken = faultSyscallError(op, syscall.EPIPE)
+			action.err = direction.broken
+			action.close = true
+		case FaultTimeout:
+			action.err = &net.OpError{Op: op.String(), Net: "tcp", Err: faultTimeoutError{}}
+		}
+		action.limit = -1
+		return action
+	}
+	return action
+}
+
+// Records bytes that were transferred.
+func (this *faultInjector) transferred(op FaultOp, n int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.directions[op].bytes += int64(n)
+}
+
+// Returns every fault that has fired so far.
+func (this *faultInjector) Fired() []Fault {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]Fault(nil), this.history...)
+}
+
+func faultSyscallError(op FaultOp, errno syscall.Errno) error {
+	return &net.OpError{
+		Op:  op.String(),
+		Net: "tcp",
+		Err: os.NewSyscallError(op.String(), errno),
+	}
+}
+
+// Performs a read or write through the injector.
+func (this *faultInjector) do(op FaultOp, buf []byte, fn func(buf []byte) (int, error), closer io.Closer) (int, error) {
+	action := this.next(op, len(buf))
+	if action.delay > 0 {
+		time.Sleep(action.delay)
+	}
+	if action.close {
+		closer.Close()
+	}
+	if action.err != nil {
+		return 0, action.err
+	}
+	if action.truncate {
+		if op == FaultRead {
+			return 0, io.EOF
+		}
+		return len(buf), nil
+	}
+
+	if action.limit >= 0 && action.limit < len(buf) {
+		n, err := fn(buf[:action.limit])
+		this.transferred(op, n)
+		if err == nil && op == FaultWrite {
+			// The rest of the write is handled on the next call, where the fault
+			// fires. Writers must return an error for short writes.
+			m, err := this.do(op, buf[n:], fn, closer)
+			return n + m, err
+		}
+		return n, err
+	}
+
+	n, err := fn(buf)
+	this.transferred(op, n)
+	return n, err
+}
+
+// A net.Conn that injects faults from a plan. Since Socket is built on a
+// net.Conn, this can be used with NewSocketFromConn() to test how sockets
+// and pools cope with broken connections.
+type FaultConn struct {
+	net.Conn
+	injector *faultInjector
+}
+
+func NewFaultConn(conn net.Conn, plan *FaultPlan) *FaultConn {
+	return &FaultConn{conn, newFaultInjector(plan)}
+}
+
+func (this *FaultConn) Read(buf []byte) (int, error) {
+	return this.injector.do(FaultRead, buf, this.Conn.Read, this.Conn)
+}
+
+func (this *FaultConn) Write(buf []byte) (int, error) {
+	return this.injector.do(FaultWrite, buf, this.Conn.Write, this.Conn)
+}
+
+// Returns every fault that has fired so far.
+func (this *FaultConn) Fired() []Fault {
+	return this.injector.Fired()
+}
+
+// A Transport that injects faults from a plan. Write faults apply to calls to
+// Write(), and once the write side is broken, Flush() fails as well.
+type FaultTransport struct {
+	Transport
+	injector *faultInjector
+}
+
+func NewFaultTransport(transport Transport, plan *FaultPlan) *FaultTransport {
+	return &FaultTransport{transport, newFaultInjector(plan)}
+}
+
+func (this *FaultTransport) Read(buf []byte) (int, error) {
+	return this.injector.do(FaultRead, buf, this.Transport.Read, this.Transport)
+}
+
+func (this *FaultTransport) Write(buf []byte) (int, error) {
+	return this.injector.do(FaultWrite, buf, this.Transport.Write, this.Transport)
+}
+
+func (this *FaultTransport) Flush() error {
+	if err := this.brokenWrites(); err != nil {
+		return err
+	}
+	return this.Transport.Flush()
+}
+
+// Implements Transport.Reuse. A broken transport cannot be reused.
+func (this *FaultTransport) Reuse() error {
+	if err := this.brokenWrites(); err != nil {
+		return err
+	}
+	return this.Transport.Reuse()
+}
+
+func (this *FaultTransport) brokenWrites() error {
+	this.injector.lock.Lock()
+	defer this.injector.lock.Unlock()
+	return this.injector.directions[FaultWrite].broken
+}
+
+// Returns every fault that has fired so far.
+func (this *FaultTransport) Fired() []Fault {
+	return this.injector.Fired()
+}
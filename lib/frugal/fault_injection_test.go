@@ -0,0 +1,205 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A service factory that connects to an in-process server, injecting faults
+// into the client side of each connection. The nth connection uses the nth
+// plan; connections after that have no faults.
+type TestFaultFactory struct {
+	server *Server
+	plans  []*FaultPlan
+}
+
+func (this *TestFaultFactory) Connect() (*Connection, error) {
+	client, server := NewMemoryConnPair()
+	go this.server.processRequest(server)
+
+	plan := &FaultPlan{}
+	if len(this.plans) > 0 {
+		plan, this.plans = this.plans[0], this.plans[1:]
+	}
+	socket := NewSocketFromConn(NewFaultConn(client, plan), time.Second)
+	return NewConnectionFromFactory(socket, thrift.NewTBinaryProtocolFactoryDefault()), nil
+}
+
+var _ = Describe("FaultConn", func() {
+	var local, remote *MemoryConn
+
+	BeforeEach(func() {
+		local, remote = NewMemoryConnPair()
+	})
+
+	AfterEach(func() {
+		local.Close()
+		remote.Close()
+	})
+
+	It("Resets reads at a byte offset", func() {
+		conn := NewFaultConn(local, &FaultPlan{
+			Faults: []Fault{{Op: FaultRead, Kind: FaultReset, Offset: 5}},
+		})
+		remote.Write([]byte("hello world"))
+
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		Expect(err).To(BeNil())
+		Expect(string(buf[:n])).To(Equal("hello"))
+
+		_, err = conn.Read(buf)
+		Expect(IsRetryableError(err)).To(BeTrue())
+		_, err = conn.Read(buf)
+		Expect(IsRetryableError(err)).To(BeTrue())
+
+		// The other side sees the connection close.
+		Expect(ioutil.ReadAll(remote)).To(BeEmpty())
+	})
+
+	It("Breaks writes partway through", func() {
+		conn := NewFaultConn(local, &FaultPlan{
+			Faults: []Fault{{Op: FaultWrite, Kind: FaultBrokenPipe, Offset: 3}},
+		})
+
+		n, err := conn.Write([]byte("hello"))
+		Expect(n).To(Equal(3))
+		Expect(IsRetryableError(err)).To(BeTrue())
+
+		received, err := ioutil.ReadAll(remote)
+		Expect(err).To(BeNil())
+		Expect(string(received)).To(Equal("hel"))
+	})
+
+	It("Truncates streams", func() {
+		conn := NewFaultConn(local, &FaultPlan{
+			Faults: []Fault{
+				{Op: FaultWrite, Kind: FaultTruncate, Offset: 4},
+				{Op: FaultRead, Kind: FaultTruncate, Call: 1},
+			},
+		})
+
+		n, err := conn.Write([]byte("truncated"))
+		Expect(n).To(Equal(9))
+		Expect(err).To(BeNil())
+		local.Close()
+		Expect(ioutil.ReadAll(remote)).To(Equal([]byte("trun")))
+
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("Injects timeouts and latency on specific calls", func() {
+		conn := NewFaultConn(local, &FaultPlan{
+			Faults: []Fault{
+				{Op: FaultWrite, Kind: FaultLatency, Call: 1, Delay: 30 * time.Millisecond},
+				{Op: FaultWrite, Kind: FaultTimeout, Call: 2},
+			},
+		})
+
+		started := time.Now()
+		_, err := conn.Write([]byte("slow"))
+		Expect(err).To(BeNil())
+		Expect(time.Since(started)).To(BeNumerically(">=", 30*time.Millisecond))
+
+		_, err = conn.Write([]byte("timeout"))
+		netErr, ok := err.(net.Error)
+		Expect(ok).To(BeTrue())
+		Expect(netErr.Timeout()).To(BeTrue())
+
+		// Timeouts are not permanent.
+		_, err = conn.Write([]byte("fine"))
+		Expect(err).To(BeNil())
+		Expect(conn.Fired()).To(HaveLen(2))
+	})
+
+	It("Is deterministic for a given seed", func() {
+		outcomes := func(seed int64) []bool {
+			conn := NewFaultConn(local, &FaultPlan{
+				Seed:   seed,
+				Faults: []Fault{{Op: FaultWrite, Kind: FaultTimeout, Probability: 0.5}},
+			})
+			results := []bool{}
+			for i := 0; i < 32; i++ {
+				_, err := conn.Write([]byte("x"))
+				results = append(results, err == nil)
+			}
+			return results
+		}
+
+		first := outcomes(42)
+		Expect(outcomes(42)).To(Equal(first))
+		Expect(first).To(ContainElement(true))
+		Expect(first).To(ContainElement(false))
+	})
+})
+
+var _ = Describe("FaultTransport", func() {
+	It("Fails Flush and Reuse once writes are broken", func() {
+		client, server := NewMemorySocketPair(time.Second)
+		defer server.Close()
+
+		transport := NewFaultTransport(client, &FaultPlan{
+			Faults: []Fault{{Op: FaultWrite, Kind: FaultReset, Call: 2}},
+		})
+		_, err := transport.Write([]byte("ok"))
+		Expect(err).To(BeNil())
+		Expect(transport.Flush()).To(BeNil())
+
+		_, err = transport.Write([]byte("reset"))
+		Expect(IsRetryableError(err)).To(BeTrue())
+		Expect(IsRetryableError(transport.Flush())).To(BeTrue())
+		Expect(IsRetryableError(transport.Reuse())).To(BeTrue())
+	})
+})
+
+var _ = Describe("SocketPool with faults", func() {
+	It("Discards connections reset mid-response", func() {
+		server, err := NewServer(NewTestServerCallbacks(nil), &ServerOptions{ListenAddr: "127.0.0.1:0"})
+		Expect(err).To(BeNil())
+
+		pool := NewSocketPool(&TestFaultFactory{
+			server: server,
+			plans: []*FaultPlan{{
+				Faults: []Fault{{Op: FaultRead, Kind: FaultReset, Offset: 6}},
+			}},
+		}, 1)
+		defer pool.Close()
+
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff:    time.Millisecond,
+			IdempotentMethods: []string{"get"},
+		})
+		attempts := 0
+		err = policy.Call(pool, "get", func(conn *Connection) error {
+			attempts++
+			if err := SendTestCall(conn, "get"); err != nil {
+				return err
+			}
+			_, err := ReceiveTestReply(conn)
+			return err
+		})
+		Expect(err).To(BeNil())
+		Expect(attempts).To(Equal(2))
+		Expect(pool.Idle()).To(Equal(1))
+	})
+})
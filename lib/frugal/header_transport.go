@@ -0,0 +1,315 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Protocol ids that can appear in a THeader frame.
+const (
+	HeaderProtocolBinary  = 0
+	HeaderProtocolCompact = 2
+)
+
+const (
+	kHeaderMagic        uint16 = 0x0fff
+	kHeaderInfoKeyValue uint64 = 1
+	kMaxFrameSize       uint32 = 16384000
+)
+
+var ErrBadHeaderFrame = errors.New("malformed header frame")
+
+// Transports that can carry per-message key/value headers.
+type HeaderCarrier interface {
+	// Returns the headers received with the most recently read message.
+	ReadHeaders() map[string]string
+
+	// Sets a header to be sent with the next flushed message.
+	SetWriteHeader(key string, value string)
+}
+
+type headerMode int
+
+const (
+	kHeaderModeUnknown headerMode = iota
+	kHeaderModeHeader
+	kHeaderModeFramed
+	kHeaderModeUnframed
+)
+
+// A transport compatible with Thrift's header protocol (THeaderTransport).
+// Each flushed message is sent as a header frame carrying key/value headers.
+// When reading, plain framed and unframed messages are also accepted, and the
+// transport replies in the same format it last received.
+type HeaderTransport struct {
+	transport  Transport
+	protocolId uint64
+	mode       headerMode
+
+	// Headers from the last frame read, and headers for the next frame written.
+	readHeaders  map[string]string
+	writeHeaders map[string]string
+
+	// The unread remainder of the current frame, and pending writes.
+	readBuffer  bytes.Buffer
+	writeBuffer bytes.Buffer
+}
+
+// Wraps a transport in the header format. The protocol id should describe
+// the protocol used for message payloads, for example HeaderProtocolBinary.
+func NewHeaderTransport(transport Transport, protocolId int) *HeaderTransport {
+	return &HeaderTransport{
+		transport:    transport,
+		protocolId:   uint64(protocolId),
+		readHeaders:  map[string]string{},
+		writeHeaders: map[string]string{},
+	}
+}
+
+// Returns the wrapped transport.
+func (this *HeaderTransport) Underlying() Transport {
+	return this.transport
+}
+
+func (this *HeaderTransport) Open() error {
+	return this.transport.Open()
+}
+
+func (this *HeaderTransport) IsOpen() bool {
+	return this.transport.IsOpen()
+}
+
+func (this *HeaderTransport) Peek() bool {
+	return this.readBuffer.Len() > 0 || this.transport.Peek()
+}
+
+func (this *HeaderTransport) Close() error {
+	return this.transport.Close()
+}
+
+// Implements Transport.Reuse.
+func (this *HeaderTransport) Reuse() error {
+	if this.readBuffer.Len() > 0 {
+		return ErrPendingReads
+	}
+	if this.writeBuffer.Len() > 0 {
+		return ErrPendingWrites
+	}
+	return this.transport.Reuse()
+}
+
+// Implements HeaderCarrier.ReadHeaders.
+func (this *HeaderTransport) ReadHeaders() map[string]string {
+	return this.readHeaders
+}
+
+// Implements HeaderCarrier.SetWriteHeader.
+func (this *HeaderTransport) SetWriteHeader(key string, value string) {
+	this.writeHeaders[key] = value
+}
+
+func (this *HeaderTransport) Read(buf []byte) (int, error) {
+	if this.readBuffer.Len() > 0 {
+		return this.readBuffer.Read(buf)
+	}
+	if this.mode == kHeaderModeUnframed {
+		return this.transport.Read(buf)
+	}
+
+	// Note that if the message turns out to be unframed, the bytes we peeked at
+	// are put back in the read buffer.
+	if err := this.readFrame(); err != nil {
+		return 0, err
+	}
+	return this.readBuffer.Read(buf)
+}
+
+// Reads the next frame into the read buffer, detecting its format.
+func (this *HeaderTransport) readFrame() error {
+	var prefix [4]byte
+	if err := ReceiveAll(this.transport, prefix[:]); err != nil {
+		return err
+	}
+
+	// Unframed binary messages start with a version word that has the high bit
+	// set, which can never be a valid frame length.
+	if prefix[0]&0x80 != 0 {
+		this.mode = kHeaderModeUnframed
+		this.readHeaders = map[string]string{}
+		this.readBuffer.Write(prefix[:])
+		return nil
+	}
+
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > kMaxFrameSize {
+		return fmt.Errorf("frame size %d exceeds the maximum of %d", size, kMaxFrameSize)
+	}
+
+	frame := make([]byte, size)
+	if err := ReceiveAll(this.transport, frame); err != nil {
+		return err
+	}
+
+	if len(frame) < 10 || binary.BigEndian.Uint16(frame[0:2]) != kHeaderMagic {
+		this.mode = kHeaderModeFramed
+		this.readHeaders = map[string]string{}
+		this.readBuffer.Write(frame)
+		return nil
+	}
+
+	this.mode = kHeaderModeHeader
+	return this.parseHeaderFrame(frame)
+}
+
+// Parses a header frame (not including the length prefix).
+func (this *HeaderTransport) parseHeaderFrame(frame []byte) error {
+	// Skip past the magic, flags, and sequence id.
+	headerSize := int(binary.BigEndian.Uint16(frame[8:10])) * 4
+	if 10+headerSize > len(frame) {
+		return ErrBadHeaderFrame
+	}
+	header := bytes.NewReader(frame[10 : 10+headerSize])
+	payload := frame[10+headerSize:]
+
+	protocolId, err := binary.ReadUvarint(header)
+	if err != nil {
+		return ErrBadHeaderFrame
+	}
+	this.protocolId = protocolId
+
+	// We don't support any transforms (such as zlib).
+	transforms, err := binary.ReadUvarint(header)
+	if err != nil {
+		return ErrBadHeaderFrame
+	}
+	if transforms != 0 {
+		return fmt.Errorf("header transforms are not supported")
+	}
+
+	headers := map[string]string{}
+	for header.Len() > 0 {
+		infoId, err := binary.ReadUvarint(header)
+		if err != nil {
+			return ErrBadHeaderFrame
+		}
+		if infoId != kHeaderInfoKeyValue {
+			// Anything else is either padding, or an info block we don't know how
+			// to skip.
+			break
+		}
+
+		count, err := binary.ReadUvarint(header)
+		if err != nil {
+			return ErrBadHeaderFrame
+		}
+		for i := uint64(0); i < count; i++ {
+			key, err := readHeaderString(header)
+			if err != nil {
+				return err
+			}
+			value, err := readHeaderString(header)
+			if err != nil {
+				return err
+			}
+			headers[key] = value
+		}
+	}
+
+	this.readHeaders = headers
+	this.readBuffer.Write(payload)
+	return nil
+}
+
+func readHeaderString(reader *bytes.Reader) (string, error) {
+	size, err := binary.ReadUvarint(reader)
+	if err != nil || size > uint64(reader.Len()) {
+		return "", ErrBadHeaderFrame
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", ErrBadHeaderFrame
+	}
+	return string(buf), nil
+}
+
+func (this *HeaderTransport) Write(buf []byte) (int, error) {
+	return this.writeBuffer.Write(buf)
+}
+
+// Sends all pending writes as a single message, then clears the write
+// headers.
+func (this *HeaderTransport) Flush() error {
+	payload := this.writeBuffer.Bytes()
+	defer this.writeBuffer.Reset()
+
+	var frame []byte
+	switch this.mode {
+	case kHeaderModeUnframed:
+		frame = payload
+	case kHeaderModeFramed:
+		frame = make([]byte, 4, 4+len(payload))
+		binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+		frame = append(frame, payload...)
+	default:
+		frame = this.buildHeaderFrame(payload)
+	}
+	this.writeHeaders = map[string]string{}
+
+	if _, err := this.transport.Write(frame); err != nil {
+		return err
+	}
+	return this.transport.Flush()
+}
+
+func (this *HeaderTransport) buildHeaderFrame(payload []byte) []byte {
+	header := new(bytes.Buffer)
+	writeUvarint(header, this.protocolId)
+	writeUvarint(header, 0)
+
+	if len(this.writeHeaders) > 0 {
+		writeUvarint(header, kHeaderInfoKeyValue)
+		writeUvarint(header, uint64(len(this.writeHeaders)))
+		for key, value := range this.writeHeaders {
+			writeUvarint(header, uint64(len(key)))
+			header.WriteString(key)
+			writeUvarint(header, uint64(len(value)))
+			header.WriteString(value)
+		}
+	}
+
+	// The header is padded to a multiple of four bytes.
+	for header.Len()%4 != 0 {
+		header.WriteByte(0)
+	}
+
+	frame := make([]byte, 14, 14+header.Len()+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(10+header.Len()+len(payload)))
+	binary.BigEndian.PutUint16(frame[4:6], kHeaderMagic)
+	binary.BigEndian.PutUint16(frame[6:8], 0)
+	binary.BigEndian.PutUint32(frame[8:12], 0)
+	binary.BigEndian.PutUint16(frame[12:14], uint16(header.Len()/4))
+	frame = append(frame, header.Bytes()...)
+	return append(frame, payload...)
+}
+
+func writeUvarint(buffer *bytes.Buffer, value uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], value)
+	buffer.Write(buf[:n])
+}
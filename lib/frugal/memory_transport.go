@@ -0,0 +1,206 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// Returned by reads on a MemoryConn that pass their deadline.
+type memoryTimeoutError struct{}
+
+func (this memoryTimeoutError) Error() string   { return "i/o timeout" }
+func (this memoryTimeoutError) Timeout() bool   { return true }
+func (this memoryTimeoutError) Temporary() bool { return true }
+
+// One direction of a MemoryConn pair. Writes never block; reads block until
+// there is data, the pipe is closed, or the deadline passes.
+type memoryPipe struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	buffer   bytes.Buffer
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newMemoryPipe() *memoryPipe {
+	pipe := &memoryPipe{}
+	pipe.cond = sync.NewCond(&pipe.lock)
+	return pipe
+}
+
+func (this *memoryPipe) read(buf []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for this.buffer.Len() == 0 {
+		if this.closed {
+			return 0, io.EOF
+		}
+		if !this.deadline.IsZero() && !time.Now().Before(this.deadline) {
+			return 0, memoryTimeoutError{}
+		}
+		this.cond.Wait()
+	}
+	return this.buffer.Read(buf)
+}
+
+func (this *memoryPipe) write(buf []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, _ := this.buffer.Write(buf)
+	this.cond.Broadcast()
+	return n, nil
+}
+
+func (this *memoryPipe) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.closed = true
+	if this.timer != nil {
+		this.timer.Stop()
+	}
+	this.cond.Broadcast()
+}
+
+func (this *memoryPipe) setDeadline(deadline time.Time) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.deadline = deadline
+	if this.timer != nil {
+		this.timer.Stop()
+		this.timer = nil
+	}
+	if !deadline.IsZero() {
+		// Wake up blocked readers so they notice the deadline passed.
+		this.timer = time.AfterFunc(time.Until(deadline), func() {
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			this.cond.Broadcast()
+		})
+	}
+	this.cond.Broadcast()
+}
+
+// The address of either end of a MemoryConn pair.
+type memoryAddr struct{}
+
+func (this memoryAddr) Network() string { return "memory" }
+func (this memoryAddr) String() string  { return "memory" }
+
+// One end of an in-memory, buffered net.Conn pair. Unlike net.Pipe(), writes
+// are buffered, so one side can flush a whole message before the other side
+// reads it. Closing either end makes the other end read EOF once it has
+// drained the buffer, and fail to write.
+type MemoryConn struct {
+	in  *memoryPipe
+	out *memoryPipe
+}
+
+// Returns two connected ends of an in-memory connection.
+func NewMemoryConnPair() (*MemoryConn, *MemoryConn) {
+	first, second := newMemoryPipe(), newMemoryPipe()
+	return &MemoryConn{first, second}, &MemoryConn{second, first}
+}
+
+func (this *MemoryConn) Read(buf []byte) (int, error) {
+	return this.in.read(buf)
+}
+
+func (this *MemoryConn) Write(buf []byte) (int, error) {
+	return this.out.write(buf)
+}
+
+func (this *MemoryConn) Close() error {
+	this.in.close()
+	this.out.close()
+	return nil
+}
+
+func (this *MemoryConn) LocalAddr() net.Addr {
+	return memoryAddr{}
+}
+
+func (this *MemoryConn) RemoteAddr() net.Addr {
+	return memoryAddr{}
+}
+
+func (this *MemoryConn) SetDeadline(t time.Time) error {
+	return this.SetReadDeadline(t)
+}
+
+func (this *MemoryConn) SetReadDeadline(t time.Time) error {
+	this.in.setDeadline(t)
+	return nil
+}
+
+// Writes never block, so write deadlines are ignored.
+func (this *MemoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Returns two Sockets connected to each other in memory. They behave exactly
+// like network sockets, including ErrPendingReads and ErrPendingWrites from
+// Reuse() and Read(), so they are a drop-in replacement for tests.
+func NewMemorySocketPair(timeout time.Duration) (*Socket, *Socket) {
+	first, second := NewMemoryConnPair()
+	return NewSocketFromConn(first, timeout), NewSocketFromConn(second, timeout)
+}
+
+// A service factory that connects to a Server in the same process, without
+// any networking. The server does not need to be listening; each connection
+// is handed directly to it.
+type MemoryServiceFactory struct {
+	server  *Server
+	factory thrift.TProtocolFactory
+	timeout time.Duration
+}
+
+// Creates a factory for in-memory connections to a server. If the protocol
+// factory is nil, the binary protocol is used.
+func NewMemoryServiceFactory(server *Server, factory thrift.TProtocolFactory, timeout time.Duration) *MemoryServiceFactory {
+	if factory == nil {
+		factory = thrift.NewTBinaryProtocolFactoryDefault()
+	}
+	return &MemoryServiceFactory{
+		server:  server,
+		factory: factory,
+		timeout: timeout,
+	}
+}
+
+// Implements ServiceFactory.Connect. If the server is at its connection
+// limit, ErrTooManyConnections is returned.
+func (this *MemoryServiceFactory) Connect() (*Connection, error) {
+	if !this.server.tryAcquireConnection() {
+		return nil, ErrTooManyConnections
+	}
+
+	client, server := NewMemoryConnPair()
+	go this.server.processRequest(server)
+	return NewConnectionFromFactory(NewSocketFromConn(client, this.timeout), this.factory), nil
+}
@@ -0,0 +1,135 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"io"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MemorySocketPair", func() {
+	It("Delivers flushed writes", func() {
+		client, server := NewMemorySocketPair(time.Second)
+		defer client.Close()
+		defer server.Close()
+
+		client.Write([]byte("hello"))
+		Expect(client.Flush()).To(BeNil())
+
+		buf := make([]byte, 5)
+		Expect(ReceiveAll(server, buf)).To(BeNil())
+		Expect(string(buf)).To(Equal("hello"))
+		Expect(server.Reuse()).To(BeNil())
+	})
+
+	It("Mirrors Socket's pending read and write errors", func() {
+		client, server := NewMemorySocketPair(time.Second)
+		defer client.Close()
+		defer server.Close()
+
+		client.Write([]byte("unflushed"))
+		_, err := client.Read(make([]byte, 1))
+		Expect(err).To(Equal(ErrPendingWrites))
+		Expect(client.Reuse()).To(Equal(ErrPendingWrites))
+		Expect(client.Flush()).To(BeNil())
+
+		buf := make([]byte, 2)
+		Expect(ReceiveAll(server, buf)).To(BeNil())
+		Expect(server.Reuse()).To(Equal(ErrPendingReads))
+	})
+
+	It("Reads EOF after the other side closes", func() {
+		client, server := NewMemorySocketPair(time.Second)
+		defer server.Close()
+
+		client.Write([]byte("bye"))
+		Expect(client.Flush()).To(BeNil())
+		client.Close()
+
+		buf := make([]byte, 3)
+		Expect(ReceiveAll(server, buf)).To(BeNil())
+		_, err := server.Read(buf)
+		Expect(err).To(Equal(io.EOF))
+
+		server.Write([]byte("late"))
+		Expect(server.Flush()).To(Equal(io.ErrClosedPipe))
+
+		_, err = client.Read(buf)
+		Expect(err).To(Equal(ErrSocketClosed))
+	})
+
+	It("Times out reads", func() {
+		client, server := NewMemorySocketPair(20 * time.Millisecond)
+		defer client.Close()
+		defer server.Close()
+
+		_, err := server.Read(make([]byte, 1))
+		netErr, ok := err.(net.Error)
+		Expect(ok).To(BeTrue())
+		Expect(netErr.Timeout()).To(BeTrue())
+	})
+})
+
+var _ = Describe("MemoryServiceFactory", func() {
+	It("Connects to a server without networking", func() {
+		callbacks := NewTestServerCallbacks(nil)
+		server, err := NewServer(callbacks, &ServerOptions{ListenAddr: "127.0.0.1:0"})
+		Expect(err).To(BeNil())
+
+		pool := NewSocketPool(NewMemoryServiceFactory(server, nil, time.Second), 1)
+		defer pool.Close()
+
+		for i := 0; i < 2; i++ {
+			conn, err := pool.Get()
+			Expect(err).To(BeNil())
+			Expect(SendTestCall(conn, "memory")).To(BeNil())
+			name, err := ReceiveTestReply(conn)
+			Expect(err).To(BeNil())
+			Expect(name).To(Equal("memory"))
+			pool.Put(conn, &err)
+		}
+		Expect(server.ActiveConnections()).To(Equal(int64(1)))
+
+		pool.Close()
+		Eventually(server.ActiveConnections).Should(Equal(int64(0)))
+	})
+
+	It("Respects the server's connection limit", func() {
+		server, err := NewServer(NewTestServerCallbacks(nil), &ServerOptions{
+			ListenAddr:     "127.0.0.1:0",
+			MaxConnections: 1,
+		})
+		Expect(err).To(BeNil())
+
+		factory := NewMemoryServiceFactory(server, nil, time.Second)
+		conn, err := factory.Connect()
+		Expect(err).To(BeNil())
+
+		_, err = factory.Connect()
+		Expect(err).To(Equal(ErrTooManyConnections))
+
+		conn.Transport().Close()
+		Eventually(func() error {
+			conn, err := factory.Connect()
+			if err == nil {
+				conn.Transport().Close()
+			}
+			return err
+		}).Should(BeNil())
+	})
+})
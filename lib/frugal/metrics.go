@@ -0,0 +1,244 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Hooks for collecting metrics from a Server or SocketPool. Implementations
+// must be safe to call from multiple goroutines.
+type Metrics interface {
+	// Called by Server after each call to ProcessRequest(), with the time it
+	// took and the error it returned, if any.
+	RequestProcessed(method string, duration time.Duration, err error)
+
+	// Called by SocketPool at the end of Get(). If reused is true, the
+	// connection came from the idle list rather than being dialed.
+	PoolGet(reused bool, duration time.Duration, err error)
+
+	// Called by SocketPool at the end of Put(). If kept is false, the
+	// connection was closed instead of being returned to the idle list.
+	PoolPut(kept bool)
+
+	// Called by SocketPool after each call to ServiceFactory.Connect().
+	PoolDial(duration time.Duration, err error)
+
+	// Called by SocketPool when an idle connection fails Transport.Reuse().
+	PoolReuseFailed(err error)
+
+	// Called by SocketPool.Close() with the number of idle connections it closed.
+	PoolClosed(closed int)
+}
+
+// Default histogram bucket upper bounds, in seconds.
+var DefaultLatencyBuckets = []float64{
+	0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// A cumulative histogram of observed values. Counts[i] is the number of
+// observations less than or equal to Bounds[i]; observations over the last
+// bound are only reflected in Count.
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)),
+	}
+}
+
+// Records a single value.
+func (this *Histogram) Observe(value float64) {
+	for i, bound := range this.Bounds {
+		if value <= bound {
+			this.Counts[i]++
+		}
+	}
+	this.Count++
+	this.Sum += value
+}
+
+func (this *Histogram) copy() *Histogram {
+	other := *this
+	other.Counts = append([]uint64(nil), this.Counts...)
+	return &other
+}
+
+// Request statistics for a single method.
+type MethodStats struct {
+	Requests uint64
+	Errors   uint64
+	Latency  *Histogram
+}
+
+// Statistics for a SocketPool.
+type PoolStats struct {
+	Gets          uint64
+	GetErrors     uint64
+	Reuses        uint64
+	GetLatency    *Histogram
+	Puts          uint64
+	Discards      uint64
+	Dials         uint64
+	DialErrors    uint64
+	DialLatency   *Histogram
+	ReuseFailures uint64
+	Closes        uint64
+	ClosedIdle    uint64
+}
+
+// An in-memory implementation of Metrics. It keeps counters and latency
+// histograms which can be read back with Methods() and Pool().
+type MemoryMetrics struct {
+	buckets []float64
+	lock    sync.Mutex
+	methods map[string]*MethodStats
+	pool    PoolStats
+}
+
+// Creates a new in-memory metrics collector. If buckets is nil,
+// DefaultLatencyBuckets is used.
+func NewMemoryMetrics(buckets []float64) *MemoryMetrics {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	return &MemoryMetrics{
+		buckets: buckets,
+		methods: map[string]*MethodStats{},
+		pool: PoolStats{
+			GetLatency:  NewHistogram(buckets),
+			DialLatency: NewHistogram(buckets),
+		},
+	}
+}
+
+// Implements Metrics.RequestProcessed.
+func (this *MemoryMetrics) RequestProcessed(method string, duration time.Duration, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	stats, ok := this.methods[method]
+	if !ok {
+		stats = &MethodStats{Latency: NewHistogram(this.buckets)}
+		this.methods[method] = stats
+	}
+	stats.Requests++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.Latency.Observe(duration.Seconds())
+}
+
+// Implements Metrics.PoolGet.
+func (this *MemoryMetrics) PoolGet(reused bool, duration time.Duration, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pool.Gets++
+	if err != nil {
+		this.pool.GetErrors++
+	}
+	if reused {
+		this.pool.Reuses++
+	}
+	this.pool.GetLatency.Observe(duration.Seconds())
+}
+
+// Implements Metrics.PoolPut.
+func (this *MemoryMetrics) PoolPut(kept bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pool.Puts++
+	if !kept {
+		this.pool.Discards++
+	}
+}
+
+// Implements Metrics.PoolDial.
+func (this *MemoryMetrics) PoolDial(duration time.Duration, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pool.Dials++
+	if err != nil {
+		this.pool.DialErrors++
+	}
+	this.pool.DialLatency.Observe(duration.Seconds())
+}
+
+// Implements Metrics.PoolReuseFailed.
+func (this *MemoryMetrics) PoolReuseFailed(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pool.ReuseFailures++
+}
+
+// Implements Metrics.PoolClosed.
+func (this *MemoryMetrics) PoolClosed(closed int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.pool.Closes++
+	this.pool.ClosedIdle += uint64(closed)
+}
+
+// Returns a copy of the per-method request statistics.
+func (this *MemoryMetrics) Methods() map[string]MethodStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	methods := map[string]MethodStats{}
+	for name, stats := range this.methods {
+		methods[name] = MethodStats{
+			Requests: stats.Requests,
+			Errors:   stats.Errors,
+			Latency:  stats.Latency.copy(),
+		}
+	}
+	return methods
+}
+
+// Returns the names of all methods seen so far, in sorted order.
+func (this *MemoryMetrics) MethodNames() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	names := []string{}
+	for name := range this.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns a copy of the socket pool statistics.
+func (this *MemoryMetrics) Pool() PoolStats {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	stats := this.pool
+	stats.GetLatency = this.pool.GetLatency.copy()
+	stats.DialLatency = this.pool.DialLatency.copy()
+	return stats
+}
@@ -0,0 +1,146 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Renders a MemoryMetrics collector in the Prometheus text exposition format.
+// It can be mounted directly as an HTTP handler.
+type PrometheusExporter struct {
+	metrics *MemoryMetrics
+
+	// Prepended to every metric name, for example "frugal".
+	prefix string
+}
+
+func NewPrometheusExporter(metrics *MemoryMetrics, prefix string) *PrometheusExporter {
+	return &PrometheusExporter{
+		metrics: metrics,
+		prefix:  prefix,
+	}
+}
+
+// Writes the current metrics to the given writer.
+func (this *PrometheusExporter) WriteTo(w io.Writer) (int64, error) {
+	buffer := new(bytes.Buffer)
+
+	// Names come from the same snapshot as the stats, since methods can be
+	// recorded for the first time while this runs.
+	methods := this.metrics.Methods()
+	names := []string{}
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	this.header(buffer, "server_requests_total", "counter", "Requests processed by the server.")
+	for _, name := range names {
+		stats := methods[name]
+		this.sample(buffer, "server_requests_total", labels("method", name, "outcome", "ok"), float64(stats.Requests-stats.Errors))
+		this.sample(buffer, "server_requests_total", labels("method", name, "outcome", "error"), float64(stats.Errors))
+	}
+
+	this.header(buffer, "server_request_duration_seconds", "histogram", "Time spent processing requests.")
+	for _, name := range names {
+		this.histogram(buffer, "server_request_duration_seconds", labels("method", name), methods[name].Latency)
+	}
+
+	pool := this.metrics.Pool()
+	this.counter(buffer, "pool_gets_total", "Calls to SocketPool.Get().", float64(pool.Gets))
+	this.counter(buffer, "pool_get_errors_total", "Calls to SocketPool.Get() that failed.", float64(pool.GetErrors))
+	this.counter(buffer, "pool_reuses_total", "Connections handed out from the idle list.", float64(pool.Reuses))
+	this.header(buffer, "pool_get_duration_seconds", "histogram", "Time spent in SocketPool.Get().")
+	this.histogram(buffer, "pool_get_duration_seconds", "", pool.GetLatency)
+	this.counter(buffer, "pool_puts_total", "Calls to SocketPool.Put().", float64(pool.Puts))
+	this.counter(buffer, "pool_discards_total", "Connections closed instead of kept idle.", float64(pool.Discards))
+	this.counter(buffer, "pool_dials_total", "New connections dialed.", float64(pool.Dials))
+	this.counter(buffer, "pool_dial_errors_total", "New connections that failed to dial.", float64(pool.DialErrors))
+	this.header(buffer, "pool_dial_duration_seconds", "histogram", "Time spent dialing new connections.")
+	this.histogram(buffer, "pool_dial_duration_seconds", "", pool.DialLatency)
+	this.counter(buffer, "pool_reuse_failures_total", "Idle connections that could not be reused.", float64(pool.ReuseFailures))
+	this.counter(buffer, "pool_closes_total", "Calls to SocketPool.Close().", float64(pool.Closes))
+	this.counter(buffer, "pool_closed_idle_total", "Idle connections closed by SocketPool.Close().", float64(pool.ClosedIdle))
+
+	n, err := w.Write(buffer.Bytes())
+	return int64(n), err
+}
+
+// Implements http.Handler.
+func (this *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	this.WriteTo(w)
+}
+
+func (this *PrometheusExporter) name(name string) string {
+	if this.prefix == "" {
+		return name
+	}
+	return this.prefix + "_" + name
+}
+
+func (this *PrometheusExporter) header(buffer *bytes.Buffer, name string, kind string, help string) {
+	fmt.Fprintf(buffer, "# HELP %s %s\n", this.name(name), help)
+	fmt.Fprintf(buffer, "# TYPE %s %s\n", this.name(name), kind)
+}
+
+func (this *PrometheusExporter) sample(buffer *bytes.Buffer, name string, labels string, value float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(buffer, "%s%s %s\n", this.name(name), labels, formatFloat(value))
+}
+
+func (this *PrometheusExporter) counter(buffer *bytes.Buffer, name string, help string, value float64) {
+	this.header(buffer, name, "counter", help)
+	this.sample(buffer, name, "", value)
+}
+
+func (this *PrometheusExporter) histogram(buffer *bytes.Buffer, name string, extra string, histogram *Histogram) {
+	join := func(le string) string {
+		if extra == "" {
+			return labels("le", le)
+		}
+		return extra + "," + labels("le", le)
+	}
+
+	for i, bound := range histogram.Bounds {
+		this.sample(buffer, name+"_bucket", join(formatFloat(bound)), float64(histogram.Counts[i]))
+	}
+	this.sample(buffer, name+"_bucket", join("+Inf"), float64(histogram.Count))
+	this.sample(buffer, name+"_sum", extra, histogram.Sum)
+	this.sample(buffer, name+"_count", extra, float64(histogram.Count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Formats key/value pairs as a Prometheus label list, without braces.
+func labels(pairs ...string) string {
+	parts := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,125 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A service factory that dials a test server.
+type TestServerFactory struct {
+	server *Server
+}
+
+func (this *TestServerFactory) Connect() (*Connection, error) {
+	socket, err := NewSocket(this.server.Addr().String(), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnectionFromFactory(socket, thrift.NewTBinaryProtocolFactoryDefault()), nil
+}
+
+var _ = Describe("Metrics", func() {
+	It("Buckets histogram observations", func() {
+		histogram := NewHistogram([]float64{1, 2})
+		histogram.Observe(0.5)
+		histogram.Observe(1.5)
+		histogram.Observe(3)
+		Expect(histogram.Counts).To(Equal([]uint64{1, 2}))
+		Expect(histogram.Count).To(Equal(uint64(3)))
+		Expect(histogram.Sum).To(Equal(5.0))
+	})
+
+	It("Records server requests per method", func() {
+		metrics := NewMemoryMetrics(nil)
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{
+			Metrics: metrics,
+		})
+		defer server.Stop()
+
+		conn := DialTestServer(server)
+		defer conn.Transport().Close()
+		for _, method := range []string{"a", "b", "a"} {
+			Expect(SendTestCall(conn, method)).To(BeNil())
+			_, err := ReceiveTestReply(conn)
+			Expect(err).To(BeNil())
+		}
+
+		Eventually(func() uint64 {
+			return metrics.Methods()["a"].Requests
+		}).Should(Equal(uint64(2)))
+		Expect(metrics.MethodNames()).To(Equal([]string{"a", "b"}))
+		Expect(metrics.Methods()["a"].Errors).To(Equal(uint64(0)))
+		Expect(metrics.Methods()["a"].Latency.Count).To(Equal(uint64(2)))
+	})
+
+	It("Records socket pool events", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		metrics := NewMemoryMetrics(nil)
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle: 1,
+			Metrics: metrics,
+		})
+
+		first, err := pool.Get()
+		Expect(err).To(BeNil())
+		second, err := pool.Get()
+		Expect(err).To(BeNil())
+		pool.Put(first, &err)
+		pool.Put(second, &err)
+
+		_, err = pool.Get()
+		Expect(err).To(BeNil())
+
+		failed := errors.New("failed")
+		pool.Put(first, &failed)
+		pool.Close()
+
+		stats := metrics.Pool()
+		Expect(stats.Gets).To(Equal(uint64(3)))
+		Expect(stats.Reuses).To(Equal(uint64(1)))
+		Expect(stats.Dials).To(Equal(uint64(2)))
+		Expect(stats.DialLatency.Count).To(Equal(uint64(2)))
+		Expect(stats.Puts).To(Equal(uint64(3)))
+		Expect(stats.Discards).To(Equal(uint64(2)))
+		Expect(stats.Closes).To(Equal(uint64(1)))
+	})
+
+	It("Renders the Prometheus text format", func() {
+		metrics := NewMemoryMetrics([]float64{0.1, 1})
+		metrics.RequestProcessed("get\"Thing", 50*time.Millisecond, nil)
+		metrics.RequestProcessed("get\"Thing", 2*time.Second, errors.New("failed"))
+
+		buffer := new(bytes.Buffer)
+		_, err := NewPrometheusExporter(metrics, "frugal").WriteTo(buffer)
+		Expect(err).To(BeNil())
+
+		text := buffer.String()
+		Expect(text).To(ContainSubstring("# TYPE frugal_server_requests_total counter\n"))
+		Expect(text).To(ContainSubstring(`frugal_server_requests_total{method="get\"Thing",outcome="ok"} 1` + "\n"))
+		Expect(text).To(ContainSubstring(`frugal_server_requests_total{method="get\"Thing",outcome="error"} 1` + "\n"))
+		Expect(text).To(ContainSubstring(`frugal_server_request_duration_seconds_bucket{method="get\"Thing",le="0.1"} 1` + "\n"))
+		Expect(text).To(ContainSubstring(`frugal_server_request_duration_seconds_bucket{method="get\"Thing",le="+Inf"} 2` + "\n"))
+		Expect(text).To(ContainSubstring(`frugal_server_request_duration_seconds_count{method="get\"Thing"} 2` + "\n"))
+		Expect(text).To(ContainSubstring("frugal_pool_gets_total 0\n"))
+	})
+})
@@ -0,0 +1,108 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+// Separates the service name from the method name in multiplexed messages.
+// This is the same separator used by Thrift's TMultiplexedProtocol.
+const MultiplexedSeparator = ":"
+
+// A processor that hosts several services on one server. Clients using
+// TMultiplexedProtocol prefix each method name with "service:", and the
+// multiplexer uses that prefix to pick a processor. For example:
+//
+//     mux := NewMultiplexer()
+//     mux.Register("Users", usersProcessor)
+//     mux.Register("Groups", groupsProcessor)
+//
+// A ServerInterface can then forward ProcessRequest() to the multiplexer.
+type Multiplexer struct {
+	lock       sync.RWMutex
+	processors map[string]Processor
+
+	// Handles messages with no service prefix, if non-nil.
+	fallback Processor
+}
+
+func NewMultiplexer() *Multiplexer {
+	return &Multiplexer{
+		processors: map[string]Processor{},
+	}
+}
+
+// Registers the processor for a service, replacing any previous one.
+func (this *Multiplexer) Register(service string, processor Processor) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.processors[service] = processor
+}
+
+// Registers a processor for messages without a service prefix, so that
+// clients not using TMultiplexedProtocol keep working.
+func (this *Multiplexer) RegisterDefault(processor Processor) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.fallback = processor
+}
+
+// Returns the names of all registered services.
+func (this *Multiplexer) Services() []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	names := []string{}
+	for name := range this.processors {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Implements Processor.ProcessRequest. The request is passed on with its
+// ServiceName set and the prefix removed from MethodName. Requests for
+// unknown services are rejected with a TApplicationException.
+func (this *Multiplexer) ProcessRequest(request *Request) error {
+	index := strings.Index(request.MethodName, MultiplexedSeparator)
+
+	this.lock.RLock()
+	var processor Processor
+	if index == -1 {
+		processor = this.fallback
+	} else {
+		processor = this.processors[request.MethodName[:index]]
+	}
+	this.lock.RUnlock()
+
+	if processor == nil {
+		message := fmt.Sprintf("unknown service for method: %s", request.MethodName)
+		if index != -1 {
+			message = fmt.Sprintf("unknown service: %s", request.MethodName[:index])
+		}
+		return request.Reject(thrift.UNKNOWN_METHOD, message)
+	}
+	if index == -1 {
+		return processor.ProcessRequest(request)
+	}
+
+	dispatched := *request
+	dispatched.ServiceName = request.MethodName[:index]
+	dispatched.MethodName = request.MethodName[index+len(MultiplexedSeparator):]
+	return processor.ProcessRequest(&dispatched)
+}
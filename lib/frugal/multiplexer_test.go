@@ -0,0 +1,181 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A processor that remembers which service and method it was called with,
+// and replies with an empty result.
+type TestRecordingProcessor struct {
+	lock  sync.Mutex
+	calls []string
+}
+
+func (this *TestRecordingProcessor) Calls() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]string(nil), this.calls...)
+}
+
+func (this *TestRecordingProcessor) ProcessRequest(request *Request) error {
+	this.lock.Lock()
+	this.calls = append(this.calls, request.ServiceName+"/"+request.MethodName)
+	this.lock.Unlock()
+
+	if err := request.Input.Skip(thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := request.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if err := request.Output.WriteMessageBegin(request.MethodName, thrift.REPLY, request.SequenceId); err != nil {
+		return err
+	}
+	if err := request.Output.WriteStructBegin("result"); err != nil {
+		return err
+	}
+	if err := request.Output.WriteFieldStop(); err != nil {
+		return err
+	}
+	if err := request.Output.WriteStructEnd(); err != nil {
+		return err
+	}
+	if err := request.Output.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return request.Output.Flush()
+}
+
+// Server callbacks that forward every request to a processor.
+type TestProcessorCallbacks struct {
+	*TestServerCallbacks
+	processor Processor
+}
+
+func (this *TestProcessorCallbacks) ProcessRequest(request *Request) error {
+	return this.processor.ProcessRequest(request)
+}
+
+// Sends a call with an empty argument struct.
+func SendTestArgsCall(conn *Connection, method string) error {
+	oprot := conn.Output()
+	if err := oprot.WriteMessageBegin(method, thrift.CALL, 1); err != nil {
+		return err
+	}
+	if err := oprot.WriteStructBegin("args"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	if err := oprot.WriteStructEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+// Receives a reply, returning its name, and the exception if it was one.
+func ReceiveTestResult(conn *Connection) (string, thrift.TApplicationException, error) {
+	iprot := conn.Input()
+	name, msgType, _, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if msgType == thrift.EXCEPTION {
+		exception, err := thrift.NewTApplicationException(0, "").Read(iprot)
+		if err != nil {
+			return "", nil, err
+		}
+		return name, exception, iprot.ReadMessageEnd()
+	}
+
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return "", nil, err
+	}
+	return name, nil, iprot.ReadMessageEnd()
+}
+
+var _ = Describe("Multiplexer", func() {
+	var users, groups *TestRecordingProcessor
+	var mux *Multiplexer
+	var server *Server
+	var conn *Connection
+
+	BeforeEach(func() {
+		users = &TestRecordingProcessor{}
+		groups = &TestRecordingProcessor{}
+		mux = NewMultiplexer()
+		mux.Register("Users", users)
+		mux.Register("Groups", groups)
+
+		server = StartTestServer(&TestProcessorCallbacks{NewTestServerCallbacks(nil), mux}, &ServerOptions{})
+		conn = DialTestServer(server)
+	})
+
+	AfterEach(func() {
+		conn.Transport().Close()
+		server.Stop()
+	})
+
+	call := func(method string) (string, thrift.TApplicationException) {
+		Expect(SendTestArgsCall(conn, method)).To(BeNil())
+		name, exception, err := ReceiveTestResult(conn)
+		Expect(err).To(BeNil())
+		return name, exception
+	}
+
+	It("Dispatches on the service prefix", func() {
+		name, exception := call("Users:get")
+		Expect(exception).To(BeNil())
+		Expect(name).To(Equal("get"))
+
+		_, exception = call("Groups:list")
+		Expect(exception).To(BeNil())
+
+		Expect(users.Calls()).To(Equal([]string{"Users/get"}))
+		Expect(groups.Calls()).To(Equal([]string{"Groups/list"}))
+	})
+
+	It("Rejects unknown services", func() {
+		name, exception := call("Nope:get")
+		Expect(name).To(Equal("Nope:get"))
+		Expect(exception).NotTo(BeNil())
+		Expect(exception.TypeId()).To(Equal(int32(thrift.UNKNOWN_METHOD)))
+		Expect(exception.Error()).To(Equal("unknown service: Nope"))
+
+		// The connection is still usable afterwards.
+		_, exception = call("Users:get")
+		Expect(exception).To(BeNil())
+	})
+
+	It("Sends unprefixed methods to the default processor", func() {
+		_, exception := call("get")
+		Expect(exception).NotTo(BeNil())
+
+		mux.RegisterDefault(users)
+		_, exception = call("get")
+		Expect(exception).To(BeNil())
+		Expect(users.Calls()).To(Equal([]string{"/get"}))
+	})
+})
@@ -0,0 +1,338 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+)
+
+var ErrCallTimeout = errors.New("call timed out waiting for a reply")
+var ErrClientClosed = errors.New("client was closed")
+
+// Options that can be passed to NewPipelinedClient().
+type PipelinedClientOptions struct {
+	// Timeout for dialing and for writes.
+	Timeout time.Duration
+
+	// How long Call() waits for a reply. If zero, it waits forever.
+	CallTimeout time.Duration
+
+	// Protocol for messages. If nil, the binary protocol is used.
+	ProtocolFactory thrift.TProtocolFactory
+
+	// Whether or not to use framing.
+	Framed bool
+
+	// If non-nil, the connection is made over TLS.
+	TLSConfig *tls.Config
+}
+
+// A call waiting for its reply.
+type pendingCall struct {
+	result func(iprot thrift.TProtocol) error
+	done   chan error
+}
+
+// A single connection of a PipelinedClient. Reads and writes go through
+// separate sockets over the same net.Conn, since a Socket cannot be read
+// while it has pending writes.
+type pipelinedConn struct {
+	cn    net.Conn
+	iprot thrift.TProtocol
+	oprot thrift.TProtocol
+
+	// Serializes writing whole messages.
+	writeLock sync.Mutex
+
+	// Calls waiting for replies, by sequence id. This is nil once the
+	// connection has failed. Guarded by the client's lock.
+	pending map[int32]*pendingCall
+}
+
+// A client connection that can have many calls in flight at once. Each call
+// gets its own sequence id, and a reader goroutine matches replies to calls
+// as they arrive, in any order. If the connection breaks, every waiting call
+// fails with the error, and the next call reconnects.
+//
+// Unlike a Connection from a SocketPool, a PipelinedClient is safe to use
+// from many goroutines. Calls are made with callbacks that write arguments
+// and read results; for example, with Thrift-generated structs:
+//
+//     err := client.Call("getUser", args.Write, result.Read)
+type PipelinedClient struct {
+	hostAndPort string
+	options     PipelinedClientOptions
+
+	lock      sync.Mutex
+	conn      *pipelinedConn
+	nextSeqId int32
+	closed    bool
+
+	// Tracks reader goroutines, so Close() can wait for them.
+	readers sync.WaitGroup
+}
+
+// Creates a pipelined client and makes its first connection.
+func NewPipelinedClient(hostAndPort string, options *PipelinedClientOptions) (*PipelinedClient, error) {
+	client := &PipelinedClient{
+		hostAndPort: hostAndPort,
+		options:     *options,
+	}
+	if client.options.ProtocolFactory == nil {
+		client.options.ProtocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if _, err := client.connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Returns the current connection, dialing a new one if needed. The lock must
+// be held.
+func (this *PipelinedClient) connect() (*pipelinedConn, error) {
+	if this.closed {
+		return nil, ErrClientClosed
+	}
+	if this.conn != nil {
+		return this.conn, nil
+	}
+
+	cn, err := dialHostAndPort(this.hostAndPort, this.options.Timeout, this.options.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies can take any amount of time, so the read side has no timeout;
+	// calls time out on their own instead.
+	var reader, writer thrift.TTransport = NewSocketFromConn(cn, 0), NewSocketFromConn(cn, this.options.Timeout)
+	if this.options.Framed {
+		reader = thrift.NewTFramedTransport(reader)
+		writer = thrift.NewTFramedTransport(writer)
+	}
+
+	conn := &pipelinedConn{
+		cn:      cn,
+		iprot:   this.options.ProtocolFactory.GetProtocol(reader),
+		oprot:   this.options.ProtocolFactory.GetProtocol(writer),
+		pending: map[int32]*pendingCall{},
+	}
+	this.conn = conn
+
+	this.readers.Add(1)
+	go this.read(conn)
+	return conn, nil
+}
+
+// Registers a call and assigns its sequence id.
+func (this *PipelinedClient) register(call *pendingCall) (*pipelinedConn, int32, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	conn, err := this.connect()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	this.nextSeqId++
+	seqId := this.nextSeqId
+	if call != nil {
+		conn.pending[seqId] = call
+	}
+	return conn, seqId, nil
+}
+
+// Removes a call that stopped waiting. Returns false if the reader has
+// already taken the call, in which case it is about to complete.
+func (this *PipelinedClient) abandon(conn *pipelinedConn, seqId int32) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if _, ok := conn.pending[seqId]; !ok {
+		return false
+	}
+	delete(conn.pending, seqId)
+	return true
+}
+
+// Shuts down a connection, failing every call waiting on it.
+func (this *PipelinedClient) fail(conn *pipelinedConn, err error) {
+	this.lock.Lock()
+	pending := conn.pending
+	conn.pending = nil
+	if this.conn == conn {
+		this.conn = nil
+	}
+	this.lock.Unlock()
+
+	if pending == nil {
+		return
+	}
+
+	conn.cn.Close()
+	for _, call := range pending {
+		call.done <- err
+	}
+}
+
+func (this *PipelinedClient) send(conn *pipelinedConn, method string, msgType thrift.TMessageType, seqId int32, args func(oprot thrift.TProtocol) error) error {
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+
+	if err := conn.oprot.WriteMessageBegin(method, msgType, seqId); err != nil {
+		return err
+	}
+	if err := args(conn.oprot); err != nil {
+		return err
+	}
+	if err := conn.oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return conn.oprot.Flush()
+}
+
+// Reads replies until the connection fails.
+func (this *PipelinedClient) read(conn *pipelinedConn) {
+	defer this.readers.Done()
+
+	for {
+		_, msgType, seqId, err := conn.iprot.ReadMessageBegin()
+		if err != nil {
+			this.fail(conn, err)
+			return
+		}
+
+		this.lock.Lock()
+		call := conn.pending[seqId]
+		delete(conn.pending, seqId)
+		this.lock.Unlock()
+
+		if err := this.receive(conn.iprot, msgType, call); err != nil {
+			if call != nil {
+				call.done <- err
+			}
+			this.fail(conn, err)
+			return
+		}
+	}
+}
+
+// Reads the body of a reply and completes its call, if it has one. Returns
+// an error if the connection can no longer be used.
+func (this *PipelinedClient) receive(iprot thrift.TProtocol, msgType thrift.TMessageType, call *pendingCall) error {
+	var result error
+	switch {
+	case msgType == thrift.EXCEPTION:
+		exception, err := thrift.NewTApplicationException(thrift.UNKNOWN_APPLICATION_EXCEPTION, "").Read(iprot)
+		if err != nil {
+			return err
+		}
+		result = exception
+	case call == nil:
+		// Nobody is waiting for this reply anymore.
+		if err := iprot.Skip(thrift.STRUCT); err != nil {
+			return err
+		}
+	default:
+		if err := call.result(iprot); err != nil {
+			return err
+		}
+	}
+
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if call != nil {
+		call.done <- result
+	}
+	return nil
+}
+
+// Calls a method and waits for its reply, up to the client's CallTimeout.
+// args must write the argument struct, and result must read the result
+// struct. If the server replies with an exception, it is returned as a
+// thrift.TApplicationException.
+func (this *PipelinedClient) Call(method string, args func(oprot thrift.TProtocol) error, result func(iprot thrift.TProtocol) error) error {
+	ctx := context.Background()
+	if this.options.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, this.options.CallTimeout)
+		defer cancel()
+	}
+	return this.CallContext(ctx, method, args, result)
+}
+
+// Like Call(), but waits until the context is done instead of using the
+// client's CallTimeout. If the context ends first, ErrCallTimeout is
+// returned.
+func (this *PipelinedClient) CallContext(ctx context.Context, method string, args func(oprot thrift.TProtocol) error, result func(iprot thrift.TProtocol) error) error {
+	call := &pendingCall{
+		result: result,
+		done:   make(chan error, 1),
+	}
+
+	conn, seqId, err := this.register(call)
+	if err != nil {
+		return err
+	}
+	if err := this.send(conn, method, thrift.CALL, seqId, args); err != nil {
+		this.fail(conn, err)
+		return err
+	}
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		if this.abandon(conn, seqId) {
+			return ErrCallTimeout
+		}
+		return <-call.done
+	}
+}
+
+// Sends a oneway message, which gets no reply.
+func (this *PipelinedClient) Send(method string, args func(oprot thrift.TProtocol) error) error {
+	conn, seqId, err := this.register(nil)
+	if err != nil {
+		return err
+	}
+	if err := this.send(conn, method, thrift.ONEWAY, seqId, args); err != nil {
+		this.fail(conn, err)
+		return err
+	}
+	return nil
+}
+
+// Closes the connection. Waiting calls fail with ErrClientClosed.
+func (this *PipelinedClient) Close() {
+	this.lock.Lock()
+	this.closed = true
+	conn := this.conn
+	this.lock.Unlock()
+
+	if conn != nil {
+		this.fail(conn, ErrClientClosed)
+	}
+	this.readers.Wait()
+}
@@ -0,0 +1,318 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A message received by a TestRawServer.
+type TestRawMessage struct {
+	Name  string
+	SeqId int32
+}
+
+// A bare-bones server that lets tests decide exactly how and when to reply.
+// The handler is called for each connection, with the number of connections
+// accepted so far.
+type TestRawServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+func StartTestRawServer(handler func(count int, iprot thrift.TProtocol, oprot thrift.TProtocol)) *TestRawServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+
+	server := &TestRawServer{listener: listener}
+	server.wg.Add(1)
+	go func() {
+		defer server.wg.Done()
+		for count := 1; ; count++ {
+			cn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(count int) {
+				defer cn.Close()
+				socket := NewSocketFromConn(cn, 0)
+				factory := thrift.NewTBinaryProtocolFactoryDefault()
+				handler(count, factory.GetProtocol(socket), factory.GetProtocol(socket))
+			}(count)
+		}
+	}()
+	return server
+}
+
+func (this *TestRawServer) Addr() string {
+	return this.listener.Addr().String()
+}
+
+func (this *TestRawServer) Stop() {
+	this.listener.Close()
+	this.wg.Wait()
+}
+
+// Reads a call with an argument struct.
+func ReadTestRawMessage(iprot thrift.TProtocol) (*TestRawMessage, error) {
+	name, _, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return nil, err
+	}
+	if err := iprot.Skip(thrift.STRUCT); err != nil {
+		return nil, err
+	}
+	return &TestRawMessage{name, seqId}, iprot.ReadMessageEnd()
+}
+
+// Replies to a message with a struct holding a single string.
+func WriteTestStringReply(oprot thrift.TProtocol, message *TestRawMessage, value string) error {
+	if err := oprot.WriteMessageBegin(message.Name, thrift.REPLY, message.SeqId); err != nil {
+		return err
+	}
+	if err := WriteTestString(oprot, value); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+func WriteTestString(oprot thrift.TProtocol, value string) error {
+	if err := oprot.WriteStructBegin("result"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldBegin("success", thrift.STRING, 0); err != nil {
+		return err
+	}
+	if err := oprot.WriteString(value); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+// Returns a result callback that reads a struct written by WriteTestString.
+func ReadTestString(value *string) func(iprot thrift.TProtocol) error {
+	return func(iprot thrift.TProtocol) error {
+		if _, err := iprot.ReadStructBegin(); err != nil {
+			return err
+		}
+		for {
+			_, fieldType, _, err := iprot.ReadFieldBegin()
+			if err != nil {
+				return err
+			}
+			if fieldType == thrift.STOP {
+				break
+			}
+			if *value, err = iprot.ReadString(); err != nil {
+				return err
+			}
+			if err := iprot.ReadFieldEnd(); err != nil {
+				return err
+			}
+		}
+		return iprot.ReadStructEnd()
+	}
+}
+
+func WriteTestEmptyArgs(oprot thrift.TProtocol) error {
+	if err := oprot.WriteStructBegin("args"); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
+
+func SkipTestResult(iprot thrift.TProtocol) error {
+	return iprot.Skip(thrift.STRUCT)
+}
+
+var _ = Describe("PipelinedClient", func() {
+	It("Sends concurrent calls over one connection", func() {
+		processor := &TestRecordingProcessor{}
+		callbacks := &TestProcessorCallbacks{NewTestServerCallbacks(nil), processor}
+		server := StartTestServer(callbacks, &ServerOptions{})
+		defer server.Stop()
+
+		client, err := NewPipelinedClient(server.Addr().String(), &PipelinedClientOptions{
+			Timeout:     time.Second,
+			CallTimeout: time.Second,
+		})
+		Expect(err).To(BeNil())
+		defer client.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(client.Call("get", WriteTestEmptyArgs, SkipTestResult)).To(BeNil())
+			}()
+		}
+		wg.Wait()
+
+		Expect(processor.Calls()).To(HaveLen(20))
+		Expect(server.ActiveConnections()).To(Equal(int64(1)))
+	})
+
+	It("Matches replies that arrive out of order", func() {
+		server := StartTestRawServer(func(count int, iprot thrift.TProtocol, oprot thrift.TProtocol) {
+			first, err := ReadTestRawMessage(iprot)
+			if err != nil {
+				return
+			}
+			second, err := ReadTestRawMessage(iprot)
+			if err != nil {
+				return
+			}
+			WriteTestStringReply(oprot, second, second.Name)
+			WriteTestStringReply(oprot, first, first.Name)
+		})
+		defer server.Stop()
+
+		client, err := NewPipelinedClient(server.Addr(), &PipelinedClientOptions{Timeout: time.Second})
+		Expect(err).To(BeNil())
+		defer client.Close()
+
+		results := make([]string, 2)
+		var wg sync.WaitGroup
+		for i, name := range []string{"first", "second"} {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(client.Call(name, WriteTestEmptyArgs, ReadTestString(&results[i]))).To(BeNil())
+			}(i, name)
+		}
+		wg.Wait()
+		Expect(results).To(Equal([]string{"first", "second"}))
+	})
+
+	It("Times out calls individually", func() {
+		server := StartTestRawServer(func(count int, iprot thrift.TProtocol, oprot thrift.TProtocol) {
+			for {
+				message, err := ReadTestRawMessage(iprot)
+				if err != nil {
+					return
+				}
+				switch message.Name {
+				case "slow":
+					// Never reply.
+				case "broken":
+					oprot.WriteMessageBegin(message.Name, thrift.EXCEPTION, message.SeqId)
+					thrift.NewTApplicationException(thrift.INTERNAL_ERROR, "broken").Write(oprot)
+					oprot.WriteMessageEnd()
+					oprot.Flush()
+				default:
+					WriteTestStringReply(oprot, message, message.Name)
+				}
+			}
+		})
+		defer server.Stop()
+
+		client, err := NewPipelinedClient(server.Addr(), &PipelinedClientOptions{
+			Timeout:     time.Second,
+			CallTimeout: 50 * time.Millisecond,
+		})
+		Expect(err).To(BeNil())
+		defer client.Close()
+
+		Expect(client.Call("slow", WriteTestEmptyArgs, SkipTestResult)).To(Equal(ErrCallTimeout))
+
+		err = client.Call("broken", WriteTestEmptyArgs, SkipTestResult)
+		exception, ok := err.(thrift.TApplicationException)
+		Expect(ok).To(BeTrue())
+		Expect(exception.TypeId()).To(Equal(int32(thrift.INTERNAL_ERROR)))
+
+		var result string
+		Expect(client.Call("fast", WriteTestEmptyArgs, ReadTestString(&result))).To(BeNil())
+		Expect(result).To(Equal("fast"))
+	})
+
+	It("Fails waiting calls and reconnects when the connection breaks", func() {
+		server := StartTestRawServer(func(count int, iprot thrift.TProtocol, oprot thrift.TProtocol) {
+			for {
+				message, err := ReadTestRawMessage(iprot)
+				if err != nil {
+					return
+				}
+
+				// The first connection hangs up after two calls.
+				if count == 1 && message.Name == "second" {
+					return
+				}
+				if message.Name != "first" {
+					WriteTestStringReply(oprot, message, message.Name)
+				}
+			}
+		})
+		defer server.Stop()
+
+		client, err := NewPipelinedClient(server.Addr(), &PipelinedClientOptions{Timeout: time.Second})
+		Expect(err).To(BeNil())
+		defer client.Close()
+
+		errs := make(chan error, 2)
+		for _, name := range []string{"first", "second"} {
+			go func(name string) {
+				errs <- client.Call(name, WriteTestEmptyArgs, SkipTestResult)
+			}(name)
+			time.Sleep(10 * time.Millisecond)
+		}
+		Expect(<-errs).NotTo(BeNil())
+		Expect(<-errs).NotTo(BeNil())
+
+		var result string
+		Expect(client.Call("third", WriteTestEmptyArgs, ReadTestString(&result))).To(BeNil())
+		Expect(result).To(Equal("third"))
+	})
+
+	It("Fails waiting calls when closed", func() {
+		server := StartTestRawServer(func(count int, iprot thrift.TProtocol, oprot thrift.TProtocol) {
+			ReadTestRawMessage(iprot)
+			ReadTestRawMessage(iprot)
+		})
+		defer server.Stop()
+
+		client, err := NewPipelinedClient(server.Addr(), &PipelinedClientOptions{Timeout: time.Second})
+		Expect(err).To(BeNil())
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- client.Call("hang", WriteTestEmptyArgs, SkipTestResult)
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		client.Close()
+		Expect(<-errs).To(Equal(ErrClientClosed))
+		Expect(client.Call("after", WriteTestEmptyArgs, SkipTestResult)).To(Equal(ErrClientClosed))
+	})
+})
@@ -14,9 +14,7 @@
 package frugal
 
 import (
-	"io"
-	"net"
-	"syscall"
+	"crypto/tls"
 	"time"
 )
 
@@ -39,7 +37,13 @@ type ResumeableSocket struct {
 
 // Creates a new resumeable socket with a given host/port and timeout.
 func NewResumeableSocket(hostAndPort string, timeout time.Duration) (*ResumeableSocket, error) {
-	socket, err := NewSocket(hostAndPort, timeout)
+	return NewResumeableTLSSocket(hostAndPort, timeout, nil)
+}
+
+// Creates a new resumeable socket that connects over TLS. The same config is
+// used if the connection has to be re-established.
+func NewResumeableTLSSocket(hostAndPort string, timeout time.Duration, tlsConfig *tls.Config) (*ResumeableSocket, error) {
+	socket, err := NewTLSSocket(hostAndPort, timeout, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -80,22 +84,6 @@ func (this *ResumeableSocket) Read(buf []byte) (int, error) {
 	return n, err
 }
 
-// Only restart from broken pipes, which happen when either end of the socket
-// closes.
-func (this *ResumeableSocket) isRestartable(err error) bool {
-	if err == io.EOF {
-		return true
-	}
-
-	if opError, ok := err.(*net.OpError); ok {
-		if opError.Err == syscall.EPIPE || opError.Err == syscall.ECONNRESET {
-			return true
-		}
-	}
-
-	return false
-}
-
 func (this *ResumeableSocket) tryRestart(err error) error {
 	// This socket was verified to be working, either via an initial call to Dial
 	// or from a successful receive. Any failure now is a real failure.
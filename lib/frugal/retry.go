@@ -0,0 +1,174 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Anything connections can be borrowed from and returned to, such as a
+// SocketPool or a BalancedPool.
+type ConnectionPool interface {
+	Get() (*Connection, error)
+	Put(conn *Connection, err *error)
+}
+
+// Returns true if an error means the connection was broken, rather than the
+// request itself failing. This covers EOF, broken pipes, connection resets,
+// and refused connections.
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// NB: Thrift coughs up some non-standard EOF instance, so we have to compare
+	// the string as well.
+	if err == io.EOF || err.Error() == io.EOF.Error() {
+		return true
+	}
+
+	if opError, ok := err.(*net.OpError); ok {
+		err = opError.Err
+	}
+	if syscallError, ok := err.(*os.SyscallError); ok {
+		err = syscallError.Err
+	}
+	switch err {
+	case syscall.EPIPE, syscall.ECONNRESET, syscall.ECONNREFUSED:
+		return true
+	}
+	return false
+}
+
+// Options that can be passed to NewRetryPolicy().
+type RetryPolicyOptions struct {
+	// Maximum number of attempts per call, including the first one. If zero,
+	// this defaults to 3.
+	MaxAttempts int
+
+	// Delay before the first retry. Each following retry doubles the delay, up
+	// to MaxBackoff. If zero, these default to 10ms and one second.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Fraction of each delay that is randomized, between 0 and 1. For example,
+	// 0.2 picks a delay within 20% either side of the backoff.
+	Jitter float64
+
+	// Decides whether an error is worth retrying. If nil, IsRetryableError is
+	// used.
+	Retryable func(err error) bool
+
+	// Methods that are safe to call more than once. Other methods are only
+	// retried if no connection could be obtained, since then nothing was sent.
+	IdempotentMethods []string
+}
+
+// A client-side policy for retrying calls over pooled connections.
+type RetryPolicy struct {
+	options    RetryPolicyOptions
+	idempotent map[string]bool
+
+	lock   sync.Mutex
+	random *rand.Rand
+}
+
+func NewRetryPolicy(options *RetryPolicyOptions) *RetryPolicy {
+	policy := &RetryPolicy{
+		options:    *options,
+		idempotent: map[string]bool{},
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if policy.options.MaxAttempts == 0 {
+		policy.options.MaxAttempts = 3
+	}
+	if policy.options.InitialBackoff == 0 {
+		policy.options.InitialBackoff = 10 * time.Millisecond
+	}
+	if policy.options.MaxBackoff == 0 {
+		policy.options.MaxBackoff = time.Second
+	}
+	if policy.options.Retryable == nil {
+		policy.options.Retryable = IsRetryableError
+	}
+	for _, method := range options.IdempotentMethods {
+		policy.idempotent[method] = true
+	}
+	return policy
+}
+
+// Returns true if the method was listed as idempotent.
+func (this *RetryPolicy) IsIdempotent(method string) bool {
+	return this.idempotent[method]
+}
+
+// Returns how long to wait after the given failed attempt (starting from 1).
+func (this *RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := this.options.InitialBackoff
+	for i := 1; i < attempt && backoff < this.options.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > this.options.MaxBackoff {
+		backoff = this.options.MaxBackoff
+	}
+
+	if this.options.Jitter > 0 {
+		this.lock.Lock()
+		factor := 1 + this.options.Jitter*(2*this.random.Float64()-1)
+		this.lock.Unlock()
+		backoff = time.Duration(float64(backoff) * factor)
+	}
+	return backoff
+}
+
+// Runs a call on a connection from the pool, retrying according to the
+// policy. The connection is returned to the pool after each attempt, with
+// the error fn returned, so broken connections are discarded and retries get
+// a fresh one.
+//
+// For example:
+//
+//     err := policy.Call(pool, "getUser", func(conn *Connection) error {
+//         user, err = NewUserServiceClient(conn).GetUser(id)
+//         return err
+//     })
+func (this *RetryPolicy) Call(pool ConnectionPool, method string, fn func(conn *Connection) error) error {
+	for attempt := 1; ; attempt++ {
+		conn, err := pool.Get()
+		if err == nil {
+			err = fn(conn)
+			pool.Put(conn, &err)
+			if err == nil {
+				return nil
+			}
+
+			// The request may have reached the server, so only idempotent methods
+			// can be sent again.
+			if !this.IsIdempotent(method) {
+				return err
+			}
+		}
+
+		if attempt >= this.options.MaxAttempts || !this.options.Retryable(err) {
+			return err
+		}
+		time.Sleep(this.Backoff(attempt))
+	}
+}
@@ -0,0 +1,159 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Server callbacks that hang up without replying to the first few requests.
+type TestDroppingCallbacks struct {
+	*TestServerCallbacks
+
+	lock  sync.Mutex
+	drops int
+	calls int
+}
+
+func (this *TestDroppingCallbacks) Calls() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.calls
+}
+
+func (this *TestDroppingCallbacks) ProcessRequest(request *Request) error {
+	this.lock.Lock()
+	this.calls++
+	drop := this.drops > 0
+	if drop {
+		this.drops--
+	}
+	this.lock.Unlock()
+
+	if drop {
+		return errors.New("dropped")
+	}
+	return this.TestServerCallbacks.ProcessRequest(request)
+}
+
+var _ = Describe("RetryPolicy", func() {
+	var callbacks *TestDroppingCallbacks
+	var server *Server
+	var pool *SocketPool
+
+	BeforeEach(func() {
+		callbacks = &TestDroppingCallbacks{
+			TestServerCallbacks: NewTestServerCallbacks(nil),
+			drops:               2,
+		}
+		server = StartTestServer(callbacks, &ServerOptions{})
+		pool = NewSocketPool(&TestServerFactory{server}, 1)
+	})
+
+	AfterEach(func() {
+		pool.Close()
+		server.Stop()
+	})
+
+	call := func(conn *Connection) error {
+		if err := SendTestCall(conn, "get"); err != nil {
+			return err
+		}
+		_, err := ReceiveTestReply(conn)
+		return err
+	}
+
+	It("Classifies broken connections as retryable", func() {
+		Expect(IsRetryableError(io.EOF)).To(BeTrue())
+		Expect(IsRetryableError(&net.OpError{Op: "read", Err: syscall.ECONNRESET})).To(BeTrue())
+		Expect(IsRetryableError(&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)})).To(BeTrue())
+		Expect(IsRetryableError(errors.New("application error"))).To(BeFalse())
+		Expect(IsRetryableError(nil)).To(BeFalse())
+	})
+
+	It("Retries idempotent methods on a fresh connection", func() {
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff:    time.Millisecond,
+			IdempotentMethods: []string{"get"},
+		})
+		Expect(policy.Call(pool, "get", call)).To(BeNil())
+		Expect(callbacks.Calls()).To(Equal(3))
+	})
+
+	It("Gives up after MaxAttempts", func() {
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			MaxAttempts:       2,
+			InitialBackoff:    time.Millisecond,
+			IdempotentMethods: []string{"get"},
+		})
+		err := policy.Call(pool, "get", call)
+		Expect(IsRetryableError(err)).To(BeTrue())
+		Expect(callbacks.Calls()).To(Equal(2))
+	})
+
+	It("Does not resend non-idempotent methods", func() {
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff: time.Millisecond,
+		})
+		Expect(policy.Call(pool, "set", call)).NotTo(BeNil())
+		Expect(callbacks.Calls()).To(Equal(1))
+	})
+
+	It("Retries any method when dialing fails", func() {
+		factory := &TestFlakyFactory{inner: &TestServerFactory{server}}
+		factory.SetFailing(true)
+		flaky := NewSocketPool(factory, 1)
+		defer flaky.Close()
+
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff: time.Millisecond,
+			Retryable: func(err error) bool {
+				factory.SetFailing(false)
+				return true
+			},
+		})
+		Expect(policy.Call(flaky, "set", func(conn *Connection) error { return nil })).To(BeNil())
+		Expect(factory.Dials()).To(Equal(2))
+	})
+
+	It("Backs off exponentially with jitter", func() {
+		policy := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     50 * time.Millisecond,
+		})
+		Expect(policy.Backoff(1)).To(Equal(10 * time.Millisecond))
+		Expect(policy.Backoff(2)).To(Equal(20 * time.Millisecond))
+		Expect(policy.Backoff(3)).To(Equal(40 * time.Millisecond))
+		Expect(policy.Backoff(4)).To(Equal(50 * time.Millisecond))
+
+		jittered := NewRetryPolicy(&RetryPolicyOptions{
+			InitialBackoff: 100 * time.Millisecond,
+			Jitter:         0.5,
+		})
+		for i := 0; i < 20; i++ {
+			backoff := jittered.Backoff(1)
+			Expect(backoff).To(BeNumerically(">=", 50*time.Millisecond))
+			Expect(backoff).To(BeNumerically("<=", 150*time.Millisecond))
+		}
+	})
+})
@@ -15,15 +15,23 @@
 package frugal
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"io"
 	"net"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 )
 
+var ErrTooManyConnections = errors.New("server connection limit reached")
+var ErrTooManyRequests = errors.New("server request limit reached")
+
 // Information about a thrift request.
 type Request struct {
 	RequestId   int64
@@ -32,6 +40,52 @@ type Request struct {
 	MethodName  string
 	Input       thrift.TProtocol
 	Output      thrift.TProtocol
+
+	// If the server has a tracer, the span covering this request. Handlers can
+	// use its context as the parent for outgoing calls.
+	Span *Span
+
+	// If the server uses TLS and the client presented a certificate that was
+	// verified, the client's certificate. Handlers can use it to authorize
+	// requests by client identity.
+	PeerCertificate *x509.Certificate
+
+	// If the request was dispatched by a Multiplexer, the name of the service
+	// it was addressed to. MethodName no longer has the service prefix.
+	ServiceName string
+}
+
+// Rejects a request without processing it. The arguments are skipped, and a
+// TApplicationException with the given type and message is sent in reply.
+// Oneway requests get no reply.
+func (this *Request) Reject(typeId int32, message string) error {
+	if err := this.Input.Skip(thrift.STRUCT); err != nil {
+		return err
+	}
+	if err := this.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+	return this.SendException(typeId, message)
+}
+
+// Replies to a request with a TApplicationException, for processors that have
+// already read the arguments. Oneway requests get no reply.
+func (this *Request) SendException(typeId int32, message string) error {
+	if this.MessageType == thrift.ONEWAY {
+		return nil
+	}
+
+	exception := thrift.NewTApplicationException(typeId, message)
+	if err := this.Output.WriteMessageBegin(this.MethodName, thrift.EXCEPTION, this.SequenceId); err != nil {
+		return err
+	}
+	if err := exception.Write(this.Output); err != nil {
+		return err
+	}
+	if err := this.Output.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return this.Output.Flush()
 }
 
 // Callbacks for a request processor.
@@ -53,16 +107,61 @@ type ServerInterface interface {
 
 // Options that can be passed to NewServer().
 type ServerOptions struct {
-	// Host and port string for listening.
+	// Host and port string for listening. If this starts with "/", it is the
+	// path of a unix socket instead.
 	ListenAddr string
 
+	// Permissions for a unix socket. If zero, the socket is created according
+	// to the process umask.
+	SocketMode os.FileMode
+
+	// If non-nil, the server accepts connections from this listener instead of
+	// opening ListenAddr. This allows for socket activation, or for handing a
+	// listening socket over to a new process. The listener is closed by Stop().
+	Listener net.Listener
+
 	// Timeout for client operations.
 	ClientTimeout time.Duration
 
 	// Whether or not to use framing.
 	Framed bool
+
+	// Maximum number of client connections serviced at once. If zero, there is
+	// no limit. When the limit is reached, the server stops accepting new
+	// connections until an existing one closes.
+	MaxConnections int
+
+	// If true, connections over MaxConnections are accepted and immediately
+	// closed, rather than left waiting in the listen backlog.
+	RejectExcessConnections bool
+
+	// Maximum number of requests that can be inside ProcessRequest() at once.
+	// If zero, there is no limit.
+	MaxConcurrentRequests int
+
+	// If non-nil, receives per-request metrics.
+	Metrics Metrics
+
+	// If non-nil, a span is started around each request. If the client's
+	// transport carries headers (see HeaderTransport), the span joins the
+	// caller's trace.
+	Tracer *Tracer
+
+	// If non-nil, clients must connect over TLS. For mutual TLS, set ClientAuth
+	// to tls.RequireAndVerifyClientCert (or tls.VerifyClientCertIfGiven) and
+	// ClientCAs to the authorities that sign client certificates.
+	TLSConfig *tls.Config
+
+	// Time allowed for a client to finish the TLS handshake. If zero,
+	// ClientTimeout is used, or DefaultHandshakeTimeout if that is zero too,
+	// so that a stalled client cannot hold a connection slot forever.
+	HandshakeTimeout time.Duration
 }
 
+// The handshake timeout used when neither HandshakeTimeout nor ClientTimeout
+// is set.
+const DefaultHandshakeTimeout = 10 * time.Second
+
 // This is a reimplementation of thrift.TSimpleServer. Eventually, we would
 // like to remove dependence on the unnecessary factory abstraction layers,
 // but for now we wrap the Thrift API.
@@ -71,30 +170,61 @@ type Server struct {
 	callbacks ServerInterface
 	options   *ServerOptions
 
-	// Current server state.
+	// Current server state. Serve() and Stop() run on different goroutines,
+	// so listener, stopped, and stopping are guarded by lock.
 	addr     net.Addr
+	lock     sync.Mutex
 	listener net.Listener
 	stopped  bool
 
+	// Closed by Stop() to wake anything blocked inside Serve().
+	stopping chan struct{}
+
 	// The next request id to use.
 	requestId int64
+
+	// Slots for connection and request limits. These are nil if there is no
+	// limit. A slot is taken by sending to the channel.
+	connectionSlots chan struct{}
+	requestSlots    chan struct{}
+
+	// Live counts of open connections and in-flight requests.
+	activeConnections int64
+	activeRequests    int64
 }
 
 // Allocates a new thrift server. If the given host+port cannot be resolved,
 // an error is returned.
 func NewServer(callbacks ServerInterface, options *ServerOptions) (*Server, error) {
-	addr, err := net.ResolveTCPAddr("tcp", options.ListenAddr)
+	addr, err := resolveListenAddr(options)
 	if err != nil {
 		return nil, err
 	}
-	return &Server{
+	server := &Server{
 		callbacks: callbacks,
 		options:   options,
 		addr:      addr,
 		listener:  nil,
 		stopped:   false,
 		requestId: int64(0),
-	}, nil
+	}
+	if options.MaxConnections > 0 {
+		server.connectionSlots = make(chan struct{}, options.MaxConnections)
+	}
+	if options.MaxConcurrentRequests > 0 {
+		server.requestSlots = make(chan struct{}, options.MaxConcurrentRequests)
+	}
+	return server, nil
+}
+
+func resolveListenAddr(options *ServerOptions) (net.Addr, error) {
+	if options.Listener != nil {
+		return options.Listener.Addr(), nil
+	}
+	if strings.HasPrefix(options.ListenAddr, "/") {
+		return net.ResolveUnixAddr("unix", options.ListenAddr)
+	}
+	return net.ResolveTCPAddr("tcp", options.ListenAddr)
 }
 
 // Thrift's protocol is not framed by default, so to differentiate between
@@ -115,6 +245,12 @@ func NewServerClientSocket(conn net.Conn, timeout time.Duration) *ServerClientSo
 }
 
 func (this *ServerClientSocket) Reuse() error {
+	// Clients may pipeline requests, in which case the start of the next one
+	// has already been read into the buffer.
+	if this.closed == nil && this.readPos != this.readLimit && this.writeBuffer.Len() == 0 {
+		return nil
+	}
+
 	// Flag the socket so that the next Read() has no timeout.
 	this.firstRead = true
 	this.oldTimeout = this.SetTimeout(0)
@@ -132,35 +268,54 @@ func (this *ServerClientSocket) Read(buf []byte) (int, error) {
 
 // Returns the address the server is listening or will listen on.
 func (this *Server) Addr() net.Addr {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
 	if this.listener != nil {
 		return this.listener.Addr()
 	}
 	return this.addr
 }
 
+// Returns the number of client connections currently open.
+func (this *Server) ActiveConnections() int64 {
+	return atomic.LoadInt64(&this.activeConnections)
+}
+
+// Returns the number of requests currently inside ProcessRequest().
+func (this *Server) ActiveRequests() int64 {
+	return atomic.LoadInt64(&this.activeRequests)
+}
+
 // Begins servicing requests. Blocks until Stop() is called.
 func (this *Server) Serve() error {
-	if this.listener != nil {
-		return errors.New("server is already listening")
-	}
-
-	var err error
-	this.listener, err = net.Listen(this.addr.Network(), this.addr.String())
+	listener, err := this.start()
 	if err != nil {
 		return err
 	}
 
 	// Close the error on exit.
 	defer func() {
+		this.lock.Lock()
+		defer this.lock.Unlock()
 		this.listener = nil
 		this.stopped = false
 	}()
 
-	for !this.stopped {
-		conn, err := this.listener.Accept()
+	for !this.isStopped() {
+		// If we're applying backpressure, wait for a free slot before accepting.
+		if !this.options.RejectExcessConnections && !this.acquireConnection() {
+			break
+		}
+
+		conn, err := listener.Accept()
 		if err != nil {
+			if !this.options.RejectExcessConnections {
+				this.releaseConnection()
+			}
+
 			// If we're supposed to stop, just exit out.
-			if this.stopped {
+			if this.isStopped() {
 				break
 			}
 
@@ -169,13 +324,207 @@ func (this *Server) Serve() error {
 			continue
 		}
 
+		if this.options.RejectExcessConnections && !this.tryAcquireConnection() {
+			conn.Close()
+			continue
+		}
+
 		go this.processRequest(conn)
 	}
 
 	return nil
 }
 
+// Opens the listener and publishes it, so that Stop() can close it. The
+// stopping channel is created first, since Stop() closes it once it sees
+// the listener.
+func (this *Server) start() (net.Listener, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.listener != nil {
+		return nil, errors.New("server is already listening")
+	}
+
+	listener, err := this.listen()
+	if err != nil {
+		return nil, err
+	}
+	if this.options.TLSConfig != nil {
+		listener = tls.NewListener(listener, this.options.TLSConfig)
+	}
+
+	this.stopping = make(chan struct{})
+	this.listener = listener
+	return listener, nil
+}
+
+func (this *Server) isStopped() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.stopped
+}
+
+// Opens the listening socket, unless one was passed in.
+func (this *Server) listen() (net.Listener, error) {
+	if this.options.Listener != nil {
+		return this.options.Listener, nil
+	}
+	if this.addr.Network() != "unix" {
+		return net.Listen(this.addr.Network(), this.addr.String())
+	}
+
+	path := this.addr.String()
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if this.options.SocketMode != 0 {
+		if err := os.Chmod(path, this.options.SocketMode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// Removes a unix socket left behind by a server that did not shut down
+// cleanly. Sockets that something is still listening on, and files that are
+// not sockets, are left alone so that listening fails.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	if cn, err := net.Dial("unix", path); err == nil {
+		cn.Close()
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// Takes a connection slot, blocking if the server is at its connection
+// limit. Returns false if the server was stopped while waiting.
+func (this *Server) acquireConnection() bool {
+	if this.connectionSlots == nil {
+		return true
+	}
+
+	select {
+	case this.connectionSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	this.callbacks.LogError("max-connections", ErrTooManyConnections)
+	select {
+	case this.connectionSlots <- struct{}{}:
+		return true
+	case <-this.stopping:
+		return false
+	}
+}
+
+// Takes a connection slot if one is free, without blocking.
+func (this *Server) tryAcquireConnection() bool {
+	if this.connectionSlots == nil {
+		return true
+	}
+
+	select {
+	case this.connectionSlots <- struct{}{}:
+		return true
+	default:
+		this.callbacks.LogError("max-connections", ErrTooManyConnections)
+		return false
+	}
+}
+
+func (this *Server) releaseConnection() {
+	if this.connectionSlots != nil {
+		<-this.connectionSlots
+	}
+}
+
+// Takes a request slot, blocking if the server is at its request limit.
+func (this *Server) acquireRequest() {
+	if this.requestSlots != nil {
+		select {
+		case this.requestSlots <- struct{}{}:
+		default:
+			this.callbacks.LogError("max-concurrent-requests", ErrTooManyRequests)
+			this.requestSlots <- struct{}{}
+		}
+	}
+	atomic.AddInt64(&this.activeRequests, 1)
+}
+
+func (this *Server) releaseRequest() {
+	atomic.AddInt64(&this.activeRequests, -1)
+	if this.requestSlots != nil {
+		<-this.requestSlots
+	}
+}
+
+// Starts a server span for a request, if tracing is enabled.
+func (this *Server) startSpan(method string, iprot thrift.TProtocol) *Span {
+	if this.options.Tracer == nil {
+		return nil
+	}
+
+	var parent *SpanContext
+	if carrier, ok := iprot.Transport().(HeaderCarrier); ok {
+		parent = ExtractSpanContext(carrier.ReadHeaders())
+	}
+	return this.options.Tracer.StartSpan(method, SpanKindServer, parent)
+}
+
+// Completes the TLS handshake on a client connection, if it is using TLS, so
+// that its certificate is available before the first request.
+func (this *Server) handshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	timeout := this.options.HandshakeTimeout
+	if timeout == 0 {
+		timeout = this.options.ClientTimeout
+	}
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(timeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	return tlsConn.Handshake()
+}
+
 func (this *Server) processRequest(conn net.Conn) {
+	atomic.AddInt64(&this.activeConnections, 1)
+	defer func() {
+		atomic.AddInt64(&this.activeConnections, -1)
+		this.releaseConnection()
+	}()
+
+	if err := this.handshake(conn); err != nil {
+		this.callbacks.LogError("tls-handshake", err)
+		conn.Close()
+		return
+	}
+	peer := peerCertificate(conn)
+
 	socket := NewServerClientSocket(conn, this.options.ClientTimeout)
 	defer socket.Close()
 
@@ -209,14 +558,26 @@ func (this *Server) processRequest(conn net.Conn) {
 		// processing happens in goroutines.
 		requestId := atomic.AddInt64(&this.requestId, int64(1))
 
+		this.acquireRequest()
+		span := this.startSpan(name, iprot)
+		started := time.Now()
 		err = this.callbacks.ProcessRequest(&Request{
-			RequestId:   requestId,
-			SequenceId:  sequenceId,
-			MessageType: msgType,
-			MethodName:  name,
-			Input:       iprot,
-			Output:      oprot,
+			RequestId:       requestId,
+			SequenceId:      sequenceId,
+			MessageType:     msgType,
+			MethodName:      name,
+			Input:           iprot,
+			Output:          oprot,
+			Span:            span,
+			PeerCertificate: peer,
 		})
+		if this.options.Metrics != nil {
+			this.options.Metrics.RequestProcessed(name, time.Since(started), err)
+		}
+		if span != nil {
+			span.Finish(err)
+		}
+		this.releaseRequest()
 		if err != nil {
 			this.callbacks.LogError("process-request", err)
 			break
@@ -233,11 +594,15 @@ func (this *Server) processRequest(conn net.Conn) {
 
 // Interrupts Serve() causing the server to stop servicing requests.
 func (this *Server) Stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
 	if this.listener == nil || this.stopped {
 		return
 	}
 
 	// Mark as stopped, then make the listener stop accepting conncetions.
 	this.stopped = true
+	close(this.stopping)
 	this.listener.Close()
 }
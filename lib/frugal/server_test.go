@@ -0,0 +1,286 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Server callbacks that reply to every call with an empty message. If a
+// channel is given, each request blocks until it receives from it.
+type TestServerCallbacks struct {
+	block chan bool
+
+	lock   sync.Mutex
+	errors map[string]int
+}
+
+func NewTestServerCallbacks(block chan bool) *TestServerCallbacks {
+	return &TestServerCallbacks{
+		block:  block,
+		errors: map[string]int{},
+	}
+}
+
+func (this *TestServerCallbacks) ProcessRequest(request *Request) error {
+	if err := request.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+	if this.block != nil {
+		<-this.block
+	}
+	if err := request.Output.WriteMessageBegin(request.MethodName, thrift.REPLY, request.SequenceId); err != nil {
+		return err
+	}
+	if err := request.Output.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return request.Output.Flush()
+}
+
+func (this *TestServerCallbacks) GetProtocolsForClient(client Transport) (thrift.TProtocol, thrift.TProtocol) {
+	factory := thrift.NewTBinaryProtocolFactoryDefault()
+	return factory.GetProtocol(client), factory.GetProtocol(client)
+}
+
+func (this *TestServerCallbacks) LogError(context string, err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.errors[context]++
+}
+
+func (this *TestServerCallbacks) ErrorCount(context string) int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.errors[context]
+}
+
+// Starts a server and waits for it to listen. Unless an address or listener
+// is given, the server listens on a random local port.
+func StartTestServer(callbacks ServerInterface, options *ServerOptions) *Server {
+	if options.ListenAddr == "" && options.Listener == nil {
+		options.ListenAddr = "127.0.0.1:0"
+	}
+	server, err := NewServer(callbacks, options)
+	Expect(err).To(BeNil())
+
+	go server.Serve()
+	Eventually(func() bool {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		return server.listener != nil
+	}).Should(BeTrue())
+	return server
+}
+
+// Sends an empty call over a new connection to the given server, returning
+// the connection for further use.
+func DialTestServer(server *Server) *Connection {
+	socket, err := NewSocket(server.Addr().String(), time.Second)
+	Expect(err).To(BeNil())
+	return NewConnectionFromFactory(socket, thrift.NewTBinaryProtocolFactoryDefault())
+}
+
+func SendTestCall(conn *Connection, method string) error {
+	if err := conn.Output().WriteMessageBegin(method, thrift.CALL, 1); err != nil {
+		return err
+	}
+	if err := conn.Output().WriteMessageEnd(); err != nil {
+		return err
+	}
+	return conn.Output().Flush()
+}
+
+func ReceiveTestReply(conn *Connection) (string, error) {
+	name, _, _, err := conn.Input().ReadMessageBegin()
+	if err != nil {
+		return "", err
+	}
+	return name, conn.Input().ReadMessageEnd()
+}
+
+var _ = Describe("Server", func() {
+	It("Limits the number of concurrent requests", func() {
+		block := make(chan bool)
+		callbacks := NewTestServerCallbacks(block)
+		server := StartTestServer(callbacks, &ServerOptions{
+			MaxConcurrentRequests: 1,
+		})
+		defer server.Stop()
+
+		first := DialTestServer(server)
+		defer first.Transport().Close()
+		second := DialTestServer(server)
+		defer second.Transport().Close()
+
+		Expect(SendTestCall(first, "first")).To(BeNil())
+		Expect(SendTestCall(second, "second")).To(BeNil())
+
+		// Only one request is allowed in, and the other must be logged.
+		Eventually(server.ActiveConnections).Should(Equal(int64(2)))
+		Eventually(server.ActiveRequests).Should(Equal(int64(1)))
+		Eventually(func() int {
+			return callbacks.ErrorCount("max-concurrent-requests")
+		}).Should(Equal(1))
+
+		// Release both requests.
+		block <- true
+		block <- true
+
+		_, err := ReceiveTestReply(first)
+		Expect(err).To(BeNil())
+		_, err = ReceiveTestReply(second)
+		Expect(err).To(BeNil())
+		Eventually(server.ActiveRequests).Should(Equal(int64(0)))
+	})
+
+	It("Rejects connections over the limit", func() {
+		callbacks := NewTestServerCallbacks(nil)
+		server := StartTestServer(callbacks, &ServerOptions{
+			MaxConnections:          1,
+			RejectExcessConnections: true,
+		})
+		defer server.Stop()
+
+		first := DialTestServer(server)
+		defer first.Transport().Close()
+		Expect(SendTestCall(first, "first")).To(BeNil())
+		_, err := ReceiveTestReply(first)
+		Expect(err).To(BeNil())
+		Expect(server.ActiveConnections()).To(Equal(int64(1)))
+
+		// The second connection should be closed without a reply.
+		second := DialTestServer(server)
+		defer second.Transport().Close()
+		SendTestCall(second, "second")
+		_, err = ReceiveTestReply(second)
+		Expect(err).NotTo(BeNil())
+		Expect(callbacks.ErrorCount("max-connections")).To(Equal(1))
+
+		// Once the first connection closes, new connections are accepted.
+		first.Transport().Close()
+		Eventually(server.ActiveConnections).Should(Equal(int64(0)))
+
+		third := DialTestServer(server)
+		defer third.Transport().Close()
+		Expect(SendTestCall(third, "third")).To(BeNil())
+		_, err = ReceiveTestReply(third)
+		Expect(err).To(BeNil())
+	})
+
+	It("Waits for a free slot before accepting", func() {
+		callbacks := NewTestServerCallbacks(nil)
+		server := StartTestServer(callbacks, &ServerOptions{
+			MaxConnections: 1,
+		})
+		defer server.Stop()
+
+		first := DialTestServer(server)
+		Expect(SendTestCall(first, "first")).To(BeNil())
+		_, err := ReceiveTestReply(first)
+		Expect(err).To(BeNil())
+
+		// The second connection sits in the backlog until the first closes.
+		second := DialTestServer(server)
+		defer second.Transport().Close()
+		Expect(SendTestCall(second, "second")).To(BeNil())
+		Eventually(func() int {
+			return callbacks.ErrorCount("max-connections")
+		}).Should(Equal(1))
+		Expect(server.ActiveConnections()).To(Equal(int64(1)))
+
+		first.Transport().Close()
+		name, err := ReceiveTestReply(second)
+		Expect(err).To(BeNil())
+		Expect(name).To(Equal("second"))
+	})
+
+	It("Listens on unix sockets", func() {
+		dir, err := ioutil.TempDir("", "frugal")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "server.sock")
+
+		// Leave a stale socket behind, as if a server had crashed.
+		stale, err := net.Listen("unix", path)
+		Expect(err).To(BeNil())
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		stale.Close()
+
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{
+			ListenAddr: path,
+			SocketMode: 0600,
+		})
+		defer server.Stop()
+
+		info, err := os.Stat(path)
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		conn := DialTestServer(server)
+		defer conn.Transport().Close()
+		Expect(SendTestCall(conn, "unix")).To(BeNil())
+		name, err := ReceiveTestReply(conn)
+		Expect(err).To(BeNil())
+		Expect(name).To(Equal("unix"))
+	})
+
+	It("Does not remove files that are not sockets", func() {
+		dir, err := ioutil.TempDir("", "frugal")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "server.sock")
+		Expect(ioutil.WriteFile(path, []byte("data"), 0644)).To(BeNil())
+
+		server, err := NewServer(NewTestServerCallbacks(nil), &ServerOptions{ListenAddr: path})
+		Expect(err).To(BeNil())
+		Expect(server.Serve()).NotTo(BeNil())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(Equal("data"))
+	})
+
+	It("Accepts connections from a given listener", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{
+			Listener: listener,
+		})
+		Expect(server.Addr().String()).To(Equal(listener.Addr().String()))
+
+		conn := DialTestServer(server)
+		defer conn.Transport().Close()
+		Expect(SendTestCall(conn, "listener")).To(BeNil())
+		_, err = ReceiveTestReply(conn)
+		Expect(err).To(BeNil())
+
+		// Stopping the server closes the listener.
+		server.Stop()
+		Eventually(func() error {
+			_, err := net.Dial("tcp", listener.Addr().String())
+			return err
+		}).ShouldNot(BeNil())
+	})
+})
@@ -14,6 +14,8 @@
 package frugal
 
 import (
+	"time"
+
 	"git.apache.org/thrift.git/lib/go/thrift"
 )
 
@@ -44,6 +46,11 @@ type Connection struct {
 	// The client field may be used be consumers of the socket pool to store extra
 	// data associated with the connection.
 	Client interface{}
+
+	// When the connection was created, and when it was last returned to a
+	// socket pool.
+	createdAt time.Time
+	idleSince time.Time
 }
 
 // Allocate a new Connection given a frugal.Socket and a TProtocolFactory.
@@ -52,6 +59,7 @@ func NewConnectionFromFactory(transport Transport, factory thrift.TProtocolFacto
 		transport: transport,
 		iprot:     factory.GetProtocol(transport),
 		oprot:     factory.GetProtocol(transport),
+		createdAt: time.Now(),
 	}
 }
 
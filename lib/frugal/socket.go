@@ -15,6 +15,8 @@ package frugal
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net"
 	"strings"
@@ -34,6 +36,9 @@ type Socket struct {
 	timeout     time.Duration
 	closed      error
 
+	// If non-nil, the connection is made over TLS with this configuration.
+	tlsConfig *tls.Config
+
 	// Network data is received into a fixed-size read buffer, and calls to Read()
 	// access this buffer. If the buffer is depleted, the network is read again.
 	readBuffer []byte
@@ -44,7 +49,11 @@ type Socket struct {
 	writeBuffer bytes.Buffer
 }
 
-func dialHostAndPort(hostAndPort string, timeout time.Duration) (net.Conn, error) {
+func dialHostAndPort(hostAndPort string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig != nil {
+		return dialTLS(hostAndPort, timeout, tlsConfig)
+	}
+
 	if strings.HasPrefix(hostAndPort, "/") {
 		cn, err := net.DialTimeout("unix", hostAndPort, timeout)
 		if err != nil {
@@ -64,9 +73,32 @@ func dialHostAndPort(hostAndPort string, timeout time.Duration) (net.Conn, error
 	return cn, nil
 }
 
+// Dials and completes a TLS handshake. If the config has no ServerName, the
+// host from hostAndPort is verified against the server's certificate.
+func dialTLS(hostAndPort string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(hostAndPort, "/") {
+		network = "unix"
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	cn, err := tls.DialWithDialer(dialer, network, hostAndPort, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return cn, nil
+}
+
 // Allocate a new socket using the given host:port string and timeout duration.
 func NewSocket(hostAndPort string, timeout time.Duration) (*Socket, error) {
-	cn, err := dialHostAndPort(hostAndPort, timeout)
+	return NewTLSSocket(hostAndPort, timeout, nil)
+}
+
+// Allocate a new socket that connects over TLS. If the config is nil, this is
+// the same as NewSocket(). The TLS handshake happens before this returns, and
+// must complete within the timeout.
+func NewTLSSocket(hostAndPort string, timeout time.Duration, tlsConfig *tls.Config) (*Socket, error) {
+	cn, err := dialHostAndPort(hostAndPort, timeout, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +107,7 @@ func NewSocket(hostAndPort string, timeout time.Duration) (*Socket, error) {
 		hostAndPort: hostAndPort,
 		cn:          cn,
 		timeout:     timeout,
+		tlsConfig:   tlsConfig,
 		readBuffer:  make([]byte, kReadBufferSize),
 	}, nil
 }
@@ -193,11 +226,30 @@ func (this *Socket) RemoteAddr() string {
 	return this.cn.RemoteAddr().String()
 }
 
+// Returns the remote side's certificate, if the socket is using TLS and the
+// certificate was verified.
+func (this *Socket) PeerCertificate() *x509.Certificate {
+	return peerCertificate(this.cn)
+}
+
+// Returns the verified leaf certificate of a TLS connection, or nil.
+func peerCertificate(cn net.Conn) *x509.Certificate {
+	tlsConn, ok := cn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
 // Re-establish the connection.
 func (this *Socket) redial() error {
 	this.Close()
 
-	cn, err := dialHostAndPort(this.hostAndPort, this.timeout)
+	cn, err := dialHostAndPort(this.hostAndPort, this.timeout, this.tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -14,12 +14,15 @@
 package frugal
 
 import (
+	"context"
 	"errors"
 	"log"
 	"sync"
+	"time"
 )
 
 var ErrPoolClosed = errors.New("pool is closed")
+var ErrPoolExhausted = errors.New("pool has no connections available")
 
 // A SocketPool is responsible for pooling re-using connections. It has three
 // main entry points in its API:
@@ -30,60 +33,467 @@ var ErrPoolClosed = errors.New("pool is closed")
 type SocketPool struct {
 	factory     ServiceFactory
 	maxIdle     int
+	maxActive   int
+	waitTimeout time.Duration
+	metrics     Metrics
 	connections []*Connection
 	lock        sync.Mutex
 	closed      bool
+
+	// Number of connections allocated by the pool, including idle ones and
+	// connections currently being dialed.
+	active int
+
+	// Callers of Get() waiting for a connection to be released, in order. Each
+	// channel is signalled (or closed, if the pool closes) to wake its waiter.
+	waiters []chan struct{}
+
+	// Idle connections are closed once they expire.
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+
+	// Optional probe for idle connections, and the number of idle connections
+	// to keep dialed ahead of time.
+	healthCheck func(conn *Connection) error
+	minIdle     int
+
+	// If expiration, health checks, or pre-warming are enabled, a background
+	// goroutine maintains the idle list. Closing stopBackground tells it to
+	// exit, and it closes backgroundDone once it has. Sending to refill asks
+	// it to top up the idle list without waiting for its next tick.
+	stopBackground chan struct{}
+	backgroundDone chan struct{}
+	refill         chan struct{}
+}
+
+// Options that can be passed to NewSocketPoolWithOptions().
+type SocketPoolOptions struct {
+	// Maximum number of idle connections to keep.
+	MaxIdle int
+
+	// Maximum number of connections, idle or in use, that the pool will allocate
+	// at once. If zero, there is no limit. When the limit is reached, Get()
+	// blocks until a connection is returned with Put().
+	MaxActive int
+
+	// Maximum amount of time Get() will wait when MaxActive is reached, after
+	// which it fails with ErrPoolExhausted. If zero, Get() waits indefinitely.
+	WaitTimeout time.Duration
+
+	// If non-zero, idle connections are closed after sitting in the pool for
+	// this long.
+	IdleTimeout time.Duration
+
+	// If non-zero, connections are closed once they are this old, rather than
+	// being reused.
+	MaxLifetime time.Duration
+
+	// How often expired idle connections are evicted in the background. If
+	// zero, this defaults to half of the shorter of IdleTimeout and MaxLifetime.
+	ReapInterval time.Duration
+
+	// If non-nil, this is called periodically on each idle connection. If it
+	// returns an error, the connection is closed. The probe has exclusive use
+	// of the connection while it runs, and must leave it ready for reuse.
+	HealthCheck func(conn *Connection) error
+
+	// How often idle connections are health checked. Required if HealthCheck
+	// is set.
+	HealthCheckInterval time.Duration
+
+	// Number of idle connections to dial ahead of time, so that callers don't
+	// pay dial latency. The pool is filled when it is created, whenever idle
+	// connections are evicted, and after each round of health checks. This is
+	// capped at MaxIdle.
+	MinIdle int
+
+	// If non-nil, receives pool events.
+	Metrics Metrics
 }
 
 // Create a new socket pool with a given maximum number of idle connections.
 func NewSocketPool(factory ServiceFactory, maxIdle int) *SocketPool {
-	return &SocketPool{
-		factory: factory,
-		maxIdle: maxIdle,
-		closed:  false,
+	return NewSocketPoolWithOptions(factory, &SocketPoolOptions{
+		MaxIdle: maxIdle,
+	})
+}
+
+// Create a new socket pool with the given options.
+func NewSocketPoolWithOptions(factory ServiceFactory, options *SocketPoolOptions) *SocketPool {
+	pool := &SocketPool{
+		factory:     factory,
+		maxIdle:     options.MaxIdle,
+		maxActive:   options.MaxActive,
+		waitTimeout: options.WaitTimeout,
+		metrics:     options.Metrics,
+		closed:      false,
+		idleTimeout: options.IdleTimeout,
+		maxLifetime: options.MaxLifetime,
+		healthCheck: options.HealthCheck,
+		minIdle:     options.MinIdle,
+	}
+	if pool.minIdle > pool.maxIdle {
+		pool.minIdle = pool.maxIdle
+	}
+
+	reapEvery := reapInterval(options)
+	checkEvery := time.Duration(0)
+	if options.HealthCheck != nil {
+		checkEvery = options.HealthCheckInterval
+	}
+
+	if reapEvery > 0 || checkEvery > 0 || pool.minIdle > 0 {
+		pool.stopBackground = make(chan struct{})
+		pool.backgroundDone = make(chan struct{})
+		pool.refill = make(chan struct{}, 1)
+		go pool.background(reapEvery, checkEvery)
+	}
+	return pool
+}
+
+// Computes how often the reaper should run, or 0 if it is not needed.
+func reapInterval(options *SocketPoolOptions) time.Duration {
+	if options.ReapInterval > 0 {
+		return options.ReapInterval
+	}
+
+	interval := options.IdleTimeout
+	if interval == 0 || (options.MaxLifetime > 0 && options.MaxLifetime < interval) {
+		interval = options.MaxLifetime
+	}
+	return interval / 2
+}
+
+// Periodically evicts expired idle connections, health checks idle
+// connections, and refills the idle list, until the pool is closed. A zero
+// interval disables the corresponding task.
+func (this *SocketPool) background(reapEvery time.Duration, checkEvery time.Duration) {
+	defer close(this.backgroundDone)
+
+	var reapTicks, checkTicks <-chan time.Time
+	if reapEvery > 0 {
+		ticker := time.NewTicker(reapEvery)
+		defer ticker.Stop()
+		reapTicks = ticker.C
+	}
+	if checkEvery > 0 {
+		ticker := time.NewTicker(checkEvery)
+		defer ticker.Stop()
+		checkTicks = ticker.C
+	}
+
+	this.fillIdle()
+	for {
+		select {
+		case <-reapTicks:
+			this.lock.Lock()
+			this.evictExpired(time.Now())
+			this.lock.Unlock()
+			this.fillIdle()
+		case <-checkTicks:
+			this.checkIdle()
+			this.fillIdle()
+		case <-this.refill:
+			this.fillIdle()
+		case <-this.stopBackground:
+			return
+		}
+	}
+}
+
+// Dials new connections until there are at least MinIdle idle connections,
+// or MaxActive is reached. Stops at the first dial error.
+func (this *SocketPool) fillIdle() {
+	for {
+		if !this.reserveForIdle() {
+			return
+		}
+
+		conn, err := this.dial()
+		if err != nil {
+			this.release()
+			return
+		}
+
+		this.lock.Lock()
+		if this.closed {
+			conn.transport.Close()
+			this.active--
+		} else {
+			conn.idleSince = time.Now()
+			this.connections = append(this.connections, conn)
+			this.wakeWaiter()
+		}
+		this.lock.Unlock()
+	}
+}
+
+// Reserves room to dial a connection for the idle list, if one is needed.
+func (this *SocketPool) reserveForIdle() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed || len(this.connections) >= this.minIdle {
+		return false
+	}
+	if this.maxActive > 0 && this.active >= this.maxActive {
+		return false
+	}
+	this.active++
+	return true
+}
+
+// Runs the health check on every idle connection, closing any that fail.
+// Connections are checked one at a time, and are out of the idle list while
+// being checked.
+func (this *SocketPool) checkIdle() {
+	this.lock.Lock()
+	candidates := append([]*Connection(nil), this.connections...)
+	this.lock.Unlock()
+
+	for _, conn := range candidates {
+		if !this.takeIdle(conn) {
+			// Already handed out or evicted.
+			continue
+		}
+
+		err := conn.transport.Reuse()
+		if err == nil {
+			err = this.healthCheck(conn)
+		}
+
+		this.lock.Lock()
+		if err != nil || this.closed {
+			if err != nil {
+				log.Printf("connection health check error: %s\n", err.Error())
+			}
+			conn.transport.Close()
+			this.active--
+			this.wakeWaiter()
+		} else {
+			// Keep the original idle time, so health checks don't prevent
+			// IdleTimeout from expiring the connection.
+			this.connections = append(this.connections, conn)
+			this.wakeWaiter()
+		}
+		this.lock.Unlock()
+	}
+}
+
+// Removes a specific connection from the idle list. Returns false if it was
+// not there.
+func (this *SocketPool) takeIdle(conn *Connection) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for i, other := range this.connections {
+		if other == conn {
+			this.connections = append(this.connections[:i], this.connections[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether a connection has outlived MaxLifetime.
+func (this *SocketPool) isTooOld(conn *Connection, now time.Time) bool {
+	return this.maxLifetime > 0 && now.Sub(conn.createdAt) >= this.maxLifetime
+}
+
+// Returns whether an idle connection should be closed rather than reused.
+func (this *SocketPool) isExpired(conn *Connection, now time.Time) bool {
+	if this.isTooOld(conn, now) {
+		return true
+	}
+	return this.idleTimeout > 0 && now.Sub(conn.idleSince) >= this.idleTimeout
+}
+
+// Closes any expired idle connections, and asks for replacements if MinIdle
+// is set. The lock must be held.
+func (this *SocketPool) evictExpired(now time.Time) {
+	if this.idleTimeout == 0 && this.maxLifetime == 0 {
+		return
 	}
+
+	kept := []*Connection{}
+	for _, conn := range this.connections {
+		if !this.isExpired(conn, now) {
+			kept = append(kept, conn)
+			continue
+		}
+
+		conn.transport.Close()
+		this.active--
+		this.wakeWaiter()
+	}
+	if len(kept) < len(this.connections) {
+		this.requestRefill()
+	}
+	this.connections = kept
 }
 
-// Get a transport and protocol from the cache if one is available.
-func (this *SocketPool) getFree() (*Connection, error) {
+// Wakes the background goroutine to refill the idle list, if MinIdle is set.
+// The lock must be held.
+func (this *SocketPool) requestRefill() {
+	if this.minIdle == 0 || this.closed {
+		return
+	}
+	select {
+	case this.refill <- struct{}{}:
+	default:
+		// A refill is already pending.
+	}
+}
+
+// Get a transport and protocol from the cache if one is available. If not,
+// and there is room to allocate a new connection, room is reserved and nil is
+// returned. Otherwise, a channel is returned that will be signalled when a
+// connection is released.
+func (this *SocketPool) getFree() (*Connection, chan struct{}, error) {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
 	if this.closed {
-		return nil, ErrPoolClosed
+		return nil, nil, ErrPoolClosed
 	}
 
+	this.evictExpired(time.Now())
+
 	if len(this.connections) == 0 {
-		return nil, nil
+		if this.maxActive > 0 && this.active >= this.maxActive {
+			wait := make(chan struct{}, 1)
+			this.waiters = append(this.waiters, wait)
+			return nil, wait, nil
+		}
+		this.active++
+		return nil, nil, nil
 	}
 
 	tp := this.connections[len(this.connections)-1]
 	this.connections = this.connections[:len(this.connections)-1]
 
 	// Ask to re-use the connection. If that doesn't work, log the error and
-	// just signal for a new connection.
+	// just signal for a new connection, which takes this one's place.
 	if err := tp.transport.Reuse(); err != nil {
 		log.Printf("connection re-use error: %s\n", err.Error())
-		return nil, nil
+		tp.transport.Close()
+		if this.metrics != nil {
+			this.metrics.PoolReuseFailed(err)
+		}
+		return nil, nil, nil
+	}
+	return tp, nil, nil
+}
+
+// Wakes the longest-waiting caller of Get(), if any. The lock must be held.
+func (this *SocketPool) wakeWaiter() {
+	if len(this.waiters) == 0 {
+		return
+	}
+	wait := this.waiters[0]
+	this.waiters = this.waiters[1:]
+	wait <- struct{}{}
+}
+
+// Gives up a reservation made by getFree(), for example after a failed dial.
+func (this *SocketPool) release() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.active--
+	this.wakeWaiter()
+}
+
+// Stops waiting on a channel returned by getFree(). If the waiter was already
+// signalled, the signal is passed on so it isn't lost.
+func (this *SocketPool) cancelWait(wait chan struct{}) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for i, other := range this.waiters {
+		if other == wait {
+			this.waiters = append(this.waiters[:i], this.waiters[i+1:]...)
+			return
+		}
+	}
+
+	select {
+	case _, ok := <-wait:
+		if ok {
+			this.wakeWaiter()
+		}
+	default:
 	}
-	return tp, nil
 }
 
 // Returns a transport and factory. If any idle transports are available, one
-// is returned, otherwise a new one is allocated.
+// is returned, otherwise a new one is allocated. If the pool has reached its
+// MaxActive limit, this blocks until a connection is released, failing with
+// ErrPoolExhausted if WaitTimeout expires first.
 //
 // Callers may use Connection.Client to store per-connection data, for
 // example, to cache thrift client objects so they don't have to be reallocated.
 func (this *SocketPool) Get() (*Connection, error) {
-	conn, err := this.getFree()
-	if err != nil {
-		return nil, err
+	return this.GetContext(context.Background())
+}
+
+// Same as Get(), except that waiting for a connection also stops when the
+// given context is done, in which case ErrPoolExhausted is returned.
+func (this *SocketPool) GetContext(ctx context.Context) (*Connection, error) {
+	started := time.Now()
+	conn, reused, err := this.get(ctx)
+	if this.metrics != nil {
+		this.metrics.PoolGet(reused, time.Since(started), err)
 	}
-	if conn != nil {
-		return conn, nil
+	return conn, err
+}
+
+func (this *SocketPool) get(ctx context.Context) (*Connection, bool, error) {
+	var timeout <-chan time.Time
+	for {
+		conn, wait, err := this.getFree()
+		if err != nil {
+			return nil, false, err
+		}
+		if conn != nil {
+			return conn, true, nil
+		}
+
+		if wait == nil {
+			conn, err = this.dial()
+			if err != nil {
+				this.release()
+			}
+			return conn, false, err
+		}
+
+		if timeout == nil && this.waitTimeout > 0 {
+			timer := time.NewTimer(this.waitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case <-wait:
+			// Either a connection was released, or the pool closed. Try again.
+		case <-ctx.Done():
+			this.cancelWait(wait)
+			return nil, false, ErrPoolExhausted
+		case <-timeout:
+			this.cancelWait(wait)
+			return nil, false, ErrPoolExhausted
+		}
 	}
+}
 
-	return this.factory.Connect()
+// Allocates a new connection from the service factory.
+func (this *SocketPool) dial() (*Connection, error) {
+	started := time.Now()
+	conn, err := this.factory.Connect()
+	if this.metrics != nil {
+		this.metrics.PoolDial(time.Since(started), err)
+	}
+	return conn, err
 }
 
 // Puts a socket and protocol back into the free pool. This is intended to be
@@ -94,30 +504,87 @@ func (this *SocketPool) Get() (*Connection, error) {
 //     }
 //     defer pool.Put(cn, &err)
 func (this *SocketPool) Put(conn *Connection, err *error) {
+	if observer, ok := this.factory.(ConnectionObserver); ok {
+		observer.ConnectionReleased(conn, *err)
+	}
+
+	kept := this.put(conn, *err)
+	if this.metrics != nil {
+		this.metrics.PoolPut(kept)
+	}
+}
+
+// Returns the connection to the idle list, or closes it. Returns whether the
+// connection was kept.
+func (this *SocketPool) put(conn *Connection, err error) bool {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
-	if *err != nil || this.closed {
+	now := time.Now()
+	if err != nil || this.closed || len(this.connections) >= this.maxIdle || this.isTooOld(conn, now) {
 		conn.transport.Close()
-		return
+		this.active--
+		this.wakeWaiter()
+		return false
 	}
 
-	if len(this.connections) >= this.maxIdle {
-		conn.transport.Close()
-		return
-	}
+	conn.idleSince = now
 	this.connections = append(this.connections, conn)
+	this.wakeWaiter()
+	return true
+}
+
+// Returns the number of connections allocated by the pool, whether idle or
+// in use.
+func (this *SocketPool) Active() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.active
+}
+
+// Returns the number of idle connections.
+func (this *SocketPool) Idle() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return len(this.connections)
 }
 
 // Close all pending connections, then mark the pool as closed so no further
-// connections will be cached.
+// connections will be cached. This waits for background maintenance, if any,
+// to exit.
 func (this *SocketPool) Close() {
+	if this.close() && this.stopBackground != nil {
+		close(this.stopBackground)
+		<-this.backgroundDone
+	}
+}
+
+// Closes idle connections and marks the pool as closed. Returns false if the
+// pool was already closed.
+func (this *SocketPool) close() bool {
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
+	if this.closed {
+		return false
+	}
+
+	closed := len(this.connections)
 	for _, conn := range this.connections {
 		conn.transport.Close()
 	}
+	this.active -= closed
 	this.connections = nil
 	this.closed = true
+
+	// Wake everyone waiting in Get(); they will see that the pool is closed.
+	for _, wait := range this.waiters {
+		close(wait)
+	}
+	this.waiters = nil
+
+	if this.metrics != nil {
+		this.metrics.PoolClosed(closed)
+	}
+	return true
 }
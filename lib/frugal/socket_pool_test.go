@@ -14,6 +14,10 @@
 package frugal
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"git.apache.org/thrift.git/lib/go/thrift"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -153,4 +157,267 @@ var _ = Describe("SocketPool", func() {
 		Expect(err).To(BeNil())
 		Expect(buffer).To(Equal(data))
 	})
+
+	It("Blocks when MaxActive is reached until a connection is returned", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:   1,
+			MaxActive: 1,
+		})
+		defer pool.Close()
+
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(pool.Active()).To(Equal(1))
+
+		result := make(chan *Connection)
+		go (func() {
+			defer GinkgoRecover()
+			other, err := pool.Get()
+			Expect(err).To(BeNil())
+			result <- other
+		})()
+
+		Consistently(result, 50*time.Millisecond).ShouldNot(Receive())
+		pool.Put(conn, &err)
+
+		var other *Connection
+		Eventually(result).Should(Receive(&other))
+		Expect(other).To(Equal(conn))
+		Expect(pool.Active()).To(Equal(1))
+	})
+
+	It("Fails with ErrPoolExhausted when the wait times out", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:     1,
+			MaxActive:   1,
+			WaitTimeout: 20 * time.Millisecond,
+		})
+		defer pool.Close()
+
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+
+		_, err = pool.Get()
+		Expect(err).To(Equal(ErrPoolExhausted))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = pool.GetContext(ctx)
+		Expect(err).To(Equal(ErrPoolExhausted))
+
+		// Discarding the connection frees room for a new one.
+		failed := ErrSocketClosed
+		pool.Put(conn, &failed)
+		Expect(pool.Active()).To(Equal(0))
+		conn, err = pool.Get()
+		Expect(err).To(BeNil())
+		Expect(conn).NotTo(BeNil())
+	})
+
+	It("Wakes waiters with ErrPoolClosed on Close", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:   1,
+			MaxActive: 1,
+		})
+
+		_, err := pool.Get()
+		Expect(err).To(BeNil())
+
+		errors := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go (func() {
+				_, err := pool.Get()
+				errors <- err
+			})()
+		}
+
+		Consistently(errors, 50*time.Millisecond).ShouldNot(Receive())
+		pool.Close()
+		Eventually(errors).Should(Receive(Equal(ErrPoolClosed)))
+		Eventually(errors).Should(Receive(Equal(ErrPoolClosed)))
+	})
+
+	It("Evicts connections that sit idle too long", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:      2,
+			IdleTimeout:  30 * time.Millisecond,
+			ReapInterval: 10 * time.Millisecond,
+		})
+		defer pool.Close()
+
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		pool.Put(conn, &err)
+		Expect(pool.Idle()).To(Equal(1))
+
+		// The connection should be closed in the background.
+		Eventually(pool.Idle).Should(Equal(0))
+		Expect(pool.Active()).To(Equal(0))
+		Expect(conn.Transport().IsOpen()).To(BeFalse())
+
+		// The next Get() dials a fresh connection.
+		other, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(other).NotTo(Equal(conn))
+	})
+
+	It("Closes connections older than MaxLifetime", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:      2,
+			MaxLifetime:  30 * time.Millisecond,
+			ReapInterval: time.Hour,
+		})
+		defer pool.Close()
+
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		pool.Put(conn, &err)
+
+		// Still young enough to be reused.
+		reused, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(reused).To(Equal(conn))
+
+		// Once it's too old, it is closed rather than returned to the pool.
+		time.Sleep(40 * time.Millisecond)
+		pool.Put(conn, &err)
+		Expect(pool.Idle()).To(Equal(0))
+		Expect(pool.Active()).To(Equal(0))
+		Expect(conn.Transport().IsOpen()).To(BeFalse())
+	})
+
+	It("Stops background maintenance on Close", func() {
+		pool := NewSocketPoolWithOptions(NewTestClientFactory(), &SocketPoolOptions{
+			IdleTimeout: time.Millisecond,
+		})
+		pool.Close()
+		Expect(pool.backgroundDone).To(BeClosed())
+
+		// Closing again is harmless.
+		pool.Close()
+	})
+
+	It("Pre-dials MinIdle connections", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		metrics := NewMemoryMetrics(nil)
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle: 4,
+			MinIdle: 2,
+			Metrics: metrics,
+		})
+		defer pool.Close()
+
+		Eventually(pool.Idle).Should(Equal(2))
+		Expect(pool.Active()).To(Equal(2))
+
+		// Both gets should be served without dialing.
+		for i := 0; i < 2; i++ {
+			_, err := pool.Get()
+			Expect(err).To(BeNil())
+		}
+		Expect(metrics.Pool().Dials).To(Equal(uint64(2)))
+		Expect(metrics.Pool().Reuses).To(Equal(uint64(2)))
+	})
+
+	It("Refills MinIdle after evicting expired connections in Get", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		// The reaper never runs, so only Get() evicts.
+		metrics := NewMemoryMetrics(nil)
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle:      1,
+			MinIdle:      1,
+			MaxLifetime:  20 * time.Millisecond,
+			ReapInterval: time.Hour,
+			Metrics:      metrics,
+		})
+		defer pool.Close()
+
+		Eventually(pool.Idle).Should(Equal(1))
+		time.Sleep(30 * time.Millisecond)
+
+		// The expired connection is replaced by one for the caller, and another
+		// for the idle list.
+		_, err := pool.Get()
+		Expect(err).To(BeNil())
+		Eventually(pool.Idle).Should(Equal(1))
+		Expect(metrics.Pool().Dials).To(Equal(uint64(3)))
+	})
+
+	It("Closes idle connections that fail a health check and refills", func() {
+		server := StartTestServer(NewTestServerCallbacks(nil), &ServerOptions{})
+		defer server.Stop()
+
+		var lock sync.Mutex
+		checked := map[*Connection]int{}
+		var broken *Connection
+
+		metrics := NewMemoryMetrics(nil)
+		pool := NewSocketPoolWithOptions(&TestServerFactory{server}, &SocketPoolOptions{
+			MaxIdle: 2,
+			MinIdle: 1,
+			Metrics: metrics,
+			HealthCheck: func(conn *Connection) error {
+				lock.Lock()
+				defer lock.Unlock()
+
+				checked[conn]++
+				if conn == broken {
+					return ErrSocketClosed
+				}
+
+				// Use a real round trip as the probe.
+				if err := SendTestCall(conn, "ping"); err != nil {
+					return err
+				}
+				_, err := ReceiveTestReply(conn)
+				return err
+			},
+			HealthCheckInterval: 10 * time.Millisecond,
+		})
+		defer pool.Close()
+
+		Eventually(pool.Idle).Should(Equal(1))
+		conn, err := pool.Get()
+		Expect(err).To(BeNil())
+		pool.Put(conn, &err)
+
+		Eventually(func() int {
+			lock.Lock()
+			defer lock.Unlock()
+			return checked[conn]
+		}).Should(BeNumerically(">=", 1))
+
+		lock.Lock()
+		broken = conn
+		lock.Unlock()
+
+		// The broken connection is closed and a new one takes its place.
+		Eventually(func() uint64 {
+			return metrics.Pool().Dials
+		}).Should(Equal(uint64(2)))
+		Eventually(pool.Idle).Should(Equal(1))
+
+		other, err := pool.Get()
+		Expect(err).To(BeNil())
+		Expect(other).NotTo(Equal(conn))
+	})
 })
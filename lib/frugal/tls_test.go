@@ -0,0 +1,220 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// A throwaway certificate authority, with a server and client certificate
+// signed by it.
+type TestCertificates struct {
+	Pool   *x509.CertPool
+	Server tls.Certificate
+	Client tls.Certificate
+}
+
+func NewTestCertificates() *TestCertificates {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "frugal test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	Expect(err).To(BeNil())
+	ca, err := x509.ParseCertificate(caDer)
+	Expect(err).To(BeNil())
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		Expect(err).To(BeNil())
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &TestCertificates{
+		Pool:   pool,
+		Server: issue(2, "server", x509.ExtKeyUsageServerAuth),
+		Client: issue(3, "client", x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// Server callbacks that remember the peer certificate of the last request.
+type TestTLSServerCallbacks struct {
+	*TestServerCallbacks
+
+	lock sync.Mutex
+	peer *x509.Certificate
+	seen bool
+}
+
+func (this *TestTLSServerCallbacks) ProcessRequest(request *Request) error {
+	this.lock.Lock()
+	this.peer = request.PeerCertificate
+	this.seen = true
+	this.lock.Unlock()
+	return this.TestServerCallbacks.ProcessRequest(request)
+}
+
+func (this *TestTLSServerCallbacks) Peer() (*x509.Certificate, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.peer, this.seen
+}
+
+var _ = Describe("TLS", func() {
+	var certs *TestCertificates
+	var callbacks *TestTLSServerCallbacks
+
+	BeforeEach(func() {
+		certs = NewTestCertificates()
+		callbacks = &TestTLSServerCallbacks{TestServerCallbacks: NewTestServerCallbacks(nil)}
+	})
+
+	roundTrip := func(socket Transport) error {
+		conn := NewConnectionFromFactory(socket, thrift.NewTBinaryProtocolFactoryDefault())
+		defer socket.Close()
+		if err := SendTestCall(conn, "hello"); err != nil {
+			return err
+		}
+		_, err := ReceiveTestReply(conn)
+		return err
+	}
+
+	It("Serves requests over TLS", func() {
+		server := StartTestServer(callbacks, &ServerOptions{
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{certs.Server}},
+		})
+		defer server.Stop()
+
+		socket, err := NewTLSSocket(server.Addr().String(), time.Second, &tls.Config{RootCAs: certs.Pool})
+		Expect(err).To(BeNil())
+		Expect(socket.PeerCertificate().Subject.CommonName).To(Equal("server"))
+		Expect(roundTrip(socket)).To(BeNil())
+
+		peer, seen := callbacks.Peer()
+		Expect(seen).To(BeTrue())
+		Expect(peer).To(BeNil())
+	})
+
+	It("Rejects plain connections", func() {
+		server := StartTestServer(callbacks, &ServerOptions{
+			ClientTimeout: time.Second,
+			TLSConfig:     &tls.Config{Certificates: []tls.Certificate{certs.Server}},
+		})
+		defer server.Stop()
+
+		socket, err := NewSocket(server.Addr().String(), time.Second)
+		Expect(err).To(BeNil())
+		Expect(roundTrip(socket)).NotTo(BeNil())
+		Eventually(func() int {
+			return callbacks.ErrorCount("tls-handshake")
+		}).Should(Equal(1))
+	})
+
+	It("Times out stalled handshakes", func() {
+		server := StartTestServer(callbacks, &ServerOptions{
+			MaxConnections:   1,
+			HandshakeTimeout: 50 * time.Millisecond,
+			TLSConfig:        &tls.Config{Certificates: []tls.Certificate{certs.Server}},
+		})
+		defer server.Stop()
+
+		// Takes the only connection slot without ever starting the handshake.
+		stalled, err := net.Dial("tcp", server.Addr().String())
+		Expect(err).To(BeNil())
+		defer stalled.Close()
+		Eventually(func() int {
+			return callbacks.ErrorCount("tls-handshake")
+		}).Should(Equal(1))
+
+		socket, err := NewTLSSocket(server.Addr().String(), time.Second, &tls.Config{RootCAs: certs.Pool})
+		Expect(err).To(BeNil())
+		Expect(roundTrip(socket)).To(BeNil())
+	})
+
+	It("Exposes verified client certificates with mutual TLS", func() {
+		server := StartTestServer(callbacks, &ServerOptions{
+			TLSConfig: &tls.Config{
+				Certificates: []tls.Certificate{certs.Server},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    certs.Pool,
+			},
+		})
+		defer server.Stop()
+
+		config := &tls.Config{
+			RootCAs:      certs.Pool,
+			Certificates: []tls.Certificate{certs.Client},
+		}
+		socket, err := NewResumeableTLSSocket(server.Addr().String(), time.Second, config)
+		Expect(err).To(BeNil())
+		Expect(roundTrip(socket)).To(BeNil())
+
+		peer, _ := callbacks.Peer()
+		Expect(peer).NotTo(BeNil())
+		Expect(peer.Subject.CommonName).To(Equal("client"))
+
+		// Clients without a certificate are turned away.
+		socket, err = NewResumeableTLSSocket(server.Addr().String(), time.Second, &tls.Config{RootCAs: certs.Pool})
+		if err == nil {
+			err = roundTrip(socket)
+		}
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Redials resumeable sockets over TLS", func() {
+		server := StartTestServer(callbacks, &ServerOptions{
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{certs.Server}},
+		})
+		defer server.Stop()
+
+		socket, err := NewResumeableTLSSocket(server.Addr().String(), time.Second, &tls.Config{RootCAs: certs.Pool})
+		Expect(err).To(BeNil())
+		Expect(socket.redial()).To(BeNil())
+		Expect(socket.PeerCertificate()).NotTo(BeNil())
+		Expect(roundTrip(socket)).To(BeNil())
+	})
+})
@@ -0,0 +1,203 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Header names used to propagate trace information. These follow the Zipkin
+// B3 convention, with ids encoded as hex strings.
+const (
+	TraceIdHeader      = "X-B3-TraceId"
+	SpanIdHeader       = "X-B3-SpanId"
+	ParentSpanIdHeader = "X-B3-ParentSpanId"
+	SampledHeader      = "X-B3-Sampled"
+)
+
+// Span kinds.
+const (
+	SpanKindClient = "client"
+	SpanKindServer = "server"
+)
+
+// The identifiers needed to join a trace.
+type SpanContext struct {
+	TraceId  uint64
+	SpanId   uint64
+	ParentId uint64
+	Sampled  bool
+}
+
+// Writes the span context into a set of headers.
+func (this SpanContext) Inject(carrier HeaderCarrier) {
+	carrier.SetWriteHeader(TraceIdHeader, strconv.FormatUint(this.TraceId, 16))
+	carrier.SetWriteHeader(SpanIdHeader, strconv.FormatUint(this.SpanId, 16))
+	if this.ParentId != 0 {
+		carrier.SetWriteHeader(ParentSpanIdHeader, strconv.FormatUint(this.ParentId, 16))
+	}
+	if this.Sampled {
+		carrier.SetWriteHeader(SampledHeader, "1")
+	} else {
+		carrier.SetWriteHeader(SampledHeader, "0")
+	}
+}
+
+// Reads a span context from a set of headers. Returns nil if the headers do
+// not contain a valid trace.
+func ExtractSpanContext(headers map[string]string) *SpanContext {
+	traceId, err := strconv.ParseUint(headers[TraceIdHeader], 16, 64)
+	if err != nil || traceId == 0 {
+		return nil
+	}
+	spanId, err := strconv.ParseUint(headers[SpanIdHeader], 16, 64)
+	if err != nil || spanId == 0 {
+		return nil
+	}
+
+	context := &SpanContext{
+		TraceId: traceId,
+		SpanId:  spanId,
+		Sampled: headers[SampledHeader] != "0",
+	}
+	if parent, ok := headers[ParentSpanIdHeader]; ok {
+		context.ParentId, _ = strconv.ParseUint(parent, 16, 64)
+	}
+	return context
+}
+
+// A single timed operation within a trace.
+type Span struct {
+	Context   SpanContext
+	Name      string
+	Kind      string
+	StartTime time.Time
+	Duration  time.Duration
+	Tags      map[string]string
+
+	// The error the operation finished with, if any.
+	Err error
+
+	tracer *Tracer
+}
+
+// Attaches a key/value tag to the span.
+func (this *Span) SetTag(key string, value string) {
+	this.Tags[key] = value
+}
+
+// Ends the span and hands it to the tracer's recorder.
+func (this *Span) Finish(err error) {
+	this.Duration = time.Since(this.StartTime)
+	this.Err = err
+	if this.Context.Sampled {
+		this.tracer.recorder.RecordSpan(this)
+	}
+}
+
+// Receives finished spans.
+type SpanRecorder interface {
+	RecordSpan(span *Span)
+}
+
+// Creates spans and sends them to a recorder once finished.
+type Tracer struct {
+	recorder SpanRecorder
+
+	lock   sync.Mutex
+	random *rand.Rand
+}
+
+func NewTracer(recorder SpanRecorder) *Tracer {
+	return &Tracer{
+		recorder: recorder,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (this *Tracer) newId() uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for {
+		if id := uint64(this.random.Int63()); id != 0 {
+			return id
+		}
+	}
+}
+
+// Starts a new span. If parent is nil, the span begins a new trace; otherwise
+// it joins the parent's trace as a child.
+func (this *Tracer) StartSpan(name string, kind string, parent *SpanContext) *Span {
+	context := SpanContext{
+		SpanId:  this.newId(),
+		Sampled: true,
+	}
+	if parent != nil {
+		context.TraceId = parent.TraceId
+		context.ParentId = parent.SpanId
+		context.Sampled = parent.Sampled
+	} else {
+		context.TraceId = this.newId()
+	}
+
+	return &Span{
+		Context:   context,
+		Name:      name,
+		Kind:      kind,
+		StartTime: time.Now(),
+		Tags:      map[string]string{},
+		tracer:    this,
+	}
+}
+
+// Starts a client span for a call on this connection. If the connection's
+// transport can carry headers, the span's context is sent along with the
+// next message so the server can join the trace. The caller must call
+// Finish() on the span once the call completes.
+func (this *Connection) StartSpan(tracer *Tracer, method string, parent *SpanContext) *Span {
+	span := tracer.StartSpan(method, SpanKindClient, parent)
+	if carrier, ok := this.transport.(HeaderCarrier); ok {
+		span.Context.Inject(carrier)
+	}
+	return span
+}
+
+// A span recorder that keeps every span in memory. This is mainly useful for
+// testing.
+type MemorySpanRecorder struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+func NewMemorySpanRecorder() *MemorySpanRecorder {
+	return &MemorySpanRecorder{}
+}
+
+// Implements SpanRecorder.RecordSpan.
+func (this *MemorySpanRecorder) RecordSpan(span *Span) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.spans = append(this.spans, span)
+}
+
+// Returns all spans recorded so far, in the order they finished.
+func (this *MemorySpanRecorder) Spans() []*Span {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*Span(nil), this.spans...)
+}
@@ -0,0 +1,143 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package frugal
+
+import (
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Test server callbacks that speak the header protocol.
+type TestHeaderServerCallbacks struct {
+	*TestServerCallbacks
+}
+
+func (this *TestHeaderServerCallbacks) GetProtocolsForClient(client Transport) (thrift.TProtocol, thrift.TProtocol) {
+	transport := NewHeaderTransport(client, HeaderProtocolBinary)
+	factory := thrift.NewTBinaryProtocolFactoryDefault()
+	return factory.GetProtocol(transport), factory.GetProtocol(transport)
+}
+
+func DialTestHeaderServer(server *Server) *Connection {
+	socket, err := NewSocket(server.Addr().String(), time.Second)
+	Expect(err).To(BeNil())
+	transport := NewHeaderTransport(socket, HeaderProtocolBinary)
+	return NewConnectionFromFactory(transport, thrift.NewTBinaryProtocolFactoryDefault())
+}
+
+var _ = Describe("Tracing", func() {
+	It("Joins the caller's trace on the server", func() {
+		recorder := NewMemorySpanRecorder()
+		tracer := NewTracer(recorder)
+		server := StartTestServer(&TestHeaderServerCallbacks{NewTestServerCallbacks(nil)}, &ServerOptions{
+			Tracer: tracer,
+		})
+		defer server.Stop()
+
+		conn := DialTestHeaderServer(server)
+		defer conn.Transport().Close()
+
+		root := tracer.StartSpan("root", SpanKindClient, nil)
+		span := conn.StartSpan(tracer, "ping", &root.Context)
+		Expect(SendTestCall(conn, "ping")).To(BeNil())
+		name, err := ReceiveTestReply(conn)
+		Expect(err).To(BeNil())
+		Expect(name).To(Equal("ping"))
+		span.Finish(err)
+
+		Eventually(func() int {
+			return len(recorder.Spans())
+		}).Should(Equal(2))
+
+		spans := recorder.Spans()
+		var serverSpan, clientSpan *Span
+		for _, recorded := range spans {
+			if recorded.Kind == SpanKindServer {
+				serverSpan = recorded
+			} else {
+				clientSpan = recorded
+			}
+		}
+		Expect(serverSpan).NotTo(BeNil())
+		Expect(clientSpan).NotTo(BeNil())
+		Expect(clientSpan.Context.TraceId).To(Equal(root.Context.TraceId))
+		Expect(clientSpan.Context.ParentId).To(Equal(root.Context.SpanId))
+		Expect(serverSpan.Name).To(Equal("ping"))
+		Expect(serverSpan.Context.TraceId).To(Equal(root.Context.TraceId))
+		Expect(serverSpan.Context.ParentId).To(Equal(clientSpan.Context.SpanId))
+	})
+
+	It("Starts a new trace for clients without headers", func() {
+		recorder := NewMemorySpanRecorder()
+		server := StartTestServer(&TestHeaderServerCallbacks{NewTestServerCallbacks(nil)}, &ServerOptions{
+			Tracer: NewTracer(recorder),
+		})
+		defer server.Stop()
+
+		// A plain unframed client should still be answered.
+		conn := DialTestServer(server)
+		defer conn.Transport().Close()
+		Expect(SendTestCall(conn, "ping")).To(BeNil())
+		_, err := ReceiveTestReply(conn)
+		Expect(err).To(BeNil())
+
+		Eventually(func() int {
+			return len(recorder.Spans())
+		}).Should(Equal(1))
+		span := recorder.Spans()[0]
+		Expect(span.Context.ParentId).To(Equal(uint64(0)))
+		Expect(span.Context.TraceId).NotTo(Equal(uint64(0)))
+	})
+
+	It("Does not record unsampled spans", func() {
+		recorder := NewMemorySpanRecorder()
+		tracer := NewTracer(recorder)
+		span := tracer.StartSpan("call", SpanKindClient, &SpanContext{TraceId: 1, SpanId: 2})
+		span.Finish(nil)
+		Expect(recorder.Spans()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("HeaderTransport", func() {
+	It("Round-trips headers through a header server", func() {
+		server := StartTestServer(&TestHeaderServerCallbacks{NewTestServerCallbacks(nil)}, &ServerOptions{})
+		defer server.Stop()
+
+		conn := DialTestHeaderServer(server)
+		defer conn.Transport().Close()
+
+		transport := conn.Transport().(*HeaderTransport)
+		for i := 0; i < 2; i++ {
+			transport.SetWriteHeader("key", "value")
+			Expect(SendTestCall(conn, "ping")).To(BeNil())
+			_, err := ReceiveTestReply(conn)
+			Expect(err).To(BeNil())
+			Expect(conn.Transport().Reuse()).To(BeNil())
+		}
+	})
+
+	It("Extracts span contexts from headers", func() {
+		headers := map[string]string{
+			TraceIdHeader:      "a",
+			SpanIdHeader:       "b",
+			ParentSpanIdHeader: "c",
+		}
+		context := ExtractSpanContext(headers)
+		Expect(context).To(Equal(&SpanContext{TraceId: 10, SpanId: 11, ParentId: 12, Sampled: true}))
+		Expect(ExtractSpanContext(map[string]string{})).To(BeNil())
+	})
+})
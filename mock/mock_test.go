@@ -0,0 +1,101 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package mock
+
+import (
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mock service testing")
+}
+
+const TestSchema = `
+enum Role {
+	ADMIN = 1
+	MEMBER = 2
+}
+
+struct User {
+	1: required i64 id
+	2: required string name
+	3: optional Role role
+}
+
+service Users extends Base {
+	User getUser(1: i64 id) throws (1: NotFound notFound)
+	list<User> findUsers(1: string prefix, 2: Role role)
+	void deleteUser(1: i64 id)
+	oneway void touch(1: i64 id)
+}
+` + sematest.UsersSchema
+
+// Parses the test schema and returns the Users service.
+func ParseTestService() *parser.ServiceNode {
+	service, err := dynamic.FindService(sematest.CompileSchema(TestSchema), "Users")
+	Expect(err).To(BeNil())
+	return service
+}
+
+// A client for a mock processor, connected in memory.
+type TestClient struct {
+	service *parser.ServiceNode
+	conn    *frugal.Connection
+	seqId   int32
+}
+
+func NewTestClient(service *parser.ServiceNode, processor *Processor) *TestClient {
+	server, err := frugal.NewServer(processor, &frugal.ServerOptions{ListenAddr: "127.0.0.1:0"})
+	Expect(err).To(BeNil())
+
+	conn, err := frugal.NewMemoryServiceFactory(server, nil, 5*time.Second).Connect()
+	Expect(err).To(BeNil())
+	return &TestClient{service: service, conn: conn}
+}
+
+func (this *TestClient) Close() {
+	this.conn.Transport().Close()
+}
+
+// Calls a method with JSON arguments, and returns the result as JSON.
+func (this *TestClient) Call(name string, args string) (interface{}, error) {
+	method := dynamic.FindServiceMethod(this.service, name)
+	Expect(method).NotTo(BeNil())
+	value, err := dynamic.ArgsFromJSON(method, []byte(args))
+	Expect(err).To(BeNil())
+
+	this.seqId++
+	result, err := dynamic.Call(this.conn.Input(), this.conn.Output(), name, this.seqId, method, value)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return argsToJSON(result), nil
+}
+
+func ExpectTestApplicationException(err error, typeId int32) {
+	Expect(err).NotTo(BeNil())
+	exception, ok := err.(thrift.TApplicationException)
+	Expect(ok).To(BeTrue())
+	Expect(exception.TypeId()).To(Equal(typeId))
+}
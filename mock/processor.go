@@ -0,0 +1,210 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Options that can be passed to NewProcessor().
+type Options struct {
+	// Protocol for messages. If nil, the binary protocol is used.
+	ProtocolFactory thrift.TProtocolFactory
+
+	// If non-nil, receives errors from the server.
+	LogError func(context string, err error)
+}
+
+// A call received by a Processor.
+type Call struct {
+	Method     string
+	SequenceId int32
+	Time       time.Time
+
+	// The decoded arguments. If the service has no such method, these are
+	// decoded without a schema.
+	Args *dynamic.Struct
+
+	// The index of the rule that answered the call, or -1 if none did.
+	Rule int
+}
+
+// Returns the arguments in the same JSON form that rules match against.
+func (this *Call) ArgsJSON() map[string]interface{} {
+	return argsToJSON(this.Args)
+}
+
+// A fake service, built from its IDL. Calls are decoded with the schema and
+// answered by the first matching rule; calls that match no rule get an
+// INTERNAL_ERROR exception, and calls to unknown methods get UNKNOWN_METHOD.
+// Every call is recorded, so tests can make assertions about what was sent.
+//
+// A Processor is a complete frugal.ServerInterface, so it can be served
+// directly:
+//
+//     processor, err := mock.NewProcessor(service, rules, &mock.Options{})
+//     server, err := frugal.NewServer(processor, &frugal.ServerOptions{ListenAddr: addr})
+//     go server.Serve()
+//
+// It can also be registered with a frugal.Multiplexer.
+type Processor struct {
+	service *parser.ServiceNode
+	rules   []*compiledRule
+	options Options
+
+	lock  sync.Mutex
+	calls []*Call
+}
+
+// Creates a processor for a service, which must have been through semantic
+// analysis. Every rule is checked against the service up front.
+func NewProcessor(service *parser.ServiceNode, rules []*Rule, options *Options) (*Processor, error) {
+	processor := &Processor{
+		service: service,
+		options: *options,
+		calls:   []*Call{},
+	}
+	if processor.options.ProtocolFactory == nil {
+		processor.options.ProtocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+	}
+
+	for i, rule := range rules {
+		compiled, err := compileRule(service, i, rule)
+		if err != nil {
+			return nil, err
+		}
+		processor.rules = append(processor.rules, compiled)
+	}
+	return processor, nil
+}
+
+// Returns every call received so far, in order.
+func (this *Processor) Calls() []*Call {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*Call(nil), this.calls...)
+}
+
+// Returns every call to the given method received so far, in order.
+func (this *Processor) CallsTo(method string) []*Call {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	calls := []*Call{}
+	for _, call := range this.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Forgets every call received so far.
+func (this *Processor) Reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.calls = []*Call{}
+}
+
+func (this *Processor) record(call *Call) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.calls = append(this.calls, call)
+}
+
+// Implements frugal.ServerInterface.
+func (this *Processor) GetProtocolsForClient(client frugal.Transport) (thrift.TProtocol, thrift.TProtocol) {
+	factory := this.options.ProtocolFactory
+	return factory.GetProtocol(client), factory.GetProtocol(client)
+}
+
+// Implements frugal.ServerInterface.
+func (this *Processor) LogError(context string, err error) {
+	if this.options.LogError != nil {
+		this.options.LogError(context, err)
+	}
+}
+
+// Converts decoded arguments to parsed JSON, so they can be matched against
+// rules.
+func argsToJSON(args *dynamic.Struct) map[string]interface{} {
+	data, err := json.Marshal(dynamic.StructToJSON(args))
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	value, err := dynamic.ParseJSON(data)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	return value.(map[string]interface{})
+}
+
+// Implements frugal.Processor.
+func (this *Processor) ProcessRequest(request *frugal.Request) error {
+	method := dynamic.FindServiceMethod(this.service, request.MethodName)
+
+	var node *parser.StructNode
+	if method != nil {
+		node = dynamic.ArgsStruct(method)
+	}
+	args, err := dynamic.DecodeStruct(request.Input, node)
+	if err != nil {
+		return err
+	}
+	if err := request.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+
+	call := &Call{
+		Method:     request.MethodName,
+		SequenceId: request.SequenceId,
+		Time:       time.Now(),
+		Args:       args,
+		Rule:       -1,
+	}
+	if method == nil {
+		this.record(call)
+		return request.SendException(thrift.UNKNOWN_METHOD, fmt.Sprintf("unknown method: %s", request.MethodName))
+	}
+
+	var rule *compiledRule
+	argsJSON := argsToJSON(args)
+	for _, candidate := range this.rules {
+		if candidate.matches(request.MethodName, argsJSON) {
+			rule = candidate
+			call.Rule = candidate.index
+			break
+		}
+	}
+	this.record(call)
+
+	if rule == nil {
+		return request.SendException(thrift.INTERNAL_ERROR, fmt.Sprintf("no rule matches call to %s", request.MethodName))
+	}
+	if rule.delay > 0 {
+		time.Sleep(rule.delay)
+	}
+	if request.MessageType == thrift.ONEWAY {
+		return nil
+	}
+	return dynamic.EncodeMessage(request.Output, request.MethodName, thrift.REPLY, request.SequenceId, rule.result[request.MethodName])
+}
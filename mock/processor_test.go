@@ -0,0 +1,178 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package mock
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const TestRules = `[
+	{"method": "getUser", "args": {"id": 1}, "result": {"id": 1, "name": "Ada", "role": "ADMIN"}},
+	{"method": "getUser", "args": {"id": {"$gte": 100, "$lt": 200}}, "delay": "50ms", "result": {"id": 100, "name": "Slow"}},
+	{"method": "getUser", "exception": {"notFound": {"message": "no such user"}}},
+	{"method": "findUsers", "args": {"prefix": {"$regex": "^A"}, "role": {"$in": ["ADMIN", "MEMBER"]}}, "result": [{"id": 1, "name": "Ada"}]},
+	{"method": "findUsers", "args": {"role": {"$exists": false}}, "result": []},
+	{"method": "deleteUser", "args": {"id": {"$ne": 1}}},
+	{"method": "touch"},
+	{"method": "ping", "result": true}
+]`
+
+var _ = Describe("Processor", func() {
+	var service *parser.ServiceNode
+	var processor *Processor
+	var client *TestClient
+
+	BeforeEach(func() {
+		service = ParseTestService()
+		rules, err := ParseRules([]byte(TestRules))
+		Expect(err).To(BeNil())
+		processor, err = NewProcessor(service, rules, &Options{})
+		Expect(err).To(BeNil())
+		client = NewTestClient(service, processor)
+	})
+
+	AfterEach(func() {
+		client.Close()
+	})
+
+	It("answers with canned results", func() {
+		result, err := client.Call("getUser", `{"id": 1}`)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(map[string]interface{}{
+			"success": map[string]interface{}{
+				"id":   json.Number("1"),
+				"name": "Ada",
+				"role": "ADMIN",
+			},
+		}))
+
+		// Inherited methods work too.
+		result, err = client.Call("ping", `{}`)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(map[string]interface{}{"success": true}))
+	})
+
+	It("throws declared exceptions", func() {
+		result, err := client.Call("getUser", `{"id": 2}`)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(map[string]interface{}{
+			"notFound": map[string]interface{}{"message": "no such user"},
+		}))
+	})
+
+	It("delays answers", func() {
+		start := time.Now()
+		result, err := client.Call("getUser", `{"id": 150}`)
+		Expect(err).To(BeNil())
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+		Expect(result.(map[string]interface{})["success"]).NotTo(BeNil())
+	})
+
+	It("matches argument predicates", func() {
+		result, err := client.Call("findUsers", `{"prefix": "Al", "role": "MEMBER"}`)
+		Expect(err).To(BeNil())
+		Expect(result.(map[string]interface{})["success"]).To(HaveLen(1))
+
+		// Unmatched calls get an application exception.
+		_, err = client.Call("findUsers", `{"prefix": "Bo", "role": "ADMIN"}`)
+		ExpectTestApplicationException(err, thrift.INTERNAL_ERROR)
+
+		// Void methods reply with an empty result.
+		result, err = client.Call("deleteUser", `{"id": 5}`)
+		Expect(err).To(BeNil())
+		Expect(result).To(BeEmpty())
+		_, err = client.Call("deleteUser", `{"id": 1}`)
+		ExpectTestApplicationException(err, thrift.INTERNAL_ERROR)
+	})
+
+	It("records every call", func() {
+		client.Call("getUser", `{"id": 1}`)
+		client.Call("touch", `{"id": 9}`)
+		client.Call("getUser", `{"id": 3}`)
+		client.Call("ping", `{}`)
+
+		Eventually(func() int { return len(processor.Calls()) }).Should(Equal(4))
+		calls := processor.CallsTo("getUser")
+		Expect(calls).To(HaveLen(2))
+		Expect(calls[0].Rule).To(Equal(0))
+		Expect(calls[0].Args.Field("id").Value).To(Equal(int64(1)))
+		Expect(calls[1].Rule).To(Equal(2))
+		Expect(calls[1].ArgsJSON()).To(Equal(map[string]interface{}{"id": json.Number("3")}))
+
+		touch := processor.CallsTo("touch")
+		Expect(touch).To(HaveLen(1))
+		Expect(touch[0].Args.Field("id").Value).To(Equal(int64(9)))
+
+		processor.Reset()
+		Expect(processor.Calls()).To(BeEmpty())
+	})
+
+	It("rejects unknown methods", func() {
+		// Give the client a method the processor does not know about.
+		other := ParseTestService()
+		other.Methods = append(other.Methods, &parser.ServiceMethod{
+			ReturnType: other.Methods[0].ReturnType,
+			Name:       &parser.Token{Kind: parser.TOK_IDENTIFIER, Data: "mystery"},
+			Args:       []*parser.ServiceMethodArg{},
+		})
+		client.service = other
+
+		_, err := client.Call("mystery", `{}`)
+		ExpectTestApplicationException(err, thrift.UNKNOWN_METHOD)
+		Expect(processor.CallsTo("mystery")).To(HaveLen(1))
+		Expect(processor.CallsTo("mystery")[0].Rule).To(Equal(-1))
+	})
+
+	It("checks rules against the schema", func() {
+		bad := []string{
+			`[{"method": "nope"}]`,
+			`[{"method": "getUser", "args": {"nope": 1}}]`,
+			`[{"method": "getUser", "result": {"id": "x", "name": "y"}}]`,
+			`[{"method": "getUser", "exception": {"nope": {}}}]`,
+			`[{"method": "deleteUser", "result": 1}]`,
+			`[{"method": "getUser", "args": {"id": {"$near": 1}}}]`,
+			`[{"method": "getUser", "delay": "soon"}]`,
+			`[{"method": "getUser", "color": "red"}]`,
+		}
+		for _, input := range bad {
+			rules, err := ParseRules([]byte(input))
+			if err == nil {
+				_, err = NewProcessor(service, rules, &Options{})
+			}
+			Expect(err).NotTo(BeNil(), input)
+		}
+	})
+
+	It("loads rules from files", func() {
+		dir, err := ioutil.TempDir("", "mock")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "rules.json")
+		Expect(ioutil.WriteFile(path, []byte(TestRules), 0644)).To(Succeed())
+		rules, err := LoadRules(path)
+		Expect(err).To(BeNil())
+		Expect(rules).To(HaveLen(8))
+		Expect(rules[1].Delay).To(Equal("50ms"))
+	})
+})
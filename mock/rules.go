@@ -0,0 +1,409 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A rule for answering calls. Rules are usually loaded from a JSON file:
+//
+//     [
+//       {"method": "getUser", "args": {"id": 1}, "result": {"id": 1, "name": "Ada"}},
+//       {"method": "getUser", "args": {"id": {"$gt": 100}}, "exception": {"notFound": {"message": "no"}}},
+//       {"method": "ping", "delay": "2s", "result": true}
+//     ]
+//
+// The first rule whose method and arguments match a call answers it.
+type Rule struct {
+	// The method to match. If empty, every method matches.
+	Method string `json:"method"`
+
+	// Argument predicates, keyed by argument name. Every predicate must match
+	// for the rule to apply. A predicate is either a value, which the argument
+	// must equal, or an object of operators:
+	//
+	//   $eq, $ne          equal, or not equal, to a value
+	//   $gt, $gte, $lt, $lte
+	//                     compare numbers or strings
+	//   $in               equal to one of an array of values
+	//   $regex            match a regular expression (strings only)
+	//   $exists           whether the argument is present at all
+	//
+	// Arguments are matched in the JSON form that dynamic.StructToJSON()
+	// produces, so enums are matched by name. Objects without operators match
+	// structs and maps whose members match, ignoring any extra members.
+	Args map[string]interface{} `json:"args"`
+
+	// The value to return. This must be omitted for void methods.
+	Result interface{} `json:"result"`
+
+	// A declared exception to throw instead, as an object with one member
+	// named after the exception in the method's throws clause.
+	Exception map[string]interface{} `json:"exception"`
+
+	// How long to wait before answering, for example "250ms".
+	Delay string `json:"delay"`
+}
+
+// A rule that has been checked against the schema.
+type compiledRule struct {
+	index  int
+	rule   *Rule
+	delay  time.Duration
+	result map[string]*dynamic.Struct
+}
+
+// Loads rules from a JSON file containing an array of rules.
+func LoadRules(path string) ([]*Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// Parses rules from a JSON array of rules.
+func ParseRules(data []byte) ([]*Rule, error) {
+	value, err := dynamic.ParseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rules are unpacked by hand, so that numbers stay json.Numbers.
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rules must be a JSON array")
+	}
+	rules := []*Rule{}
+	for i, elem := range array {
+		object, ok := elem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("rule %d: expected an object", i)
+		}
+
+		rule := &Rule{}
+		for key, value := range object {
+			switch key {
+			case "method":
+				rule.Method, ok = value.(string)
+			case "args":
+				rule.Args, ok = value.(map[string]interface{})
+			case "result":
+				rule.Result = value
+			case "exception":
+				rule.Exception, ok = value.(map[string]interface{})
+			case "delay":
+				rule.Delay, ok = value.(string)
+			default:
+				return nil, fmt.Errorf("rule %d: unknown key %s", i, key)
+			}
+			if !ok {
+				return nil, fmt.Errorf("rule %d: %s has the wrong type", i, key)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Checks a rule against a service, and converts its results to values that
+// can be encoded for each method it applies to.
+func compileRule(service *parser.ServiceNode, index int, rule *Rule) (*compiledRule, error) {
+	compiled := &compiledRule{
+		index:  index,
+		rule:   rule,
+		result: map[string]*dynamic.Struct{},
+	}
+	if rule.Delay != "" {
+		delay, err := time.ParseDuration(rule.Delay)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", index, err)
+		}
+		compiled.delay = delay
+	}
+	if rule.Result != nil && rule.Exception != nil {
+		return nil, fmt.Errorf("rule %d: cannot have both a result and an exception", index)
+	}
+	if rule.Exception != nil && len(rule.Exception) != 1 {
+		return nil, fmt.Errorf("rule %d: exception must name exactly one exception", index)
+	}
+
+	methods := []*parser.ServiceMethod{}
+	if rule.Method != "" {
+		method := dynamic.FindServiceMethod(service, rule.Method)
+		if method == nil {
+			return nil, fmt.Errorf("rule %d: service %s has no method %s", index, service.Name.Identifier(), rule.Method)
+		}
+		methods = append(methods, method)
+	} else {
+		for _, current := range service.InheritanceChain() {
+			methods = append(methods, current.Methods...)
+		}
+	}
+
+	for _, method := range methods {
+		name := method.Name.Identifier()
+		for arg, predicate := range rule.Args {
+			if dynamic.ArgsStruct(method).Names[arg] == nil {
+				return nil, fmt.Errorf("rule %d: method %s has no argument %s", index, name, arg)
+			}
+			if err := checkPredicate(predicate); err != nil {
+				return nil, fmt.Errorf("rule %d: %s: %v", index, arg, err)
+			}
+		}
+
+		var value map[string]interface{}
+		switch {
+		case rule.Exception != nil:
+			value = rule.Exception
+		case rule.Result != nil:
+			if method.ReturnsVoid() {
+				return nil, fmt.Errorf("rule %d: method %s returns void", index, name)
+			}
+			value = map[string]interface{}{"success": rule.Result}
+		default:
+			// Non-void methods reply with no result at all, which clients report
+			// as a missing result. This is occasionally what a test wants.
+			value = map[string]interface{}{}
+		}
+		result, err := dynamic.StructFromValue(dynamic.ResultStruct(method), value)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: result of %s: %v", index, name, err)
+		}
+		compiled.result[name] = result
+	}
+	return compiled, nil
+}
+
+// Returns whether the rule applies to a call, given the call's arguments as
+// parsed JSON.
+func (this *compiledRule) matches(method string, args map[string]interface{}) bool {
+	if this.rule.Method != "" && this.rule.Method != method {
+		return false
+	}
+	for name, predicate := range this.rule.Args {
+		value, present := args[name]
+		if !matchPredicate(predicate, value, present) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if an object consists only of operators.
+func isOperatorObject(object map[string]interface{}) bool {
+	if len(object) == 0 {
+		return false
+	}
+	for key, _ := range object {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that a predicate only uses known operators, with operands of the
+// right types.
+func checkPredicate(predicate interface{}) error {
+	object, ok := predicate.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if !isOperatorObject(object) {
+		for _, member := range object {
+			if err := checkPredicate(member); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for op, operand := range object {
+		var ok bool
+		switch op {
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			ok = true
+		case "$exists":
+			_, ok = operand.(bool)
+		case "$in":
+			_, ok = operand.([]interface{})
+		case "$regex":
+			var pattern string
+			if pattern, ok = operand.(string); ok {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unknown operator %s", op)
+		}
+		if !ok {
+			return fmt.Errorf("%s has the wrong type", op)
+		}
+	}
+	return nil
+}
+
+func matchPredicate(predicate interface{}, value interface{}, present bool) bool {
+	object, ok := predicate.(map[string]interface{})
+	if !ok || !isOperatorObject(object) {
+		return present && matchValue(predicate, value)
+	}
+
+	for op, operand := range object {
+		var ok bool
+		switch op {
+		case "$exists":
+			exists, _ := operand.(bool)
+			ok = exists == present
+		case "$eq":
+			ok = present && matchValue(operand, value)
+		case "$ne":
+			ok = !present || !matchValue(operand, value)
+		case "$gt", "$gte", "$lt", "$lte":
+			cmp, comparable := compare(value, operand)
+			if present && comparable {
+				switch op {
+				case "$gt":
+					ok = cmp > 0
+				case "$gte":
+					ok = cmp >= 0
+				case "$lt":
+					ok = cmp < 0
+				case "$lte":
+					ok = cmp <= 0
+				}
+			}
+		case "$in":
+			operands, _ := operand.([]interface{})
+			for _, operand := range operands {
+				if present && matchValue(operand, value) {
+					ok = true
+					break
+				}
+			}
+		case "$regex":
+			pattern, _ := operand.(string)
+			str, isString := value.(string)
+			if present && isString {
+				ok, _ = regexp.MatchString(pattern, str)
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Compares two JSON numbers or strings, returning false if they cannot be
+// compared.
+func compare(a interface{}, b interface{}) (int, bool) {
+	if a, ok := a.(string); ok {
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+		return 0, false
+	}
+
+	// Compare integers exactly, since ids often do not fit in a float64.
+	if a, ok := a.(json.Number); ok {
+		if b, ok := b.(json.Number); ok {
+			x, errA := a.Int64()
+			y, errB := b.Int64()
+			if errA == nil && errB == nil {
+				switch {
+				case x < y:
+					return -1, true
+				case x > y:
+					return 1, true
+				}
+				return 0, true
+			}
+		}
+	}
+
+	x, ok := toFloat(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := toFloat(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch value.(type) {
+	case json.Number:
+		number, err := value.(json.Number).Float64()
+		return number, err == nil
+	case float64:
+		return value.(float64), true
+	}
+	return 0, false
+}
+
+// Returns whether a value matches an expected value. Objects match if every
+// expected member matches; everything else must be equal.
+func matchValue(expected interface{}, value interface{}) bool {
+	switch expected.(type) {
+	case map[string]interface{}:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, expected := range expected.(map[string]interface{}) {
+			member, present := object[key]
+			if !matchPredicate(expected, member, present) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		expected := expected.([]interface{})
+		array, ok := value.([]interface{})
+		if !ok || len(array) != len(expected) {
+			return false
+		}
+		for i, _ := range expected {
+			if !matchValue(expected[i], array[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number, float64:
+		cmp, ok := compare(value, expected)
+		return ok && cmp == 0
+	}
+	return reflect.DeepEqual(expected, value)
+}
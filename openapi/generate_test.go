@@ -0,0 +1,131 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package openapi
+
+import (
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Generate", func() {
+	It("describes services and their base services", func() {
+		document := GenerateTestDocument(&Options{})
+		Expect(document["openapi"]).To(Equal("3.1.0"))
+		Expect(document["info"]).To(Equal(sematest.ParseJSON(`{"title": "Places", "version": "1.0.0"}`)))
+		Expect(document["tags"]).To(Equal(sematest.ParseJSON(`[
+			{"name": "Places", "description": "Looks up places.\n\nPlaces are found by location."}
+		]`)))
+
+		paths := []string{}
+		for path, _ := range document["paths"].(map[string]interface{}) {
+			paths = append(paths, path)
+		}
+		Expect(paths).To(ConsistOf("/Places/find", "/Places/visit", "/Places/ping", "/Places/reset"))
+
+		ping := Lookup(document, "paths", "/Places/ping", "post")
+		Expect(Lookup(ping, "operationId")).To(Equal("Places.ping"))
+		Expect(Lookup(ping, "description")).To(Equal("Checks that the service is up."))
+		Expect(Lookup(ping, "responses", "200", "content", "application/json", "schema")).To(Equal(sematest.ParseJSON(`{"type": "boolean"}`)))
+	})
+
+	It("describes arguments and results", func() {
+		document := GenerateTestDocument(&Options{})
+		find := Lookup(document, "paths", "/Places/find", "post")
+		Expect(Lookup(find, "description")).To(Equal("Finds the place at a point."))
+		Expect(Lookup(find, "tags")).To(Equal(sematest.ParseJSON(`["Places"]`)))
+		Expect(Lookup(find, "requestBody")).To(Equal(sematest.ParseJSON(`{
+			"required": true,
+			"content": {"application/json": {"schema": {
+				"type": "object",
+				"properties": {
+					"at": {"$ref": "#/components/schemas/common.Point", "description": "Where to look."},
+					"radius": {"type": "integer", "minimum": -2147483648, "maximum": 2147483647}
+				},
+				"required": ["at", "radius"],
+				"additionalProperties": false
+			}}}
+		}`)))
+		Expect(Lookup(find, "responses", "200", "content", "application/json", "schema")).To(Equal(sematest.ParseJSON(`{"type": "string"}`)))
+
+		visit := Lookup(document, "paths", "/Places/visit", "post")
+		Expect(Lookup(visit, "description")).To(BeNil())
+		Expect(Lookup(visit, "responses")).To(HaveKey("202"))
+	})
+
+	It("describes exceptions as error responses", func() {
+		document := GenerateTestDocument(&Options{})
+		Expect(Lookup(document, "paths", "/Places/find", "post", "responses", "default")).To(Equal(sematest.ParseJSON(`{
+			"description": "Throws notFound (NotFound) or denied (common.Denied).",
+			"content": {"application/json": {"schema": {"oneOf": [
+				{
+					"type": "object",
+					"properties": {"notFound": {"$ref": "#/components/schemas/NotFound"}},
+					"required": ["notFound"],
+					"additionalProperties": false
+				},
+				{
+					"type": "object",
+					"properties": {"denied": {"$ref": "#/components/schemas/common.Denied"}},
+					"required": ["denied"],
+					"additionalProperties": false
+				}
+			]}}}
+		}`)))
+
+		document = GenerateTestDocument(&Options{
+			ErrorStatus: func(method *parser.ServiceMethod, exception *parser.ServiceMethodArg) string {
+				if exception.Name.Identifier() == "notFound" {
+					return "404"
+				}
+				return "403"
+			},
+		})
+		responses := Lookup(document, "paths", "/Places/find", "post", "responses").(map[string]interface{})
+		Expect(responses).To(HaveKey("404"))
+		Expect(responses).To(HaveKey("403"))
+		Expect(responses).NotTo(HaveKey("default"))
+		Expect(Lookup(responses, "404", "content", "application/json", "schema", "required")).To(Equal(sematest.ParseJSON(`["notFound"]`)))
+	})
+
+	It("includes definitions from every file", func() {
+		document := GenerateTestDocument(&Options{})
+		schemas := Lookup(document, "components", "schemas").(map[string]interface{})
+		Expect(schemas).To(HaveLen(3))
+		Expect(schemas).To(HaveKey("NotFound"))
+		Expect(schemas).To(HaveKey("common.Denied"))
+		Expect(Lookup(schemas, "common.Point", "description")).To(Equal("A point on the grid."))
+	})
+
+	It("selects services and paths", func() {
+		document := GenerateTestDocument(&Options{
+			Title:    "Health",
+			Version:  "2.1",
+			Services: []string{"common.Base"},
+			Path: func(service *parser.ServiceNode, method *parser.ServiceMethod) string {
+				return "/rpc/" + method.Name.Identifier()
+			},
+		})
+		Expect(document["info"]).To(Equal(sematest.ParseJSON(`{"title": "Health", "version": "2.1"}`)))
+		Expect(document["paths"]).To(HaveLen(2))
+		Expect(Lookup(document, "paths", "/rpc/reset", "post", "responses")).To(Equal(sematest.ParseJSON(`{
+			"200": {"description": "Success."}
+		}`)))
+
+		_, err := Generate(ParseTestTree(), &Options{Services: []string{"Nope"}})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(Equal("unknown name: Nope"))
+	})
+})
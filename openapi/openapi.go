@@ -0,0 +1,298 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// Generates OpenAPI descriptions of Thrift services, for services exposed
+// through an HTTP gateway.
+package openapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/jsonschema"
+	"github.com/edmodo/frugal/parser"
+)
+
+// The OpenAPI version of generated documents. Schemas are JSON Schema draft
+// 2020-12, which OpenAPI 3.1 uses.
+const Version = "3.1.0"
+
+// An OpenAPI document, or part of one, ready to be passed to json.Marshal().
+type Document map[string]interface{}
+
+// Options for Generate(). Zero values select the defaults.
+type Options struct {
+	// The title and version of the API. The default title lists the services,
+	// and the default version is "1.0.0".
+	Title   string
+	Version string
+
+	// Names of the services to describe, as "Service" or "package.Service".
+	// The default is every service in the tree, but not in its includes.
+	Services []string
+
+	// Returns the path of a method. The default is "/Service/method".
+	Path func(service *parser.ServiceNode, method *parser.ServiceMethod) string
+
+	// Returns the response status, such as "404", for an exception a method
+	// throws. Exceptions with the same status share a response. The default
+	// is "default", meaning any status without its own response.
+	ErrorStatus func(method *parser.ServiceMethod, exception *parser.ServiceMethodArg) string
+}
+
+type generator struct {
+	root    *parser.ParseTree
+	options Options
+
+	// The tree each service was defined in.
+	trees map[*parser.ServiceNode]*parser.ParseTree
+
+	// A schema builder for each tree.
+	builders map[*parser.ParseTree]*jsonschema.Builder
+}
+
+// Generates an OpenAPI document for services in an analyzed parse tree.
+//
+// Each method, including those inherited from base services, is a POST
+// operation. Its request body is a JSON object of its arguments, keyed by
+// name, and its successful response is its return value. Each exception it
+// throws is an error response, with a body of {"name": exception}, where name
+// is the name of the exception in the throws clause. All values are in the
+// JSON form used by the dynamic package.
+//
+// Structs, enums, and typedefs go in "components/schemas". Definitions from
+// included files are prefixed with their package name, as in "common.Point".
+// Doc comments become descriptions.
+func Generate(tree *parser.ParseTree, options *Options) (Document, error) {
+	gen := &generator{
+		root:     tree,
+		options:  *options,
+		trees:    map[*parser.ServiceNode]*parser.ParseTree{},
+		builders: map[*parser.ParseTree]*jsonschema.Builder{},
+	}
+	if gen.options.Version == "" {
+		gen.options.Version = "1.0.0"
+	}
+	if gen.options.Path == nil {
+		gen.options.Path = func(service *parser.ServiceNode, method *parser.ServiceMethod) string {
+			return "/" + service.Name.Identifier() + "/" + method.Name.Identifier()
+		}
+	}
+	if gen.options.ErrorStatus == nil {
+		gen.options.ErrorStatus = func(method *parser.ServiceMethod, exception *parser.ServiceMethodArg) string {
+			return "default"
+		}
+	}
+
+	schemas := jsonschema.Schema{}
+	for _, file := range parser.FlattenTrees(tree) {
+		builder := jsonschema.NewBuilder(file, gen.ref)
+		gen.builders[file] = builder
+		for name, schema := range builder.Definitions() {
+			schemas[gen.componentName(file, name)] = schema
+		}
+		for _, node := range file.Nodes {
+			if service, ok := node.(*parser.ServiceNode); ok {
+				gen.trees[service] = file
+			}
+		}
+	}
+
+	services, err := gen.services()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	tags := []Document{}
+	paths := Document{}
+	for _, service := range services {
+		name := service.Name.Identifier()
+		names = append(names, name)
+
+		tag := Document{"name": name}
+		if service.Doc != "" {
+			tag["description"] = service.Doc
+		}
+		tags = append(tags, tag)
+
+		for _, method := range flattenMethods(service) {
+			paths[gen.options.Path(service, method)] = Document{
+				"post": gen.operation(service, method),
+			}
+		}
+	}
+
+	title := gen.options.Title
+	if title == "" {
+		title = strings.Join(names, ", ")
+	}
+
+	return Document{
+		"openapi": Version,
+		"info": Document{
+			"title":   title,
+			"version": gen.options.Version,
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": Document{
+			"schemas": schemas,
+		},
+	}, nil
+}
+
+func (this *generator) services() ([]*parser.ServiceNode, error) {
+	services := []*parser.ServiceNode{}
+	if len(this.options.Services) == 0 {
+		for _, node := range this.root.Nodes {
+			if service, ok := node.(*parser.ServiceNode); ok {
+				services = append(services, service)
+			}
+		}
+		return services, nil
+	}
+
+	for _, name := range this.options.Services {
+		service, err := dynamic.FindService(this.root, name)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// Definitions in the root tree keep their names, and others are prefixed
+// with their package.
+func (this *generator) componentName(tree *parser.ParseTree, name string) string {
+	if tree == this.root {
+		return name
+	}
+	return tree.Package + "." + name
+}
+
+func (this *generator) ref(tree *parser.ParseTree, name string) string {
+	return "#/components/schemas/" + this.componentName(tree, name)
+}
+
+// Returns the methods of a service and its base services. Methods of derived
+// services come first, and hide methods with the same name in their bases.
+func flattenMethods(service *parser.ServiceNode) []*parser.ServiceMethod {
+	methods := []*parser.ServiceMethod{}
+	seen := map[string]bool{}
+	for _, current := range service.InheritanceChain() {
+		for _, method := range current.Methods {
+			if seen[method.Name.Identifier()] {
+				continue
+			}
+			seen[method.Name.Identifier()] = true
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Returns the service that declares a method, from the inheritance chain of
+// the service being described.
+func declaringService(service *parser.ServiceNode, method *parser.ServiceMethod) *parser.ServiceNode {
+	for _, current := range service.InheritanceChain() {
+		for _, other := range current.Methods {
+			if other == method {
+				return current
+			}
+		}
+	}
+	return service
+}
+
+func (this *generator) operation(service *parser.ServiceNode, method *parser.ServiceMethod) Document {
+	// Types in the method are named relative to the file it is declared in.
+	builder := this.builders[this.trees[declaringService(service, method)]]
+
+	operation := Document{
+		"operationId": service.Name.Identifier() + "." + method.Name.Identifier(),
+		"tags":        []string{service.Name.Identifier()},
+		"requestBody": Document{
+			"required": true,
+			"content":  jsonContent(builder.Struct(dynamic.ArgsStruct(method))),
+		},
+		"responses": this.responses(builder, method),
+	}
+	if method.Doc != "" {
+		operation["description"] = method.Doc
+	}
+	return operation
+}
+
+func jsonContent(schema jsonschema.Schema) Document {
+	return Document{
+		"application/json": Document{
+			"schema": schema,
+		},
+	}
+}
+
+func (this *generator) responses(builder *jsonschema.Builder, method *parser.ServiceMethod) Document {
+	responses := Document{}
+	switch {
+	case method.OneWay != nil:
+		responses["202"] = Document{"description": "Accepted; oneway methods have no reply."}
+	case method.ReturnsVoid():
+		responses["200"] = Document{"description": "Success."}
+	default:
+		responses["200"] = Document{
+			"description": fmt.Sprintf("Returns %s.", method.ReturnType.String()),
+			"content":     jsonContent(builder.Type(method.ReturnType)),
+		}
+	}
+
+	// Group exceptions by status, keeping the order they are declared in.
+	statuses := []string{}
+	byStatus := map[string][]*parser.ServiceMethodArg{}
+	for _, throws := range method.Throws {
+		status := this.options.ErrorStatus(method, throws)
+		if _, ok := byStatus[status]; !ok {
+			statuses = append(statuses, status)
+		}
+		byStatus[status] = append(byStatus[status], throws)
+	}
+
+	for _, status := range statuses {
+		descriptions := []string{}
+		schemas := []jsonschema.Schema{}
+		for _, throws := range byStatus[status] {
+			name := throws.Name.Identifier()
+			descriptions = append(descriptions, fmt.Sprintf("%s (%s)", name, throws.Type.String()))
+			schemas = append(schemas, jsonschema.Schema{
+				"type": "object",
+				"properties": jsonschema.Schema{
+					name: builder.Type(throws.Type),
+				},
+				"required":             []string{name},
+				"additionalProperties": false,
+			})
+		}
+
+		schema := schemas[0]
+		if len(schemas) > 1 {
+			schema = jsonschema.Schema{"oneOf": schemas}
+		}
+		responses[status] = Document{
+			"description": "Throws " + strings.Join(descriptions, " or ") + ".",
+			"content":     jsonContent(schema),
+		}
+	}
+	return responses
+}
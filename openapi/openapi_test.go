@@ -0,0 +1,103 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OpenAPI testing")
+}
+
+const TestCommonSchema = `
+/** A point on the grid. */
+struct Point {
+	1: required i32 x
+	2: required i32 y
+}
+
+exception Denied {
+	1: string reason
+}
+
+/** Health checks. */
+service Base {
+	/** Checks that the service is up. */
+	bool ping()
+	void reset()
+}
+`
+
+const TestSchema = `
+include "common.thrift"
+
+exception NotFound {
+	1: required string message
+}
+
+/**
+ * Looks up places.
+ *
+ * Places are found by location.
+ */
+service Places extends common.Base {
+	/** Finds the place at a point. */
+	string find(
+		/** Where to look. */
+		1: common.Point at,
+		2: i32 radius
+	) throws (1: NotFound notFound, 2: common.Denied denied)
+
+	// Not a doc comment.
+	oneway void visit(1: common.Point at)
+}
+`
+
+// Returns the analyzed tree for the test schema.
+func ParseTestTree() *parser.ParseTree {
+	return sematest.Compile(map[string]string{
+		"test.thrift":   TestSchema,
+		"common.thrift": TestCommonSchema,
+	})
+}
+
+// Generates a document for the test schema, and returns it as parsed JSON.
+func GenerateTestDocument(options *Options) map[string]interface{} {
+	document, err := Generate(ParseTestTree(), options)
+	Expect(err).To(BeNil())
+	data, err := json.Marshal(document)
+	Expect(err).To(BeNil())
+
+	var result map[string]interface{}
+	Expect(json.Unmarshal(data, &result)).To(Succeed())
+	return result
+}
+
+// Returns the value at a path of object keys.
+func Lookup(value interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		object, ok := value.(map[string]interface{})
+		Expect(ok).To(BeTrue(), "expected an object at %s", key)
+		value = object[key]
+	}
+	return value
+}
@@ -106,6 +106,9 @@ type EnumNode struct {
 
 	// Map from name -> Entry. Filled in by semantic analysis.
 	Names map[string]*EnumEntry
+
+	// The doc comment before the enum, if any.
+	Doc string
 }
 
 func NewEnumNode(loc Location, name *Token, fields []*EnumEntry) *EnumNode {
@@ -125,6 +128,31 @@ func (this *EnumNode) NodeType() string {
 	return "enum"
 }
 
+// An annotation on a field or argument, for example:
+//
+//     1: string name (min_length = 1, regex = "^[a-z]+$", deprecated)
+//
+// Annotations are not interpreted by the parser or by semantic analysis.
+type Annotation struct {
+	// The annotation name. Dotted names are joined, as in "go.tag".
+	Name string
+	Loc  Location
+
+	// The value, as a TOK_LITERAL_STRING or TOK_LITERAL_INT, or nil if the
+	// annotation has no value.
+	Value *Token
+}
+
+// Returns the annotation with the given name, or nil if there is none.
+func FindAnnotation(annotations []*Annotation, name string) *Annotation {
+	for _, annotation := range annotations {
+		if annotation.Name == name {
+			return annotation
+		}
+	}
+	return nil
+}
+
 type StructField struct {
 	// The token which contains the order number, or nil if not present.
 	Order *Token
@@ -141,6 +169,12 @@ type StructField struct {
 	// The default value, or nil if not present. After semantic analysis, this is
 	// converted to a ValueNode.
 	Default Node
+
+	// Annotations following the field, in the order they appear.
+	Annotations []*Annotation
+
+	// The doc comment before the field, if any.
+	Doc string
 }
 
 // Encapsulates struct definition.
@@ -156,6 +190,9 @@ type StructNode struct {
 
 	// Map from name -> StructField. Filled in by semantic analysis.
 	Names map[string]*StructField
+
+	// The doc comment before the struct, if any.
+	Doc string
 }
 
 func NewStructNode(loc Location, kind *Token, name *Token, fields []*StructField) *StructNode {
@@ -206,6 +243,7 @@ func (this *LiteralNode) TypeString() string {
 
 // A sequence of expressions.
 type ListNode struct {
+	Range Location
 	Exprs []Node
 
 	// After semantic analysis, this contains the resolved values for each
@@ -214,10 +252,7 @@ type ListNode struct {
 }
 
 func (this *ListNode) Loc() Location {
-	return Location{
-		Start: this.Exprs[0].Loc().Start,
-		End:   this.Exprs[len(this.Exprs)-1].Loc().End,
-	}
+	return this.Range
 }
 
 func (this *ListNode) NodeType() string {
@@ -312,6 +347,12 @@ type ServiceMethodArg struct {
 
 	// The token containing the argument name.
 	Name *Token
+
+	// Annotations following the argument, in the order they appear.
+	Annotations []*Annotation
+
+	// The doc comment before the argument, if any.
+	Doc string
 }
 
 type ServiceMethod struct {
@@ -329,6 +370,9 @@ type ServiceMethod struct {
 
 	// The list of throwable errors of the method.
 	Throws []*ServiceMethodArg
+
+	// The doc comment before the method, if any.
+	Doc string
 }
 
 // Returns whether or not a method has no return value. Should only be called
@@ -349,6 +393,9 @@ type ServiceNode struct {
 	Name    *Token
 	Extends *NameProxyNode
 	Methods []*ServiceMethod
+
+	// The doc comment before the service, if any.
+	Doc string
 }
 
 func (this *ServiceNode) Loc() Location {
@@ -411,6 +458,9 @@ type TypedefNode struct {
 	Range Location
 	Type  Type
 	Name  *Token
+
+	// The doc comment before the typedef, if any.
+	Doc string
 }
 
 func (this *TypedefNode) Loc() Location {
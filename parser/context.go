@@ -15,6 +15,7 @@ package parser
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -25,6 +26,10 @@ type CompileError struct {
 	Message string
 }
 
+func (this *CompileError) Error() string {
+	return fmt.Sprintf("%s (line %d, col %d): %s", this.File, this.Pos.Line, this.Pos.Col, this.Message)
+}
+
 type CompileContext struct {
 	// Current file being operated on, if any.
 	CurFile string
@@ -33,12 +38,31 @@ type CompileContext struct {
 	Errors []*CompileError
 
 	Packages map[string]*ParseTree
+
+	// Reads the contents of a file. If nil, files are read from disk. This
+	// lets files be parsed from memory, for example when fuzzing.
+	ReadFile func(path string) ([]byte, error)
 }
 
 func NewCompileContext() *CompileContext {
 	return &CompileContext{}
 }
 
+// Returns a context that reads files from memory instead of from disk. Files
+// are keyed by path, as given to ParseRecursive() or reached through includes;
+// for example, "test.thrift" and the "common.thrift" it includes.
+func NewMemoryCompileContext(files map[string]string) *CompileContext {
+	context := NewCompileContext()
+	context.ReadFile = func(path string) ([]byte, error) {
+		contents, ok := files[filepath.Clean(path)]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return []byte(contents), nil
+	}
+	return context
+}
+
 // Return the folder and filename. The filename has ".thrift" stripped.
 func (this *CompileContext) splitPath(file string) (string, string) {
 	folder, name := filepath.Split(file)
@@ -140,7 +164,7 @@ func (this *CompileContext) ReportError(pos Position, str string, args ...interf
 
 func (this *CompileContext) PrintErrors() {
 	for _, err := range this.Errors {
-		fmt.Printf("%s (line %d, col %d): %s\n", err.File, err.Pos.Line, err.Pos.Col, err.Message)
+		fmt.Println(err.Error())
 	}
 }
 
@@ -0,0 +1,73 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package parser
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMemoryCompileContext(t *testing.T) {
+	context := NewMemoryCompileContext(map[string]string{
+		"test.thrift":        "include \"common.thrift\"\nstruct User {\n\t1: common.Id id\n}\n",
+		"common.thrift":      "typedef i64 Id\n",
+		"unused/test.thrift": "this is not thrift",
+	})
+	tree := context.ParseRecursive("test.thrift")
+	if tree == nil || context.HasErrors() {
+		t.Fatalf("expected test.thrift to parse, got %v", context.Errors)
+	}
+	if tree.Package != "test" || len(tree.Nodes) != 1 {
+		t.Fatalf("expected one node in package test, got %d in %q", len(tree.Nodes), tree.Package)
+	}
+	include, ok := tree.Includes["common"]
+	if !ok || include.Tree == nil || include.Tree.Package != "common" {
+		t.Fatalf("expected common.thrift to be parsed as an include")
+	}
+}
+
+func TestMemoryCompileContextMissingFile(t *testing.T) {
+	context := NewMemoryCompileContext(map[string]string{
+		"test.thrift": "include \"common.thrift\"\n",
+	})
+	if tree := context.ParseRecursive("test.thrift"); tree != nil {
+		t.Fatalf("expected a missing include to fail")
+	}
+	if len(context.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", context.Errors)
+	}
+
+	_, err := context.ReadFile("common.thrift")
+	if !os.IsNotExist(err) {
+		t.Fatalf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestCompileErrorString(t *testing.T) {
+	context := NewMemoryCompileContext(map[string]string{
+		"test.thrift": "struct User {\n\t1: i64\n}\n",
+	})
+	if tree := context.ParseRecursive("test.thrift"); tree != nil {
+		t.Fatalf("expected a field without a name to fail")
+	}
+	if len(context.Errors) == 0 {
+		t.Fatalf("expected an error")
+	}
+
+	err := context.Errors[0]
+	expected := "test.thrift (line 3, col 1): expected <identifier>, but got }"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}
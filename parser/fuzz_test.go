@@ -0,0 +1,87 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Adds every file in testdata to the seed corpus.
+func addSeedCorpus(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.thrift"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+// Returns a context where "fuzz.thrift" holds the input, and other files
+// (such as includes) come from testdata.
+func newFuzzContext(data []byte) *CompileContext {
+	context := NewCompileContext()
+	context.ReadFile = func(path string) ([]byte, error) {
+		name := filepath.Base(path)
+		if name == "fuzz.thrift" {
+			return data, nil
+		}
+		return ioutil.ReadFile(filepath.Join("testdata", name))
+	}
+	return context
+}
+
+func FuzzScan(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		context := newFuzzContext(data)
+		context.Enter("fuzz.thrift")
+		defer context.Leave()
+
+		scanner, err := NewScanner(context)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Every token but EOF consumes at least one byte.
+		for count := 0; ; count++ {
+			if count > len(data) {
+				t.Fatalf("scanner did not reach end-of-file")
+			}
+			tok := scanner.next()
+			if tok.Kind == TOK_EOF {
+				break
+			}
+			if tok.Kind == TOK_ERROR && !context.HasErrors() {
+				t.Fatalf("scanner returned an error token without reporting an error")
+			}
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addSeedCorpus(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		context := newFuzzContext(data)
+		if tree := context.ParseRecursive("fuzz.thrift"); tree == nil && !context.HasErrors() {
+			t.Fatalf("parsing failed without reporting an error")
+		}
+	})
+}
@@ -48,6 +48,15 @@ func (this *Parser) need(kind TokenKind) *Token {
 	return tok
 }
 
+// Returns the doc comment before the next token, without consuming it. This
+// is often called after a failed match(), which has already undone a token;
+// next() hands that token back first, so the undo here is safe.
+func (this *Parser) peekDoc() string {
+	tok := this.scanner.next()
+	this.scanner.undo()
+	return tok.Doc
+}
+
 func (this *Parser) requireTerminator() {
 	// Currently, thrift has no concept of terminators. It allows, optionally,
 	// ',' or ';'. We should consider deviating from the official grammar and
@@ -144,6 +153,7 @@ func (this *Parser) parseExpr() Node {
 
 	// Parse a list of expressions.
 	case TOK_LBRACKET:
+		start := tok.Loc.Start
 		exprs := []Node{}
 		for this.match(TOK_RBRACKET) == nil {
 			expr := this.parseExpr()
@@ -154,7 +164,14 @@ func (this *Parser) parseExpr() Node {
 
 			this.requireTerminator()
 		}
-		return &ListNode{exprs, nil}
+		return &ListNode{
+			Location{
+				Start: start,
+				End:   this.scanner.Position(),
+			},
+			exprs,
+			nil,
+		}
 
 	// Parse a list of key-value pairs.
 	case TOK_LBRACE:
@@ -228,7 +245,7 @@ func (this *Parser) parseEnum(start *Token) *EnumNode {
 		this.requireTerminator()
 	}
 
-	return NewEnumNode(
+	node := NewEnumNode(
 		Location{
 			Start: start.Loc.Start,
 			End:   this.scanner.Position(),
@@ -236,6 +253,8 @@ func (this *Parser) parseEnum(start *Token) *EnumNode {
 		name,
 		entries,
 	)
+	node.Doc = start.Doc
+	return node
 }
 
 // Parse the rest of a fully-qualified name.
@@ -331,7 +350,7 @@ func (this *Parser) parseType() Type {
 // Parse the following:
 //   struct              ::= "struct" identifier "{" struct-body "}"
 //   struct-body         ::= (struct-member ","?)*
-//   struct-member       ::= struct-member-order? struct-member-spec? type identifier ("=" expression)?
+//   struct-member       ::= struct-member-order? struct-member-spec? type identifier ("=" expression)? annotations?
 //   struct-member-order ::= integer-literal ":"
 //   struct-member-spec  ::= "required" | "optional"
 //
@@ -346,6 +365,7 @@ func (this *Parser) parseStruct(start *Token) *StructNode {
 
 	fields := []*StructField{}
 	for this.match(TOK_RBRACE) == nil {
+		doc := this.peekDoc()
 		order := this.match(TOK_LITERAL_INT)
 		if order != nil {
 			if this.need(TOK_COLON) == nil {
@@ -375,18 +395,25 @@ func (this *Parser) parseStruct(start *Token) *StructNode {
 			}
 		}
 
+		annotations, ok := this.parseAnnotations()
+		if !ok {
+			return nil
+		}
+
 		fields = append(fields, &StructField{
-			Order:   order,
-			Spec:    spec,
-			Type:    ttype,
-			Name:    name,
-			Default: expr,
+			Order:       order,
+			Spec:        spec,
+			Type:        ttype,
+			Name:        name,
+			Default:     expr,
+			Annotations: annotations,
+			Doc:         doc,
 		})
 
 		this.requireTerminator()
 	}
 
-	return NewStructNode(
+	node := NewStructNode(
 		Location{
 			Start: start.Loc.Start,
 			End:   this.scanner.Position(),
@@ -395,10 +422,52 @@ func (this *Parser) parseStruct(start *Token) *StructNode {
 		name,
 		fields,
 	)
+	node.Doc = start.Doc
+	return node
+}
+
+// Parse:
+//   annotations ::= "(" (annotation ","?)* ")"
+//   annotation  ::= name-path ("=" (string-literal | integer-literal))?
+//
+// Returns nil if there are no annotations, and false on error.
+func (this *Parser) parseAnnotations() ([]*Annotation, bool) {
+	if this.match(TOK_LPAREN) == nil {
+		return nil, true
+	}
+
+	annotations := []*Annotation{}
+	for this.match(TOK_RPAREN) == nil {
+		first := this.need(TOK_IDENTIFIER)
+		if first == nil {
+			return nil, false
+		}
+		path := this.parseNames(first)
+		if path == nil {
+			return nil, false
+		}
+
+		annotation := &Annotation{
+			Name: JoinIdentifiers(path),
+			Loc:  first.Loc,
+		}
+		if this.match(TOK_ASSIGN) != nil {
+			value := this.scanner.next()
+			if value.Kind != TOK_LITERAL_STRING && value.Kind != TOK_LITERAL_INT {
+				this.Context.ReportError(value.Loc.Start, "expected annotation value, but got %s", value.String())
+				return nil, false
+			}
+			annotation.Value = value
+		}
+		annotations = append(annotations, annotation)
+
+		this.requireTerminator()
+	}
+	return annotations, true
 }
 
 // Parse:
-//   service-method-arg ::= (integer-literal ":")? type identifier ","?
+//   service-method-arg ::= (integer-literal ":")? type identifier annotations? ","?
 func (this *Parser) parseArgs() []*ServiceMethodArg {
 	if this.need(TOK_LPAREN) == nil {
 		return nil
@@ -406,6 +475,7 @@ func (this *Parser) parseArgs() []*ServiceMethodArg {
 
 	args := []*ServiceMethodArg{}
 	for this.match(TOK_RPAREN) == nil {
+		doc := this.peekDoc()
 		order := this.match(TOK_LITERAL_INT)
 		if order != nil {
 			if this.need(TOK_COLON) == nil {
@@ -423,10 +493,17 @@ func (this *Parser) parseArgs() []*ServiceMethodArg {
 			return nil
 		}
 
+		annotations, ok := this.parseAnnotations()
+		if !ok {
+			return nil
+		}
+
 		args = append(args, &ServiceMethodArg{
-			Order: order,
-			Type:  ttype,
-			Name:  name,
+			Order:       order,
+			Type:        ttype,
+			Name:        name,
+			Annotations: annotations,
+			Doc:         doc,
 		})
 
 		this.requireTerminator()
@@ -459,6 +536,7 @@ func (this *Parser) parseService(start *Token) *ServiceNode {
 
 	methods := []*ServiceMethod{}
 	for this.match(TOK_RBRACE) == nil {
+		doc := this.peekDoc()
 		oneway := this.match(TOK_ONEWAY)
 
 		ttype := this.parseType()
@@ -489,6 +567,7 @@ func (this *Parser) parseService(start *Token) *ServiceNode {
 			Name:       name,
 			Args:       args,
 			Throws:     throws,
+			Doc:        doc,
 		}
 		methods = append(methods, method)
 	}
@@ -501,6 +580,7 @@ func (this *Parser) parseService(start *Token) *ServiceNode {
 		Name:    name,
 		Extends: extends,
 		Methods: methods,
+		Doc:     start.Doc,
 	}
 }
 
@@ -522,6 +602,7 @@ func (this *Parser) parseTypedef(start *Token) *TypedefNode {
 		},
 		Type: ttype,
 		Name: name,
+		Doc:  start.Doc,
 	}
 }
 
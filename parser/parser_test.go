@@ -0,0 +1,95 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package parser
+
+import (
+	"testing"
+)
+
+func parseTestSchema(t *testing.T, schema string) *ParseTree {
+	context := NewMemoryCompileContext(map[string]string{"test.thrift": schema})
+	tree := context.ParseRecursive("test.thrift")
+	if tree == nil || context.HasErrors() {
+		t.Fatalf("expected schema to parse, got %v", context.Errors)
+	}
+	return tree
+}
+
+// Field, argument, and method loops call match() for the closing brace, then
+// peekDoc(), then match() again, each undoing a token.
+func TestPeekDocAfterFailedMatch(t *testing.T) {
+	tree := parseTestSchema(t, `
+struct User {
+	/** The id. */
+	1: i64 id
+	/** The name. */
+	string name
+}
+
+service Users {
+	/** Touches a user. */
+	oneway void touch(
+		/** Who to touch. */
+		1: i64 id,
+		/** Why. */
+		string reason
+	)
+	/** Finds a user. */
+	User find()
+}
+`)
+
+	user := tree.Nodes[0].(*StructNode)
+	docs := []string{user.Fields[0].Doc, user.Fields[1].Doc}
+	expectTestDocs(t, docs, "The id.", "The name.")
+
+	service := tree.Nodes[1].(*ServiceNode)
+	docs = []string{service.Methods[0].Doc, service.Methods[1].Doc}
+	expectTestDocs(t, docs, "Touches a user.", "Finds a user.")
+
+	args := service.Methods[0].Args
+	docs = []string{args[0].Doc, args[1].Doc}
+	expectTestDocs(t, docs, "Who to touch.", "Why.")
+}
+
+func TestScannerUndoAfterNext(t *testing.T) {
+	context := NewMemoryCompileContext(map[string]string{"test.thrift": "struct User"})
+	context.Enter("test.thrift")
+	defer context.Leave()
+	scanner, err := NewScanner(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := scanner.next()
+	scanner.undo()
+	if tok := scanner.next(); tok != first {
+		t.Fatalf("expected the undone token back, got %s", tok.String())
+	}
+	scanner.undo()
+	if tok := scanner.next(); tok != first {
+		t.Fatalf("expected the undone token back again, got %s", tok.String())
+	}
+	if tok := scanner.next(); tok.Kind != TOK_IDENTIFIER {
+		t.Fatalf("expected an identifier, got %s", tok.String())
+	}
+}
+
+func expectTestDocs(t *testing.T, docs []string, expected ...string) {
+	for i, doc := range docs {
+		if doc != expected[i] {
+			t.Errorf("expected doc %q, got %q", expected[i], doc)
+		}
+	}
+}
@@ -16,6 +16,7 @@ package parser
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 type AstPrinter struct {
@@ -48,10 +49,29 @@ func (this *AstPrinter) printArg(arg *ServiceMethodArg) {
 	}
 	msg += fmt.Sprintf("%s ", arg.Type.String())
 	msg += fmt.Sprintf("%s", arg.Name.Identifier())
+	msg += formatAnnotations(arg.Annotations)
 	this.fprintf("%s\n", msg)
 	this.dedent()
 }
 
+func formatAnnotations(annotations []*Annotation) string {
+	if len(annotations) == 0 {
+		return ""
+	}
+	parts := []string{}
+	for _, annotation := range annotations {
+		switch {
+		case annotation.Value == nil:
+			parts = append(parts, annotation.Name)
+		case annotation.Value.Kind == TOK_LITERAL_STRING:
+			parts = append(parts, fmt.Sprintf("%s = \"%s\"", annotation.Name, annotation.Value.StringLiteral()))
+		default:
+			parts = append(parts, fmt.Sprintf("%s = %d", annotation.Name, annotation.Value.IntLiteral()))
+		}
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
 func (this *AstPrinter) dumpLiteral(node Node) {
 	switch node.(type) {
 	case *LiteralNode:
@@ -149,6 +169,7 @@ func (this *AstPrinter) printNode(node Node) {
 			}
 			msg += fmt.Sprintf("%s ", PrettyPrintMap[field.Spec.Kind])
 			msg += fmt.Sprintf("%s", field.Name.Identifier())
+			msg += formatAnnotations(field.Annotations)
 			this.fprintf("%s\n", msg)
 		}
 		this.dedent()
@@ -16,6 +16,7 @@ package parser
 import (
 	"io/ioutil"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -33,10 +34,17 @@ type Scanner struct {
 	// If true, the last token was re-buffered and should be read again.
 	saved   bool
 	current *Token
+
+	// The text of the last doc comment, until the next token takes it.
+	doc string
 }
 
 func NewScanner(context *CompileContext) (*Scanner, error) {
-	bytes, err := ioutil.ReadFile(context.CurFile)
+	readFile := context.ReadFile
+	if readFile == nil {
+		readFile = ioutil.ReadFile
+	}
+	bytes, err := readFile(context.CurFile)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +69,9 @@ func (this *Scanner) next() *Token {
 	return this.current
 }
 
-// Undo the last scan, re-buffering the token we just read.
+// Undo the last scan, re-buffering the token we just read. Callers must undo
+// only directly after next(), as match() and peekDoc() do. Since next() always
+// takes the buffered token first, two undos can never stack up.
 func (this *Scanner) undo() {
 	if this.saved {
 		panic("Can only undo one token!")
@@ -129,25 +139,47 @@ func (this *Scanner) readUntilEndOfLine() {
 	}
 }
 
-// Reads until the end of a multi-line comment is reached.
-func (this *Scanner) readMultiLineComment() {
+// Reads until the end of a multi-line comment is reached, and returns the
+// text of the comment.
+func (this *Scanner) readMultiLineComment() string {
+	runes := []rune{}
 	for {
 		c := this.nextChar()
 
 		switch {
 		case c == EOF:
 			this.Context.ReportError(this.Position(), "reached end-of-file in multi-line comment")
-			return
+			return runesToString(runes)
 
 		case this.isEndOfLine(c):
 			this.nextLine(c)
+			c = '\n'
 
 		case c == '*':
 			if this.matchChar('/') {
-				return
+				return runesToString(runes)
 			}
 		}
+		runes = append(runes, c)
+	}
+}
+
+// Strips the leading "*" and whitespace from each line of a doc comment, and
+// drops leading and trailing blank lines.
+func formatDocComment(text string) string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimLeft(line, "*"))
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
 	}
+	return strings.Join(lines, "\n")
 }
 
 // Finds the next tokenizable character.
@@ -174,9 +206,14 @@ func (this *Scanner) nextTokenChar() (rune, Position) {
 				continue
 			}
 
-			// Detect multi-char comments.
+			// Detect multi-char comments. Doc comments start with "/**", and are
+			// kept for the next token.
 			if this.matchChar('*') {
-				this.readMultiLineComment()
+				isDoc := this.peekChar() == '*'
+				text := this.readMultiLineComment()
+				if isDoc {
+					this.doc = formatDocComment(text)
+				}
 				continue
 			}
 		}
@@ -287,7 +324,9 @@ func (this *Scanner) scan() *Token {
 		Loc: Location{
 			Start: start,
 		},
+		Doc: this.doc,
 	}
+	this.doc = ""
 
 	switch c {
 	case EOF:
@@ -0,0 +1,11 @@
+// Not valid: B and C extend each other, and A extends that cycle without
+// being part of it. Analysis must report the cycle, not loop forever.
+
+service A extends B {
+}
+
+service B extends C {
+}
+
+service C extends B {
+}
@@ -0,0 +1,14 @@
+// Not valid: B and C refer to each other, and A reaches that cycle without
+// being part of it. Analysis must report the cycle, not recurse forever.
+
+struct A {
+	1: B b
+}
+
+struct B {
+	1: C c
+}
+
+struct C {
+	1: B b
+}
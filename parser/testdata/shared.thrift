@@ -0,0 +1,17 @@
+// Definitions shared by the other seed files.
+
+namespace go shared
+namespace java com.example.shared
+
+struct SharedStruct {
+	1: i32 key
+	2: string value
+}
+
+exception NotFound {
+	1: required string message
+}
+
+service SharedService {
+	SharedStruct getStruct(1: i32 key)
+}
@@ -0,0 +1,41 @@
+/*
+ * A calculator service, along the lines of the Thrift tutorial.
+ */
+
+include "shared.thrift"
+
+namespace go tutorial
+
+typedef i32 MyInteger
+
+const i32 INT32CONSTANT = 9853
+const map<string, string> MAPCONSTANT = {"hello": "world", "goodnight": "moon"}
+const list<i16> PRIMES = [2, 3, 5, 7, 11]
+
+enum Operation {
+	ADD = 1,
+	SUBTRACT = 2,
+	MULTIPLY = 3,
+	DIVIDE = 4
+}
+
+struct Work {
+	1: i32 num1 = 0,
+	2: i32 num2,
+	3: Operation op,
+	4: optional string comment,
+}
+
+exception InvalidOperation {
+	1: i32 whatOp,
+	2: string why
+}
+
+const Work DEFAULT_WORK = {"num1": 1, "num2": 2, "op": Operation.ADD}
+
+service Calculator extends shared.SharedService {
+	void ping()
+	i32 add(1: i32 num1, 2: i32 num2)
+	i32 calculate(1: i32 logid, 2: Work w) throws (1: InvalidOperation ouch)
+	oneway void zip()
+}
@@ -0,0 +1,33 @@
+include "shared.thrift"
+
+enum Color {
+	RED = 1
+	GREEN = 2
+	BLUE
+}
+
+typedef i64 UserId
+typedef list<Color> Palette
+
+struct Point {
+	1: required i32 x
+	2: required i32 y
+}
+
+struct User {
+	1: required UserId id (min = 1)
+	2: required string name (min_length = 1, max_length = 64, regex = "^[A-Za-z ]+$")
+	3: optional Color favorite = Color.GREEN
+	4: optional Palette palette = [Color.RED, Color.BLUE]
+	5: optional map<string, Point> places
+	6: optional double score
+	7: optional bool active = true
+	8: optional list<list<i16>> grid
+	9: optional map<Color, list<string>> tags = {Color.RED: ["hot"]}
+}
+
+service Users extends shared.SharedService {
+	User getUser(1: UserId id (min = 1)) throws (1: shared.NotFound notFound)
+	list<User> findUsers(1: string query (min_length = 3), 2: i32 limit (max = 100))
+	oneway void touch(1: UserId id)
+}
@@ -38,6 +38,9 @@ type Token struct {
 	Kind TokenKind
 	Data interface{}
 	Loc  Location
+
+	// The text of a doc comment ("/** ... */") just before the token, if any.
+	Doc string
 }
 
 const (
@@ -0,0 +1,165 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Options that can be passed to NewProxy().
+type Options struct {
+	// Where to write records. If nil, nothing is recorded.
+	Log io.Writer
+
+	// If non-nil, logged message bodies are decoded with this service's schema,
+	// so that records have field names. Messages for other methods are still
+	// passed on, and recorded with fields keyed by id.
+	Service *parser.ServiceNode
+
+	// Protocol for clients. If nil, the binary protocol is used. The upstream
+	// protocol is chosen by the upstream service factory.
+	ProtocolFactory thrift.TProtocolFactory
+
+	// Maximum number of idle upstream connections. If zero, 8 are kept.
+	MaxIdle int
+
+	// If non-nil, receives errors from the server.
+	LogError func(context string, err error)
+}
+
+// A proxy that passes every message from its clients on to an upstream
+// service, and every reply back, recording each one. A Proxy is a complete
+// frugal.ServerInterface:
+//
+//     proxy := proxy.NewProxy(upstream, &proxy.Options{Log: file, Service: service})
+//     server, err := frugal.NewServer(proxy, &frugal.ServerOptions{ListenAddr: addr})
+//     go server.Serve()
+//
+// Message bodies are passed on as they were sent, whether or not the schema
+// describes them; the schema is only used to decode a copy for the log. If
+// the upstream service cannot be reached, clients get an INTERNAL_ERROR
+// exception.
+type Proxy struct {
+	pool    *frugal.SocketPool
+	options Options
+
+	lock sync.Mutex
+}
+
+func NewProxy(upstream frugal.ServiceFactory, options *Options) *Proxy {
+	proxy := &Proxy{
+		options: *options,
+	}
+	if proxy.options.ProtocolFactory == nil {
+		proxy.options.ProtocolFactory = thrift.NewTBinaryProtocolFactoryDefault()
+	}
+	if proxy.options.MaxIdle == 0 {
+		proxy.options.MaxIdle = 8
+	}
+	proxy.pool = frugal.NewSocketPool(upstream, proxy.options.MaxIdle)
+	return proxy
+}
+
+// Closes every upstream connection.
+func (this *Proxy) Close() {
+	this.pool.Close()
+}
+
+// Implements frugal.ServerInterface.
+func (this *Proxy) GetProtocolsForClient(client frugal.Transport) (thrift.TProtocol, thrift.TProtocol) {
+	factory := this.options.ProtocolFactory
+	return factory.GetProtocol(client), factory.GetProtocol(client)
+}
+
+// Implements frugal.ServerInterface.
+func (this *Proxy) LogError(context string, err error) {
+	if this.options.LogError != nil {
+		this.options.LogError(context, err)
+	}
+}
+
+// Writes a message to the log, if there is one. If the message could not be
+// passed on, the error is recorded with it.
+func (this *Proxy) record(request int64, name string, msgType thrift.TMessageType, seqId int32, wire []byte, failure error) {
+	if this.options.Log == nil {
+		return
+	}
+
+	// A body the schema cannot describe was still passed on, so it is still
+	// recorded, with only its wire form.
+	record, err := newRecord(request, name, msgType, seqId, wire, this.options.Service)
+	if err != nil {
+		this.LogError("record", err)
+	}
+	if failure != nil {
+		record.Error = failure.Error()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		this.LogError("record", err)
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, err := this.options.Log.Write(append(data, '\n')); err != nil {
+		this.LogError("record", err)
+	}
+}
+
+// Implements frugal.Processor.
+func (this *Proxy) ProcessRequest(request *frugal.Request) error {
+	wire, err := readBody(request.Input)
+	if err != nil {
+		return err
+	}
+
+	name, msgType, seqId, reply, err := this.forward(request, wire)
+	this.record(request.RequestId, request.MethodName, request.MessageType, request.SequenceId, wire, err)
+	if err != nil {
+		this.LogError("upstream", err)
+		return request.SendException(thrift.INTERNAL_ERROR, fmt.Sprintf("proxy: %v", err))
+	}
+	if reply == nil {
+		return nil
+	}
+
+	this.record(request.RequestId, name, msgType, seqId, reply, nil)
+	return writeMessage(request.Output, name, msgType, seqId, reply)
+}
+
+// Passes a message body on to the upstream service, and returns the body of
+// the reply, if the message was not oneway.
+func (this *Proxy) forward(request *frugal.Request, wire []byte) (name string, msgType thrift.TMessageType, seqId int32, reply []byte, err error) {
+	conn, err := this.pool.Get()
+	if err != nil {
+		return
+	}
+	defer this.pool.Put(conn, &err)
+
+	if err = writeMessage(conn.Output(), request.MethodName, request.MessageType, request.SequenceId, wire); err != nil {
+		return
+	}
+	if request.MessageType == thrift.ONEWAY {
+		return
+	}
+	return readMessage(conn.Input())
+}
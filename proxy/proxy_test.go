@@ -0,0 +1,178 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/mock"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proxy testing")
+}
+
+const TestSchema = `
+struct User {
+	1: required i64 id
+	2: required string name
+	3: optional list<string> tags
+}
+
+service Users extends Base {
+	User getUser(1: i64 id) throws (1: NotFound notFound)
+	oneway void touch(1: i64 id)
+	void forget(1: i64 id)
+}
+` + sematest.UsersSchema
+
+const TestRules = `[
+	{"method": "getUser", "args": {"id": 1}, "result": {"id": 1, "name": "Ada", "tags": ["a", "b"]}},
+	{"method": "getUser", "exception": {"notFound": {"message": "no such user"}}},
+	{"method": "touch"}
+]`
+
+// Parses the test schema and returns the Users service.
+func ParseTestService() *parser.ServiceNode {
+	service, err := dynamic.FindService(sematest.CompileSchema(TestSchema), "Users")
+	Expect(err).To(BeNil())
+	return service
+}
+
+// Starts a mock service answering from rules, and returns a factory for
+// connections to it.
+func NewTestUpstream(service *parser.ServiceNode, rules string) frugal.ServiceFactory {
+	parsed, err := mock.ParseRules([]byte(rules))
+	Expect(err).To(BeNil())
+	processor, err := mock.NewProcessor(service, parsed, &mock.Options{})
+	Expect(err).To(BeNil())
+	server, err := frugal.NewServer(processor, &frugal.ServerOptions{ListenAddr: "127.0.0.1:0"})
+	Expect(err).To(BeNil())
+	return frugal.NewMemoryServiceFactory(server, nil, 5*time.Second)
+}
+
+// An upstream service that answers every call with the same reply body, and
+// keeps the bodies of the calls it gets.
+type TestRawUpstream struct {
+	reply    []byte
+	received chan []byte
+}
+
+func NewTestRawUpstream(reply []byte) (*TestRawUpstream, frugal.ServiceFactory) {
+	upstream := &TestRawUpstream{
+		reply:    reply,
+		received: make(chan []byte, 1),
+	}
+	server, err := frugal.NewServer(upstream, &frugal.ServerOptions{ListenAddr: "127.0.0.1:0"})
+	Expect(err).To(BeNil())
+	return upstream, frugal.NewMemoryServiceFactory(server, nil, 5*time.Second)
+}
+
+func (this *TestRawUpstream) GetProtocolsForClient(client frugal.Transport) (thrift.TProtocol, thrift.TProtocol) {
+	factory := thrift.NewTBinaryProtocolFactoryDefault()
+	return factory.GetProtocol(client), factory.GetProtocol(client)
+}
+
+func (this *TestRawUpstream) LogError(context string, err error) {
+}
+
+func (this *TestRawUpstream) ProcessRequest(request *frugal.Request) error {
+	body, err := readBody(request.Input)
+	if err != nil {
+		return err
+	}
+	this.received <- body
+	return writeMessage(request.Output, request.MethodName, thrift.REPLY, request.SequenceId, this.reply)
+}
+
+// Writes a body in the binary protocol.
+func WriteTestBody(write func(oprot thrift.TProtocol)) []byte {
+	buffer := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buffer)
+	oprot.WriteStructBegin("")
+	write(oprot)
+	oprot.WriteFieldStop()
+	oprot.WriteStructEnd()
+	return buffer.Bytes()
+}
+
+// A service factory that always fails.
+type TestDownFactory struct {
+}
+
+func (this *TestDownFactory) Connect() (*frugal.Connection, error) {
+	return nil, errors.New("connection refused")
+}
+
+// A log that can be read while a proxy writes to it.
+type TestLog struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (this *TestLog) Write(data []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.buffer.Write(data)
+}
+
+func (this *TestLog) Records() []*Record {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	records, err := ReadRecords(bytes.NewReader(this.buffer.Bytes()))
+	Expect(err).To(BeNil())
+	return records
+}
+
+// A client of a proxy, connected in memory.
+type TestClient struct {
+	service *parser.ServiceNode
+	conn    *frugal.Connection
+	seqId   int32
+}
+
+func NewTestClient(service *parser.ServiceNode, proxy *Proxy) *TestClient {
+	server, err := frugal.NewServer(proxy, &frugal.ServerOptions{ListenAddr: "127.0.0.1:0"})
+	Expect(err).To(BeNil())
+	conn, err := frugal.NewMemoryServiceFactory(server, nil, 5*time.Second).Connect()
+	Expect(err).To(BeNil())
+	return &TestClient{service: service, conn: conn}
+}
+
+func (this *TestClient) Close() {
+	this.conn.Transport().Close()
+}
+
+// Calls a method with JSON arguments.
+func (this *TestClient) Call(name string, args string) (*dynamic.Struct, error) {
+	method := dynamic.FindServiceMethod(this.service, name)
+	Expect(method).NotTo(BeNil())
+	value, err := dynamic.ArgsFromJSON(method, []byte(args))
+	Expect(err).To(BeNil())
+
+	this.seqId++
+	return dynamic.Call(this.conn.Input(), this.conn.Output(), name, this.seqId, method, value)
+}
@@ -0,0 +1,182 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+)
+
+// One message seen by the proxy. Records are written as JSON, one per line:
+//
+//     {"time":"...","request":1,"method":"getUser","seqid":1,"type":"call","body":{"id":42},"wire":"CgAB..."}
+//     {"time":"...","request":1,"method":"getUser","seqid":1,"type":"reply","body":{"success":{...}},"wire":"DAAA..."}
+//
+// A call and its reply share the same request number.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Request int64     `json:"request"`
+	Method  string    `json:"method"`
+	SeqId   int32     `json:"seqid"`
+
+	// One of "call", "oneway", "reply" or "exception".
+	Type string `json:"type"`
+
+	// The message body as JSON (see dynamic.StructToJSON()). Without a schema,
+	// fields are keyed by id.
+	Body json.RawMessage `json:"body,omitempty"`
+
+	// The message body exactly as it was sent, so that it can be replayed
+	// even without a schema. This is always in the binary protocol; bodies
+	// sent in another protocol are copied into it token by token.
+	Wire []byte `json:"wire,omitempty"`
+
+	// If the message could not be passed on, or its reply could not be read,
+	// the error. A call with an error has no reply record.
+	Error string `json:"error,omitempty"`
+}
+
+var messageTypeNames = map[thrift.TMessageType]string{
+	thrift.CALL:      "call",
+	thrift.ONEWAY:    "oneway",
+	thrift.REPLY:     "reply",
+	thrift.EXCEPTION: "exception",
+}
+
+// Returns the message type of a record.
+func (this *Record) MessageType() (thrift.TMessageType, error) {
+	for msgType, name := range messageTypeNames {
+		if name == this.Type {
+			return msgType, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown message type: %s", this.Type)
+}
+
+// Returns true for calls and oneway calls.
+func (this *Record) IsCall() bool {
+	return this.Type == "call" || this.Type == "oneway"
+}
+
+// Returns a record for a message with a body from readBody(). The body is
+// decoded with the service's schema only to fill in Body; if it cannot be
+// decoded, the record is still returned, without Body, along with the error.
+func newRecord(request int64, name string, msgType thrift.TMessageType, seqId int32, wire []byte, service *parser.ServiceNode) (*Record, error) {
+	record := &Record{
+		Time:    time.Now(),
+		Request: request,
+		Method:  name,
+		SeqId:   seqId,
+		Type:    messageTypeNames[msgType],
+		Wire:    wire,
+	}
+	if wire == nil {
+		return record, nil
+	}
+
+	body, err := record.Decode(service)
+	if err != nil {
+		return record, err
+	}
+	if record.Body, err = json.Marshal(dynamic.StructToJSON(body)); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// Decodes the body of a record from its wire form, using the schema of the
+// given service if it has the method. The service may be nil.
+func (this *Record) Decode(service *parser.ServiceNode) (*dynamic.Struct, error) {
+	msgType, err := this.MessageType()
+	if err != nil {
+		return nil, err
+	}
+	buffer := thrift.NewTMemoryBuffer()
+	buffer.Write(this.Wire)
+	return dynamic.DecodeStruct(thrift.NewTBinaryProtocolTransport(buffer), bodyStruct(service, this.Method, msgType))
+}
+
+// Returns the schema for the body of a message, or nil if there is none.
+func bodyStruct(service *parser.ServiceNode, name string, msgType thrift.TMessageType) *parser.StructNode {
+	if msgType == thrift.EXCEPTION {
+		return dynamic.ExceptionStruct()
+	}
+	if index := strings.LastIndex(name, ":"); index != -1 {
+		name = name[index+1:]
+	}
+	method := dynamic.FindServiceMethod(service, name)
+	if method == nil {
+		return nil
+	}
+	if msgType == thrift.REPLY {
+		return dynamic.ResultStruct(method)
+	}
+	return dynamic.ArgsStruct(method)
+}
+
+// Reads a whole message, returning its body as from readBody().
+func readMessage(iprot thrift.TProtocol) (string, thrift.TMessageType, int32, []byte, error) {
+	name, msgType, seqId, err := iprot.ReadMessageBegin()
+	if err != nil {
+		return "", 0, 0, nil, err
+	}
+	wire, err := readBody(iprot)
+	if err != nil {
+		return "", 0, 0, nil, err
+	}
+	return name, msgType, seqId, wire, nil
+}
+
+// Reads records written by a Proxy.
+func ReadRecords(reader io.Reader) ([]*Record, error) {
+	records := []*Record{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, err := record.MessageType(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Reads records from a file written by a Proxy.
+func LoadRecords(path string) ([]*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRecords(file)
+}
@@ -0,0 +1,164 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Proxy", func() {
+	var service *parser.ServiceNode
+	var log *TestLog
+
+	BeforeEach(func() {
+		service = ParseTestService()
+		log = &TestLog{}
+	})
+
+	It("records calls and replies", func() {
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		result, err := client.Call("getUser", `{"id": 1}`)
+		Expect(err).To(BeNil())
+		Expect(result.Field("success").Value.(*dynamic.Struct).Field("name").Value).To(Equal("Ada"))
+
+		result, err = client.Call("getUser", `{"id": 2}`)
+		Expect(err).To(BeNil())
+		Expect(result.Field("notFound")).NotTo(BeNil())
+
+		records := log.Records()
+		Expect(records).To(HaveLen(4))
+		Expect(records[0].Type).To(Equal("call"))
+		Expect(records[0].Method).To(Equal("getUser"))
+		Expect(records[0].SeqId).To(Equal(int32(1)))
+		Expect(string(records[0].Body)).To(Equal(`{"id":1}`))
+		Expect(records[1].Type).To(Equal("reply"))
+		Expect(records[1].Request).To(Equal(records[0].Request))
+		Expect(string(records[1].Body)).To(Equal(`{"success":{"id":1,"name":"Ada","tags":["a","b"]}}`))
+		Expect(string(records[3].Body)).To(Equal(`{"notFound":{"message":"no such user"}}`))
+
+		// Bodies can be decoded again from their wire form.
+		body, err := records[1].Decode(service)
+		Expect(err).To(BeNil())
+		Expect(body.Field("success")).NotTo(BeNil())
+	})
+
+	It("passes bodies on byte for byte", func() {
+		// Decoding and encoding again would turn the set into a list, and
+		// give the empty containers the types of their schema, or none.
+		call := WriteTestBody(func(oprot thrift.TProtocol) {
+			oprot.WriteFieldBegin("", thrift.I64, 1)
+			oprot.WriteI64(1)
+			oprot.WriteFieldBegin("", thrift.SET, 9)
+			oprot.WriteSetBegin(thrift.I32, 2)
+			oprot.WriteI32(2)
+			oprot.WriteI32(1)
+			oprot.WriteFieldBegin("", thrift.LIST, 10)
+			oprot.WriteListBegin(thrift.I32, 0)
+		})
+		reply := WriteTestBody(func(oprot thrift.TProtocol) {
+			oprot.WriteFieldBegin("", thrift.STRUCT, 0)
+			oprot.WriteStructBegin("")
+			oprot.WriteFieldBegin("", thrift.LIST, 3)
+			oprot.WriteListBegin(thrift.STRING, 0)
+			oprot.WriteFieldBegin("", thrift.MAP, 11)
+			oprot.WriteMapBegin(thrift.I64, thrift.DOUBLE, 0)
+			oprot.WriteFieldStop()
+		})
+
+		upstream, factory := NewTestRawUpstream(reply)
+		proxy := NewProxy(factory, &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		Expect(writeMessage(client.conn.Output(), "getUser", thrift.CALL, 1, call)).To(Succeed())
+		_, msgType, _, received, err := readMessage(client.conn.Input())
+		Expect(err).To(BeNil())
+		Expect(msgType).To(Equal(thrift.TMessageType(thrift.REPLY)))
+		Expect(received).To(Equal(reply))
+		Expect(<-upstream.received).To(Equal(call))
+
+		records := log.Records()
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Wire).To(Equal(call))
+		Expect(string(records[0].Body)).To(ContainSubstring(`"id":1`))
+		Expect(records[1].Wire).To(Equal(reply))
+	})
+
+	It("records oneway calls", func() {
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		_, err := client.Call("touch", `{"id": 3}`)
+		Expect(err).To(BeNil())
+		Eventually(func() int { return len(log.Records()) }).Should(Equal(1))
+		Expect(log.Records()[0].Type).To(Equal("oneway"))
+	})
+
+	It("passes application exceptions back", func() {
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		_, err := client.Call("forget", `{"id": 3}`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.(thrift.TApplicationException).TypeId()).To(Equal(int32(thrift.INTERNAL_ERROR)))
+
+		records := log.Records()
+		Expect(records).To(HaveLen(2))
+		Expect(records[1].Type).To(Equal("exception"))
+		Expect(string(records[1].Body)).To(Equal(`{"message":"no rule matches call to forget","type":6}`))
+	})
+
+	It("works without a schema", func() {
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		result, err := client.Call("getUser", `{"id": 1}`)
+		Expect(err).To(BeNil())
+		Expect(result.Field("success").Value.(*dynamic.Struct).Field("tags").Value).To(Equal([]interface{}{"a", "b"}))
+
+		records := log.Records()
+		Expect(string(records[0].Body)).To(Equal(`{"1":1}`))
+		Expect(string(records[1].Body)).To(Equal(`{"0":{"1":1,"2":"Ada","3":["a","b"]}}`))
+	})
+
+	It("reports unreachable upstreams", func() {
+		proxy := NewProxy(&TestDownFactory{}, &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		_, err := client.Call("getUser", `{"id": 1}`)
+		Expect(err).NotTo(BeNil())
+		Expect(err.(thrift.TApplicationException).TypeId()).To(Equal(int32(thrift.INTERNAL_ERROR)))
+
+		records := log.Records()
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Error).To(Equal("connection refused"))
+	})
+})
@@ -0,0 +1,235 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A difference between a recorded reply and a replayed one.
+type Difference struct {
+	// A JSON pointer into the reply, as {"type": ..., "body": ...}.
+	Pointer string
+
+	// The recorded and replayed values, as parsed JSON, or nil if missing.
+	Recorded interface{}
+	Replayed interface{}
+}
+
+func (this *Difference) String() string {
+	return fmt.Sprintf("%s: recorded %s, replayed %s", this.Pointer, formatJSON(this.Recorded), formatJSON(this.Replayed))
+}
+
+func formatJSON(value interface{}) string {
+	if value == nil {
+		return "nothing"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+// The outcome of replaying one recorded call.
+type ReplayResult struct {
+	Call *Record
+
+	// The recorded reply and the new one. Either is nil for oneway calls, and
+	// Recorded is nil if the recording has no reply for the call.
+	Recorded *Record
+	Replayed *Record
+
+	// If the call could not be replayed, the error.
+	Err error
+
+	// How the replies differ, if both are present.
+	Differences []*Difference
+}
+
+// Re-issues recorded calls, in order, against another endpoint, and compares
+// each reply with the recorded one. Calls that failed when they were recorded
+// are skipped. If a call fails, its connection is dropped and the next call
+// gets a new one.
+//
+// The service is only used to give replies field names, and may be nil; it
+// should be the same one the recording was made with.
+func Replay(upstream frugal.ServiceFactory, records []*Record, service *parser.ServiceNode) []*ReplayResult {
+	replies := pairReplies(records)
+
+	results := []*ReplayResult{}
+	var conn *frugal.Connection
+	for _, record := range records {
+		if !record.IsCall() || record.Error != "" {
+			continue
+		}
+
+		result := &ReplayResult{
+			Call:     record,
+			Recorded: replies[record],
+		}
+		results = append(results, result)
+
+		if conn == nil {
+			if conn, result.Err = upstream.Connect(); result.Err != nil {
+				conn = nil
+				continue
+			}
+		}
+		if result.Replayed, result.Err = replayCall(conn, record, service); result.Err != nil {
+			conn.Transport().Close()
+			conn = nil
+			continue
+		}
+		if result.Recorded != nil && result.Replayed != nil {
+			result.Differences = diffReplies(result.Recorded, result.Replayed, service)
+		}
+	}
+	if conn != nil {
+		conn.Transport().Close()
+	}
+	return results
+}
+
+// Returns the reply to each call. Request numbers restart when the proxy
+// does, so a log that was appended to across restarts can repeat them; each
+// reply goes to the latest call before it with the same number.
+func pairReplies(records []*Record) map[*Record]*Record {
+	pending := map[int64]*Record{}
+	replies := map[*Record]*Record{}
+	for _, record := range records {
+		if record.IsCall() {
+			pending[record.Request] = record
+			continue
+		}
+		if call, ok := pending[record.Request]; ok {
+			replies[call] = record
+			delete(pending, record.Request)
+		}
+	}
+	return replies
+}
+
+// Sends a recorded call as it was recorded, and returns a record of the reply.
+func replayCall(conn *frugal.Connection, call *Record, service *parser.ServiceNode) (*Record, error) {
+	msgType, err := call.MessageType()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMessage(conn.Output(), call.Method, msgType, call.SeqId, call.Wire); err != nil {
+		return nil, err
+	}
+	if msgType == thrift.ONEWAY {
+		return nil, nil
+	}
+
+	name, msgType, seqId, reply, err := readMessage(conn.Input())
+	if err != nil {
+		return nil, err
+	}
+	if seqId != call.SeqId {
+		return nil, fmt.Errorf("expected sequence id %d in reply, got %d", call.SeqId, seqId)
+	}
+
+	// Replies are decoded again when compared, so one the schema cannot
+	// describe is still a reply.
+	record, _ := newRecord(call.Request, name, msgType, seqId, reply, service)
+	return record, nil
+}
+
+// Compares two replies. Bodies are decoded again from their wire form, so
+// that both are described by the same schema.
+func diffReplies(recorded *Record, replayed *Record, service *parser.ServiceNode) []*Difference {
+	parse := func(record *Record) interface{} {
+		data := []byte(record.Body)
+		if decoded, err := record.Decode(service); err == nil {
+			data, _ = json.Marshal(dynamic.StructToJSON(decoded))
+		}
+		body, _ := dynamic.ParseJSON(data)
+		return map[string]interface{}{
+			"type": record.Type,
+			"body": body,
+		}
+	}
+	return Diff(parse(recorded), parse(replayed))
+}
+
+// Compares two parsed JSON values (see dynamic.ParseJSON()), and returns
+// every difference between them, ordered by pointer.
+func Diff(recorded interface{}, replayed interface{}) []*Difference {
+	differences := []*Difference{}
+	diff("", recorded, replayed, &differences)
+	return differences
+}
+
+func diff(pointer string, recorded interface{}, replayed interface{}, differences *[]*Difference) {
+	switch recorded.(type) {
+	case map[string]interface{}:
+		recorded := recorded.(map[string]interface{})
+		replayed, ok := replayed.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := []string{}
+		for key, _ := range recorded {
+			keys = append(keys, key)
+		}
+		for key, _ := range replayed {
+			if _, ok := recorded[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diff(dynamic.AppendPointer(pointer, key), recorded[key], replayed[key], differences)
+		}
+		return
+
+	case []interface{}:
+		recorded := recorded.([]interface{})
+		replayed, ok := replayed.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(recorded) || i < len(replayed); i++ {
+			var a, b interface{}
+			if i < len(recorded) {
+				a = recorded[i]
+			}
+			if i < len(replayed) {
+				b = replayed[i]
+			}
+			diff(dynamic.AppendPointer(pointer, fmt.Sprintf("%d", i)), a, b, differences)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(recorded, replayed) {
+		*differences = append(*differences, &Difference{
+			Pointer:  pointer,
+			Recorded: recorded,
+			Replayed: replayed,
+		})
+	}
+}
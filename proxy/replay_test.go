@@ -0,0 +1,150 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"strings"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	var service *parser.ServiceNode
+	var records []*Record
+
+	// Records a few calls through a proxy.
+	BeforeEach(func() {
+		service = ParseTestService()
+		log := &TestLog{}
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		client.Call("getUser", `{"id": 1}`)
+		client.Call("touch", `{"id": 1}`)
+		client.Call("getUser", `{"id": 2}`)
+		Eventually(func() int { return len(log.Records()) }).Should(Equal(5))
+		records = log.Records()
+	})
+
+	It("finds no differences against the same service", func() {
+		results := Replay(NewTestUpstream(service, TestRules), records, service)
+		Expect(results).To(HaveLen(3))
+		for _, result := range results {
+			Expect(result.Err).To(BeNil())
+			Expect(result.Differences).To(BeEmpty())
+		}
+		Expect(results[1].Call.Type).To(Equal("oneway"))
+		Expect(results[1].Replayed).To(BeNil())
+	})
+
+	It("finds differences against another service", func() {
+		changed := `[
+			{"method": "getUser", "args": {"id": 1}, "result": {"id": 1, "name": "Ada L.", "tags": ["a"]}},
+			{"method": "touch"}
+		]`
+		results := Replay(NewTestUpstream(service, changed), records, service)
+		Expect(results).To(HaveLen(3))
+
+		strs := []string{}
+		for _, difference := range results[0].Differences {
+			strs = append(strs, difference.String())
+		}
+		Expect(strs).To(Equal([]string{
+			`/body/success/name: recorded "Ada", replayed "Ada L."`,
+			`/body/success/tags/1: recorded "b", replayed nothing`,
+		}))
+
+		// The second getUser matches no rule, so the mock throws an application
+		// exception instead of NotFound.
+		Expect(results[2].Replayed.Type).To(Equal("exception"))
+		Expect(results[2].Differences[0].Pointer).To(Equal("/body/message"))
+		Expect(results[2].Differences).To(ContainElement(&Difference{
+			Pointer:  "/type",
+			Recorded: "reply",
+			Replayed: "exception",
+		}))
+	})
+
+	It("pairs replies with calls across restarts", func() {
+		// A second proxy numbers its requests from 1 again, as a restarted one
+		// appending to the same log would.
+		log := &TestLog{}
+		proxy := NewProxy(NewTestUpstream(service, TestRules), &Options{Log: log, Service: service})
+		defer proxy.Close()
+		client := NewTestClient(service, proxy)
+		defer client.Close()
+
+		client.Call("getUser", `{"id": 2}`)
+		Eventually(func() int { return len(log.Records()) }).Should(Equal(2))
+		restarted := log.Records()
+		Expect(restarted[0].Request).To(Equal(records[0].Request))
+
+		results := Replay(NewTestUpstream(service, TestRules), append(records, restarted...), service)
+		Expect(results).To(HaveLen(4))
+		for _, result := range results {
+			Expect(result.Err).To(BeNil())
+			Expect(result.Differences).To(BeEmpty())
+		}
+		Expect(results[0].Recorded).To(Equal(records[1]))
+		Expect(results[3].Recorded).To(Equal(restarted[1]))
+	})
+
+	It("replays without a schema", func() {
+		results := Replay(NewTestUpstream(service, TestRules), records, nil)
+		Expect(results).To(HaveLen(3))
+
+		// The recording has field names, and the replay does not, but both are
+		// compared without the schema.
+		Expect(results[0].Err).To(BeNil())
+		Expect(string(results[0].Replayed.Body)).To(Equal(`{"0":{"1":1,"2":"Ada","3":["a","b"]}}`))
+		Expect(results[0].Differences).To(BeEmpty())
+	})
+
+	It("reports failed calls", func() {
+		results := Replay(&TestDownFactory{}, records, service)
+		Expect(results).To(HaveLen(3))
+		for _, result := range results {
+			Expect(result.Err).NotTo(BeNil())
+		}
+	})
+
+	It("diffs JSON values", func() {
+		parse := func(text string) interface{} {
+			value, err := dynamic.ParseJSON([]byte(text))
+			Expect(err).To(BeNil())
+			return value
+		}
+		differences := Diff(parse(`{"a": [1, 2], "b/c": {"d": true}, "e": 1}`), parse(`{"a": [1, 3], "b/c": 5, "f": null}`))
+		strs := []string{}
+		for _, difference := range differences {
+			strs = append(strs, difference.String())
+		}
+		Expect(strs).To(Equal([]string{
+			"/a/1: recorded 2, replayed 3",
+			`/b~1c: recorded {"d":true}, replayed 5`,
+			"/e: recorded 1, replayed nothing",
+		}))
+	})
+
+	It("reads records", func() {
+		_, err := ReadRecords(strings.NewReader(`{"type": "call"}` + "\n\n" + `{"type": "nope"}`))
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(Equal("line 3: unknown message type: nope"))
+	})
+})
@@ -0,0 +1,205 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+)
+
+// Reads a message body, and returns it in the binary protocol. Bodies are
+// copied a token at a time rather than decoded, so field order, unknown
+// fields, sets, and the element types of empty containers are all kept; a
+// body sent in the binary protocol comes back byte for byte.
+func readBody(iprot thrift.TProtocol) ([]byte, error) {
+	buffer := thrift.NewTMemoryBuffer()
+	oprot := thrift.NewTBinaryProtocolTransport(buffer)
+	if err := copyValue(iprot, oprot, thrift.STRUCT, 0); err != nil {
+		return nil, err
+	}
+	if err := iprot.ReadMessageEnd(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Writes a whole message, with a body returned by readBody().
+func writeMessage(oprot thrift.TProtocol, name string, msgType thrift.TMessageType, seqId int32, wire []byte) error {
+	if err := oprot.WriteMessageBegin(name, msgType, seqId); err != nil {
+		return err
+	}
+	if err := copyValue(wireProtocol(wire), oprot, thrift.STRUCT, 0); err != nil {
+		return err
+	}
+	if err := oprot.WriteMessageEnd(); err != nil {
+		return err
+	}
+	return oprot.Flush()
+}
+
+// Returns a protocol for reading a body returned by readBody().
+func wireProtocol(wire []byte) thrift.TProtocol {
+	buffer := thrift.NewTMemoryBuffer()
+	buffer.Write(wire)
+	return thrift.NewTBinaryProtocolTransport(buffer)
+}
+
+func copyValue(iprot thrift.TProtocol, oprot thrift.TProtocol, ttype thrift.TType, depth int) error {
+	if depth > dynamic.MaxDepth {
+		return fmt.Errorf("values are nested more than %d deep", dynamic.MaxDepth)
+	}
+
+	switch ttype {
+	case thrift.BOOL:
+		value, err := iprot.ReadBool()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteBool(value)
+	case thrift.BYTE:
+		value, err := iprot.ReadByte()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteByte(value)
+	case thrift.I16:
+		value, err := iprot.ReadI16()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI16(value)
+	case thrift.I32:
+		value, err := iprot.ReadI32()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI32(value)
+	case thrift.I64:
+		value, err := iprot.ReadI64()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteI64(value)
+	case thrift.DOUBLE:
+		value, err := iprot.ReadDouble()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteDouble(value)
+	case thrift.STRING:
+		// Binary fields share the string type, so copy the raw bytes.
+		value, err := iprot.ReadBinary()
+		if err != nil {
+			return err
+		}
+		return oprot.WriteBinary(value)
+	case thrift.STRUCT:
+		return copyStruct(iprot, oprot, depth)
+	case thrift.LIST:
+		elemType, size, err := iprot.ReadListBegin()
+		if err != nil {
+			return err
+		}
+		if err := oprot.WriteListBegin(elemType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(iprot, oprot, elemType, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadListEnd(); err != nil {
+			return err
+		}
+		return oprot.WriteListEnd()
+	case thrift.SET:
+		elemType, size, err := iprot.ReadSetBegin()
+		if err != nil {
+			return err
+		}
+		if err := oprot.WriteSetBegin(elemType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(iprot, oprot, elemType, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadSetEnd(); err != nil {
+			return err
+		}
+		return oprot.WriteSetEnd()
+	case thrift.MAP:
+		keyType, valueType, size, err := iprot.ReadMapBegin()
+		if err != nil {
+			return err
+		}
+		if err := oprot.WriteMapBegin(keyType, valueType, size); err != nil {
+			return err
+		}
+		for i := 0; i < size; i++ {
+			if err := copyValue(iprot, oprot, keyType, depth+1); err != nil {
+				return err
+			}
+			if err := copyValue(iprot, oprot, valueType, depth+1); err != nil {
+				return err
+			}
+		}
+		if err := iprot.ReadMapEnd(); err != nil {
+			return err
+		}
+		return oprot.WriteMapEnd()
+	}
+	return fmt.Errorf("unknown type id %d", ttype)
+}
+
+func copyStruct(iprot thrift.TProtocol, oprot thrift.TProtocol, depth int) error {
+	name, err := iprot.ReadStructBegin()
+	if err != nil {
+		return err
+	}
+	if err := oprot.WriteStructBegin(name); err != nil {
+		return err
+	}
+	for {
+		name, ttype, id, err := iprot.ReadFieldBegin()
+		if err != nil {
+			return err
+		}
+		if ttype == thrift.STOP {
+			break
+		}
+		if err := oprot.WriteFieldBegin(name, ttype, id); err != nil {
+			return err
+		}
+		if err := copyValue(iprot, oprot, ttype, depth+1); err != nil {
+			return err
+		}
+		if err := iprot.ReadFieldEnd(); err != nil {
+			return err
+		}
+		if err := oprot.WriteFieldEnd(); err != nil {
+			return err
+		}
+	}
+	if err := iprot.ReadStructEnd(); err != nil {
+		return err
+	}
+	if err := oprot.WriteFieldStop(); err != nil {
+		return err
+	}
+	return oprot.WriteStructEnd()
+}
@@ -30,6 +30,16 @@ func cyclicCheck(context *CompileContext, tree *ParseTree) bool {
 	return checker.check()
 }
 
+// Typedefs are resolved during type checking, so cyclic typedefs must be found
+// before it runs.
+func typedefCheck(context *CompileContext, tree *ParseTree) bool {
+	checker := &CyclicChecker{
+		context: context,
+		tree:    tree,
+	}
+	return checker.checkTypedefs()
+}
+
 func (this *CyclicChecker) check() bool {
 	for _, node := range this.tree.Nodes {
 		switch node.(type) {
@@ -43,18 +53,21 @@ func (this *CyclicChecker) check() bool {
 	return !this.context.HasErrors()
 }
 
-func (this *CyclicChecker) findNestedType(ttype Type, target *StructNode) bool {
+// Search a type expression for a reference to the target struct. Structs in
+// seen have already been searched, which also stops cycles that do not
+// include the target.
+func (this *CyclicChecker) findNestedType(ttype Type, target *StructNode, seen map[*StructNode]bool) bool {
 	// Peel away typedefs.
 	ttype, binding := ttype.Resolve()
 
 	switch ttype.(type) {
 	case *ListType:
 		ttype := ttype.(*ListType)
-		return this.findNestedType(ttype.Inner, target)
+		return this.findNestedType(ttype.Inner, target, seen)
 
 	case *MapType:
 		ttype := ttype.(*MapType)
-		if this.findNestedType(ttype.Key, target) || this.findNestedType(ttype.Value, target) {
+		if this.findNestedType(ttype.Key, target, seen) || this.findNestedType(ttype.Value, target, seen) {
 			return true
 		}
 
@@ -69,9 +82,15 @@ func (this *CyclicChecker) findNestedType(ttype Type, target *StructNode) bool {
 			return true
 		}
 
+		// Cycles that do not include the target are reported on their own.
+		if seen[node] {
+			return false
+		}
+		seen[node] = true
+
 		// Search the struct's fields
 		for _, field := range node.Fields {
-			if this.findNestedType(field.Type, target) {
+			if this.findNestedType(field.Type, target, seen) {
 				return true
 			}
 		}
@@ -82,10 +101,10 @@ func (this *CyclicChecker) findNestedType(ttype Type, target *StructNode) bool {
 
 func (this *CyclicChecker) checkCyclicStruct(node *StructNode) {
 	// For each field type, recursively traverse compound types to find references
-	// to the outer struct. This algorithm is not very intelligent - for example -
-	// it will not cache types it has already seen.
+	// to the outer struct.
+	seen := map[*StructNode]bool{}
 	for _, field := range node.Fields {
-		if this.findNestedType(field.Type, node) {
+		if this.findNestedType(field.Type, node, seen) {
 			this.context.ReportError(
 				field.Name.Loc.Start,
 				"field '%s' introduces a cyclic reference to struct '%s'",
@@ -97,11 +116,63 @@ func (this *CyclicChecker) checkCyclicStruct(node *StructNode) {
 	}
 }
 
+func (this *CyclicChecker) checkTypedefs() bool {
+	for _, node := range this.tree.Nodes {
+		typedef, ok := node.(*TypedefNode)
+		if !ok {
+			continue
+		}
+		if this.findTypedef(typedef.Type, typedef, map[*TypedefNode]bool{}) {
+			this.context.ReportError(
+				typedef.Name.Loc.Start,
+				"typedef '%s' refers to itself",
+				typedef.Name.Identifier(),
+			)
+		}
+	}
+	return !this.context.HasErrors()
+}
+
+// Search a type expression for a reference to the target typedef, without
+// resolving typedefs (which would never finish if they are cyclic).
+func (this *CyclicChecker) findTypedef(ttype Type, target *TypedefNode, seen map[*TypedefNode]bool) bool {
+	switch ttype.(type) {
+	case *ListType:
+		ttype := ttype.(*ListType)
+		return this.findTypedef(ttype.Inner, target, seen)
+
+	case *MapType:
+		ttype := ttype.(*MapType)
+		return this.findTypedef(ttype.Key, target, seen) || this.findTypedef(ttype.Value, target, seen)
+
+	case *NameProxyNode:
+		ttype := ttype.(*NameProxyNode)
+		typedef, ok := ttype.Binding.(*TypedefNode)
+		if !ok {
+			return false
+		}
+		if typedef == target {
+			return true
+		}
+
+		// Typedefs that are part of a different cycle are reported on their own.
+		if seen[typedef] {
+			return false
+		}
+		seen[typedef] = true
+		return this.findTypedef(typedef.Type, target, seen)
+	}
+	return false
+}
+
 func (this *CyclicChecker) checkCyclicService(node *ServiceNode) {
 	if node.Extends == nil {
 		return
 	}
 
+	// A chain can also end in a cycle that does not include this service;
+	// that cycle is reported on the services in it.
+	seen := map[*ServiceNode]bool{node: true}
 	parent := node.Extends.Binding.(*ServiceNode)
 	for {
 		if parent == node {
@@ -109,10 +180,11 @@ func (this *CyclicChecker) checkCyclicService(node *ServiceNode) {
 			return
 		}
 
-		if parent.Extends == nil {
+		if parent.Extends == nil || seen[parent] {
 			// Chain stops - exit with no error.
 			return
 		}
+		seen[parent] = true
 
 		parent = parent.Extends.Binding.(*ServiceNode)
 	}
@@ -0,0 +1,59 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package sema
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/edmodo/frugal/parser"
+)
+
+// The seed corpus is shared with the parser's fuzz targets.
+var seedCorpus = filepath.Join("..", "parser", "testdata")
+
+func FuzzAnalyze(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join(seedCorpus, "*.thrift"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// "fuzz.thrift" holds the input, and includes come from the corpus.
+		context := parser.NewCompileContext()
+		context.ReadFile = func(path string) ([]byte, error) {
+			name := filepath.Base(path)
+			if name == "fuzz.thrift" {
+				return data, nil
+			}
+			return ioutil.ReadFile(filepath.Join(seedCorpus, name))
+		}
+
+		tree := context.ParseRecursive("fuzz.thrift")
+		if tree == nil {
+			return
+		}
+		if !Analyze(context, tree) && !context.HasErrors() {
+			t.Fatalf("analysis failed without reporting an error")
+		}
+	})
+}
@@ -23,6 +23,7 @@ type PhaseCallback func(context *CompileContext, tree *ParseTree) bool
 var compilePhases = []PhaseCallback{
 	enterSymbols,
 	bindNames,
+	typedefCheck,
 	typeCheck,
 	cyclicCheck,
 	checkUnused,
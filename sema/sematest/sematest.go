@@ -0,0 +1,69 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+// Helpers for ginkgo specs that need analyzed parse trees.
+package sematest
+
+import (
+	"encoding/json"
+
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema"
+	. "github.com/onsi/gomega"
+)
+
+// A file with a struct for tests that need an include, as "common.thrift".
+const CommonSchema = `
+struct Point {
+	1: required i32 x
+	2: required i32 y
+}
+`
+
+// Definitions shared by test schemas for a Users service: an exception for
+// its methods to throw, and a base service for it to extend.
+const UsersSchema = `
+exception NotFound {
+	1: required string message
+}
+
+service Base {
+	bool ping()
+}
+`
+
+// Parses and analyzes files held in memory, keyed by path, and returns the
+// tree for "test.thrift". The running spec fails if they do not compile.
+func Compile(files map[string]string) *parser.ParseTree {
+	return compile(files)
+}
+
+// Compiles a schema with no includes, as "test.thrift".
+func CompileSchema(schema string) *parser.ParseTree {
+	return compile(map[string]string{"test.thrift": schema})
+}
+
+// Failures are reported at the caller of Compile() or CompileSchema().
+func compile(files map[string]string) *parser.ParseTree {
+	context := parser.NewMemoryCompileContext(files)
+	tree := context.ParseRecursive("test.thrift")
+	ExpectWithOffset(2, tree != nil && sema.Analyze(context, tree)).To(BeTrue(), "%v", context.Errors)
+	return tree
+}
+
+// Decodes JSON text, for comparing with generated documents.
+func ParseJSON(text string) interface{} {
+	var result interface{}
+	ExpectWithOffset(1, json.Unmarshal([]byte(text), &result)).To(Succeed())
+	return result
+}
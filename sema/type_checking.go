@@ -252,8 +252,10 @@ func (this *TypeChecker) checkMapType(ttype *MapType, value Node) *ValueNode {
 		return nil
 	}
 
-	// Check and resolve each key/value in the map.
-	for _, entry := range tmap.Entries {
+	// Check and resolve each key/value in the map. Entries are stored by value,
+	// so update them in place.
+	for i := range tmap.Entries {
+		entry := &tmap.Entries[i]
 		keyVal := this.checkType(ttype.Key, entry.Key)
 		if keyVal == nil {
 			return nil
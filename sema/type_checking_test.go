@@ -0,0 +1,57 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package sema_test
+
+import (
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Type checking", func() {
+	It("coerces map entries in place", func() {
+		tree := sematest.CompileSchema(`
+enum Color {
+	RED = 1
+	GREEN = 2
+}
+
+const map<Color, i16> WEIGHTS = {Color.RED: 1, Color.GREEN: 2}
+
+struct Palette {
+	1: optional map<string, list<Color>> groups = {"warm": [Color.RED]}
+}
+`)
+
+		value := tree.Names["WEIGHTS"].(*parser.ConstNode).Init.(*parser.ValueNode)
+		Expect(value.Type).To(Equal(parser.TOK_MAP))
+		entries := value.Result.(*parser.MapNode).Entries
+		Expect(entries).To(HaveLen(2))
+		for i, name := range []string{"RED", "GREEN"} {
+			Expect(entries[i].KeyVal).NotTo(BeNil())
+			Expect(entries[i].KeyVal.Type).To(Equal(parser.TOK_ENUM))
+			Expect(entries[i].KeyVal.Result.(*parser.EnumEntry).Name.Identifier()).To(Equal(name))
+			Expect(entries[i].ValueVal).NotTo(BeNil())
+			Expect(entries[i].ValueVal.Type).To(Equal(parser.TOK_I16))
+			Expect(entries[i].ValueVal.Result).To(Equal(int16(i + 1)))
+		}
+
+		// Defaults are coerced the same way.
+		field := tree.Names["Palette"].(*parser.StructNode).Fields[0]
+		entry := field.Default.(*parser.ValueNode).Result.(*parser.MapNode).Entries[0]
+		Expect(entry.KeyVal.Result).To(Equal("warm"))
+		Expect(entry.ValueVal.Type).To(Equal(parser.TOK_LIST))
+	})
+})
@@ -0,0 +1,220 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A numeric bound, parsed according to the type of the field it applies to.
+type bound struct {
+	text  string
+	int   int64
+	float float64
+}
+
+// Constraints on a single field, compiled from its annotations:
+//
+//   min, max                 numbers (i16, i32, i64, double), inclusive
+//   min_length, max_length   strings (in characters), lists and maps
+//   regex                    strings
+//
+// Other annotations are ignored, since they may be meant for other tools.
+type constraints struct {
+	min, max             *bound
+	minLength, maxLength int
+	regex                *regexp.Regexp
+}
+
+// Compiles the constraints of a field, or returns nil if it has none.
+func compileConstraints(field *parser.StructField) (*constraints, error) {
+	result := &constraints{
+		minLength: -1,
+		maxLength: -1,
+	}
+	found := false
+
+	ttype, _ := field.Type.Resolve()
+	for _, annotation := range field.Annotations {
+		var err error
+		switch annotation.Name {
+		case "min", "max":
+			var value *bound
+			if value, err = parseBound(ttype, annotation); err == nil {
+				if annotation.Name == "min" {
+					result.min = value
+				} else {
+					result.max = value
+				}
+			}
+		case "min_length", "max_length":
+			var value int
+			if value, err = parseLength(ttype, annotation); err == nil {
+				if annotation.Name == "min_length" {
+					result.minLength = value
+				} else {
+					result.maxLength = value
+				}
+			}
+		case "regex":
+			result.regex, err = parseRegex(ttype, annotation)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s (%s): %s: %v", field.Name.Identifier(), annotation.Loc.Start, annotation.Name, err)
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Returns the text of an annotation's value, which may be an integer or a
+// string.
+func annotationText(annotation *parser.Annotation) (string, error) {
+	if annotation.Value == nil {
+		return "", fmt.Errorf("expected a value")
+	}
+	if annotation.Value.Kind == parser.TOK_LITERAL_INT {
+		return strconv.FormatInt(annotation.Value.IntLiteral(), 10), nil
+	}
+	return annotation.Value.StringLiteral(), nil
+}
+
+func builtinKind(ttype parser.Type) parser.TokenKind {
+	if builtin, ok := ttype.(*parser.BuiltinType); ok {
+		return builtin.Tok.Kind
+	}
+	return parser.TOK_ERROR
+}
+
+func parseBound(ttype parser.Type, annotation *parser.Annotation) (*bound, error) {
+	text, err := annotationText(annotation)
+	if err != nil {
+		return nil, err
+	}
+
+	value := &bound{text: text}
+	switch builtinKind(ttype) {
+	case parser.TOK_I16, parser.TOK_I32, parser.TOK_I64:
+		if value.int, err = strconv.ParseInt(text, 10, 64); err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", text)
+		}
+	case parser.TOK_DOUBLE:
+		if value.float, err = strconv.ParseFloat(text, 64); err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", text)
+		}
+	default:
+		return nil, fmt.Errorf("only applies to numbers, not %s", ttype.String())
+	}
+	return value, nil
+}
+
+func parseLength(ttype parser.Type, annotation *parser.Annotation) (int, error) {
+	switch ttype.(type) {
+	case *parser.ListType, *parser.MapType:
+	default:
+		if builtinKind(ttype) != parser.TOK_STRING {
+			return 0, fmt.Errorf("only applies to strings, lists and maps, not %s", ttype.String())
+		}
+	}
+
+	text, err := annotationText(annotation)
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.Atoi(text)
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("expected a non-negative integer, got %q", text)
+	}
+	return length, nil
+}
+
+func parseRegex(ttype parser.Type, annotation *parser.Annotation) (*regexp.Regexp, error) {
+	if builtinKind(ttype) != parser.TOK_STRING {
+		return nil, fmt.Errorf("only applies to strings, not %s", ttype.String())
+	}
+	if annotation.Value == nil || annotation.Value.Kind != parser.TOK_LITERAL_STRING {
+		return nil, fmt.Errorf("expected a string")
+	}
+	return regexp.Compile(annotation.Value.StringLiteral())
+}
+
+// Checks a value, which must already have the field's type, against the
+// constraints. Returns a message describing the first violation, or "".
+func (this *constraints) check(value interface{}) string {
+	switch value.(type) {
+	case int16, int32, int64:
+		var number int64
+		switch value.(type) {
+		case int16:
+			number = int64(value.(int16))
+		case int32:
+			number = int64(value.(int32))
+		case int64:
+			number = value.(int64)
+		}
+		if this.min != nil && number < this.min.int {
+			return fmt.Sprintf("must be at least %s", this.min.text)
+		}
+		if this.max != nil && number > this.max.int {
+			return fmt.Sprintf("must be at most %s", this.max.text)
+		}
+
+	case float64:
+		number := value.(float64)
+		if this.min != nil && !(number >= this.min.float) {
+			return fmt.Sprintf("must be at least %s", this.min.text)
+		}
+		if this.max != nil && !(number <= this.max.float) {
+			return fmt.Sprintf("must be at most %s", this.max.text)
+		}
+
+	case string:
+		str := value.(string)
+		if message := this.checkLength(utf8.RuneCountInString(str)); message != "" {
+			return message
+		}
+		if this.regex != nil && !this.regex.MatchString(str) {
+			return fmt.Sprintf("must match %s", this.regex.String())
+		}
+
+	case []interface{}:
+		return this.checkLength(len(value.([]interface{})))
+
+	case *dynamic.Map:
+		return this.checkLength(len(value.(*dynamic.Map).Entries))
+	}
+	return ""
+}
+
+func (this *constraints) checkLength(length int) string {
+	if this.minLength >= 0 && length < this.minLength {
+		return fmt.Sprintf("length must be at least %d", this.minLength)
+	}
+	if this.maxLength >= 0 && length > this.maxLength {
+		return fmt.Sprintf("length must be at most %d", this.maxLength)
+	}
+	return ""
+}
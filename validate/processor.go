@@ -0,0 +1,116 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+)
+
+// Options that can be passed to NewProcessor().
+type Options struct {
+	// If non-nil, called with each request that fails validation, before it is
+	// rejected.
+	OnInvalid func(request *frugal.Request, err error)
+}
+
+// Validates the arguments of incoming requests before passing them on to
+// another processor, usually a generated one. Invalid requests are rejected
+// with a PROTOCOL_ERROR TApplicationException, and never reach the handler.
+// Requests for methods the service does not have are passed on untouched.
+//
+// The processor expects method names without a service prefix, so with a
+// frugal.Multiplexer, wrap the processor for each service before registering
+// it:
+//
+//     validated, err := validate.NewProcessor(service, processor, &validate.Options{})
+//     multiplexer.Register("Users", validated)
+type Processor struct {
+	processor frugal.Processor
+	validator *Validator
+	options   Options
+
+	// Argument structs by method name, including inherited methods.
+	args map[string]*parser.StructNode
+}
+
+// Creates a validating processor for a service, which must have been through
+// semantic analysis. Returns an error if any annotation constraint is
+// malformed.
+func NewProcessor(service *parser.ServiceNode, processor frugal.Processor, options *Options) (*Processor, error) {
+	validating := &Processor{
+		processor: processor,
+		validator: NewValidator(),
+		options:   *options,
+		args:      map[string]*parser.StructNode{},
+	}
+
+	for _, current := range service.InheritanceChain() {
+		for _, method := range current.Methods {
+			name := method.Name.Identifier()
+			if _, ok := validating.args[name]; ok {
+				continue
+			}
+
+			args := dynamic.ArgsStruct(method)
+			if err := validating.validator.AddStruct(args); err != nil {
+				return nil, fmt.Errorf("%s.%v", current.Name.Identifier(), err)
+			}
+			validating.args[name] = args
+		}
+	}
+	return validating, nil
+}
+
+// Implements frugal.Processor.
+func (this *Processor) ProcessRequest(request *frugal.Request) error {
+	node, ok := this.args[request.MethodName]
+	if !ok {
+		return this.processor.ProcessRequest(request)
+	}
+
+	args, err := dynamic.DecodeStruct(request.Input, node)
+	if err != nil {
+		return err
+	}
+	if err := request.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+
+	if err := this.validator.Validate(args); err != nil {
+		if this.options.OnInvalid != nil {
+			this.options.OnInvalid(request, err)
+		}
+		message := fmt.Sprintf("invalid arguments to %s: %v", request.MethodName, err)
+		return request.SendException(thrift.PROTOCOL_ERROR, message)
+	}
+
+	// The arguments have been consumed, so the next processor reads a copy.
+	buffer := thrift.NewTMemoryBuffer()
+	input := thrift.NewTBinaryProtocolTransport(buffer)
+	if err := dynamic.EncodeStruct(input, args); err != nil {
+		return err
+	}
+	if err := input.Flush(); err != nil {
+		return err
+	}
+
+	forwarded := *request
+	forwarded.Input = input
+	return this.processor.ProcessRequest(&forwarded)
+}
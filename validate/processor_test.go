@@ -0,0 +1,170 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/lib/frugal"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Server callbacks standing in for a generated processor. Each call is
+// decoded and recorded, and answered with an empty result.
+type TestHandler struct {
+	service   *parser.ServiceNode
+	processor frugal.Processor
+
+	lock  sync.Mutex
+	calls []*dynamic.Struct
+}
+
+func (this *TestHandler) ProcessRequest(request *frugal.Request) error {
+	if this.processor != nil {
+		return this.processor.ProcessRequest(request)
+	}
+
+	method := dynamic.FindServiceMethod(this.service, request.MethodName)
+	if method == nil {
+		return request.Reject(thrift.UNKNOWN_METHOD, "unknown method")
+	}
+	args, err := dynamic.DecodeArgs(request.Input, method)
+	if err != nil {
+		return err
+	}
+	if err := request.Input.ReadMessageEnd(); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	this.calls = append(this.calls, args)
+	this.lock.Unlock()
+
+	if request.MessageType == thrift.ONEWAY {
+		return nil
+	}
+	result := &dynamic.Struct{Node: dynamic.ResultStruct(method), Fields: []*dynamic.Field{}}
+	return dynamic.EncodeMessage(request.Output, request.MethodName, thrift.REPLY, request.SequenceId, result)
+}
+
+func (this *TestHandler) Calls() []*dynamic.Struct {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*dynamic.Struct(nil), this.calls...)
+}
+
+func (this *TestHandler) GetProtocolsForClient(client frugal.Transport) (thrift.TProtocol, thrift.TProtocol) {
+	factory := thrift.NewTBinaryProtocolFactoryDefault()
+	return factory.GetProtocol(client), factory.GetProtocol(client)
+}
+
+func (this *TestHandler) LogError(context string, err error) {
+}
+
+var _ = Describe("Processor", func() {
+	var service *parser.ServiceNode
+	var handler *TestHandler
+	var server *TestHandler
+	var conn *frugal.Connection
+	var invalid []error
+
+	BeforeEach(func() {
+		var err error
+		service, err = dynamic.FindService(sematest.CompileSchema(TestSchema), "Users")
+		Expect(err).To(BeNil())
+
+		invalid = nil
+		handler = &TestHandler{service: service}
+		processor, err := NewProcessor(service, handler, &Options{
+			OnInvalid: func(request *frugal.Request, err error) {
+				invalid = append(invalid, err)
+			},
+		})
+		Expect(err).To(BeNil())
+
+		// The validating processor sits between the server and the handler.
+		server = &TestHandler{processor: processor}
+		frugalServer, err := frugal.NewServer(server, &frugal.ServerOptions{ListenAddr: "127.0.0.1:0"})
+		Expect(err).To(BeNil())
+		conn, err = frugal.NewMemoryServiceFactory(frugalServer, nil, 5*time.Second).Connect()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		conn.Transport().Close()
+	})
+
+	call := func(name string, args string) error {
+		method := dynamic.FindServiceMethod(service, name)
+		value, err := dynamic.ArgsFromJSON(method, []byte(args))
+		Expect(err).To(BeNil())
+		_, err = dynamic.Call(conn.Input(), conn.Output(), name, 1, method, value)
+		return err
+	}
+
+	It("passes valid requests on", func() {
+		Expect(call("saveUser", `{"user": {"id": 5, "name": "Ada", "tags": ["x"]}}`)).To(Succeed())
+		Expect(call("ping", `{}`)).To(Succeed())
+
+		// The handler sees the arguments exactly as they were sent.
+		calls := handler.Calls()
+		Expect(calls).To(HaveLen(2))
+		user := calls[0].Field("user").Value.(*dynamic.Struct)
+		Expect(user.Field("id").Value).To(Equal(int64(5)))
+		Expect(user.Field("name").Value).To(Equal("Ada"))
+		Expect(user.Field("tags").Value).To(Equal([]interface{}{"x"}))
+		Expect(invalid).To(BeEmpty())
+	})
+
+	It("rejects invalid requests", func() {
+		err := call("saveUser", `{"user": {"id": 5, "name": "Ada Lovelace"}}`)
+		Expect(err).NotTo(BeNil())
+		exception, ok := err.(thrift.TApplicationException)
+		Expect(ok).To(BeTrue())
+		Expect(exception.TypeId()).To(Equal(int32(thrift.PROTOCOL_ERROR)))
+		Expect(exception.Error()).To(Equal("invalid arguments to saveUser: user.name: length must be at most 8"))
+
+		// Argument annotations are checked, too.
+		Expect(call("getUser", `{"id": 0}`)).NotTo(Succeed())
+
+		Expect(handler.Calls()).To(BeEmpty())
+		Expect(invalid).To(HaveLen(2))
+
+		// The connection is still usable.
+		Expect(call("getUser", `{"id": 1}`)).To(Succeed())
+		Expect(handler.Calls()).To(HaveLen(1))
+	})
+
+	It("drops invalid oneway requests", func() {
+		Expect(call("touch", `{"id": 11}`)).To(Succeed())
+		Expect(call("touch", `{"id": 10}`)).To(Succeed())
+		Eventually(func() int { return len(handler.Calls()) }).Should(Equal(1))
+		Expect(handler.Calls()[0].Field("id").Value).To(Equal(int64(10)))
+		Expect(invalid).To(HaveLen(1))
+	})
+
+	It("rejects malformed annotations", func() {
+		tree := sematest.CompileSchema(`service S { void f(1: string s (min = 1)) }`)
+		service, _ := dynamic.FindService(tree, "S")
+		_, err := NewProcessor(service, handler, &Options{})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(HavePrefix("S.f_args.s"))
+	})
+})
@@ -0,0 +1,57 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"testing"
+
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Validation testing")
+}
+
+const TestSchema = `
+enum Role {
+	ADMIN = 1
+	MEMBER = 2
+}
+
+typedef string Email
+
+struct Address {
+	1: required string city (min_length = 1)
+	2: optional string zip (regex = "^[0-9]{5}$")
+}
+
+struct User {
+	1: required i64 id (min = 1)
+	2: required string name (min_length = 1, max_length = 8)
+	3: optional Role role
+	4: optional list<string> tags (max_length = 2, doc = "ignored")
+	5: optional map<string, Address> addresses
+	6: optional double score (min = "0", max = "1.5")
+	7: optional Email email (regex = "@")
+}
+
+service Users extends Base {
+	User getUser(1: i64 id (min = 1)) throws (1: NotFound notFound)
+	void saveUser(1: User user)
+	oneway void touch(1: i64 id (max = 10))
+}
+` + sematest.UsersSchema
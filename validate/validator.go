@@ -0,0 +1,240 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+)
+
+// A value that failed validation.
+type Error struct {
+	// The path to the offending value, for example "user.tags[2]".
+	Path    string
+	Message string
+}
+
+func (this *Error) Error() string {
+	return fmt.Sprintf("%s: %s", this.Path, this.Message)
+}
+
+func validationError(path string, format string, args ...interface{}) error {
+	return &Error{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Checks decoded values (see the dynamic package) against their schema:
+//
+//   - required fields must be present,
+//   - every value must have the type the schema declares,
+//   - enum values must be defined by the enum, and
+//   - fields must satisfy the constraints in their annotations.
+//
+// Nested structs, lists and maps are checked too. Fields that are not in the
+// schema are allowed, so that older servers accept newer clients.
+type Validator struct {
+	structs map[*parser.StructNode]bool
+	fields  map[*parser.StructField]*constraints
+}
+
+func NewValidator() *Validator {
+	return &Validator{
+		structs: map[*parser.StructNode]bool{},
+		fields:  map[*parser.StructField]*constraints{},
+	}
+}
+
+// Compiles the annotation constraints of a struct and of every struct it
+// contains. Returns an error if an annotation is malformed or does not apply
+// to the type of its field. Structs that were never added are still checked,
+// but their annotations are ignored.
+//
+// This must not be called concurrently with Validate().
+func (this *Validator) AddStruct(node *parser.StructNode) error {
+	if this.structs[node] {
+		return nil
+	}
+	this.structs[node] = true
+
+	for _, field := range node.Fields {
+		constraints, err := compileConstraints(field)
+		if err != nil {
+			return fmt.Errorf("%s.%v", node.Name.Identifier(), err)
+		}
+		if constraints != nil {
+			this.fields[field] = constraints
+		}
+		if err := this.addType(field.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *Validator) addType(ttype parser.Type) error {
+	ttype, node := ttype.Resolve()
+	switch ttype.(type) {
+	case *parser.ListType:
+		return this.addType(ttype.(*parser.ListType).Inner)
+	case *parser.MapType:
+		ttype := ttype.(*parser.MapType)
+		if err := this.addType(ttype.Key); err != nil {
+			return err
+		}
+		return this.addType(ttype.Value)
+	}
+	if node, ok := node.(*parser.StructNode); ok {
+		return this.AddStruct(node)
+	}
+	return nil
+}
+
+// Validates a decoded struct, which must have a schema. Returns an *Error
+// for the first problem found, or nil.
+func (this *Validator) Validate(value *dynamic.Struct) error {
+	if value.Node == nil {
+		return validationError("(root)", "value has no schema")
+	}
+	return this.checkStruct(value.Node, value, "")
+}
+
+func isRequired(field *parser.StructField) bool {
+	return field.Spec == nil || field.Spec.Kind == parser.TOK_REQUIRED
+}
+
+func appendPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func (this *Validator) checkStruct(node *parser.StructNode, value *dynamic.Struct, path string) error {
+	for _, schema := range node.Fields {
+		fieldPath := appendPath(path, schema.Name.Identifier())
+
+		field := value.FieldById(dynamic.FieldId(schema.Order))
+		if field == nil {
+			if isRequired(schema) {
+				return validationError(fieldPath, "required field is missing")
+			}
+			continue
+		}
+		if field.Unknown {
+			return validationError(fieldPath, "expected %s, got %s on the wire", schema.Type.String(), field.Type.String())
+		}
+
+		if err := this.checkValue(schema.Type, field.Value, fieldPath); err != nil {
+			return err
+		}
+		if constraints := this.fields[schema]; constraints != nil {
+			if message := constraints.check(field.Value); message != "" {
+				return validationError(fieldPath, "%s", message)
+			}
+		}
+	}
+	return nil
+}
+
+func (this *Validator) checkValue(ttype parser.Type, value interface{}, path string) error {
+	resolved, node := ttype.Resolve()
+
+	var ok bool
+	switch resolved.(type) {
+	case *parser.BuiltinType:
+		switch resolved.(*parser.BuiltinType).Tok.Kind {
+		case parser.TOK_BOOL:
+			_, ok = value.(bool)
+		case parser.TOK_I16:
+			_, ok = value.(int16)
+		case parser.TOK_I32:
+			_, ok = value.(int32)
+		case parser.TOK_I64:
+			_, ok = value.(int64)
+		case parser.TOK_DOUBLE:
+			_, ok = value.(float64)
+		case parser.TOK_STRING:
+			_, ok = value.(string)
+		}
+
+	case *parser.ListType:
+		var list []interface{}
+		if list, ok = value.([]interface{}); ok {
+			inner := resolved.(*parser.ListType).Inner
+			for i, elem := range list {
+				if err := this.checkValue(inner, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case *parser.MapType:
+		var tmap *dynamic.Map
+		if tmap, ok = value.(*dynamic.Map); ok {
+			mapType := resolved.(*parser.MapType)
+			for i, entry := range tmap.Entries {
+				entryPath := fmt.Sprintf("%s[%s]", path, keyString(entry.Key, i))
+				if err := this.checkValue(mapType.Key, entry.Key, entryPath); err != nil {
+					return err
+				}
+				if err := this.checkValue(mapType.Value, entry.Value, entryPath); err != nil {
+					return err
+				}
+			}
+		}
+
+	default:
+		switch node.(type) {
+		case *parser.EnumNode:
+			var enum *dynamic.Enum
+			if enum, ok = value.(*dynamic.Enum); ok && enum.Entry == nil {
+				node := node.(*parser.EnumNode)
+				return validationError(path, "%d is not a value of enum %s", enum.Value, node.Name.Identifier())
+			}
+		case *parser.StructNode:
+			var child *dynamic.Struct
+			if child, ok = value.(*dynamic.Struct); ok {
+				return this.checkStruct(node.(*parser.StructNode), child, path)
+			}
+		}
+	}
+
+	if !ok {
+		return validationError(path, "expected %s", ttype.String())
+	}
+	return nil
+}
+
+// Formats a map key for an error path. Keys that have no short form are
+// given by their position in the map instead.
+func keyString(key interface{}, index int) string {
+	switch key.(type) {
+	case string:
+		return strconv.Quote(key.(string))
+	case bool, int16, int32, int64, float64:
+		return fmt.Sprintf("%v", key)
+	case *dynamic.Enum:
+		enum := key.(*dynamic.Enum)
+		if enum.Entry != nil {
+			return enum.Name()
+		}
+		return fmt.Sprintf("%d", enum.Value)
+	}
+	return fmt.Sprintf("#%d", index)
+}
@@ -0,0 +1,138 @@
+// vim: set ts=4 sw=4 tw=99 noet:
+//
+// Copyright 2014, Edmodo, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this work except in compliance with the License.
+// You may obtain a copy of the License in the LICENSE file, or at:
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on an "AS IS"
+// BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the specific language
+// governing permissions and limitations under the License.
+
+package validate
+
+import (
+	"github.com/edmodo/frugal/dynamic"
+	"github.com/edmodo/frugal/parser"
+	"github.com/edmodo/frugal/sema/sematest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validator", func() {
+	var user *parser.StructNode
+	var validator *Validator
+
+	BeforeEach(func() {
+		var err error
+		user, err = dynamic.FindStruct(sematest.CompileSchema(TestSchema), "User")
+		Expect(err).To(BeNil())
+		validator = NewValidator()
+		Expect(validator.AddStruct(user)).To(Succeed())
+	})
+
+	// Validates a user given as JSON, returning the error message.
+	validate := func(json string) string {
+		value, err := dynamic.StructFromJSON(user, []byte(json))
+		Expect(err).To(BeNil())
+		if err := validator.Validate(value); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	It("accepts valid values", func() {
+		Expect(validate(`{"id": 1, "name": "Ada"}`)).To(Equal(""))
+		Expect(validate(`{
+			"id": 9007199254740993,
+			"name": "ééééééé",
+			"role": "MEMBER",
+			"tags": ["a", "b"],
+			"addresses": {"home": {"city": "Paris", "zip": "75001"}},
+			"score": 1.5,
+			"email": "ada@example.com"
+		}`)).To(Equal(""))
+	})
+
+	It("checks annotation constraints", func() {
+		Expect(validate(`{"id": 0, "name": "Ada"}`)).To(Equal("id: must be at least 1"))
+		Expect(validate(`{"id": 1, "name": ""}`)).To(Equal("name: length must be at least 1"))
+		Expect(validate(`{"id": 1, "name": "Ada Lovelace"}`)).To(Equal("name: length must be at most 8"))
+		Expect(validate(`{"id": 1, "name": "Ada", "tags": ["a", "b", "c"]}`)).To(Equal("tags: length must be at most 2"))
+		Expect(validate(`{"id": 1, "name": "Ada", "score": 1.6}`)).To(Equal("score: must be at most 1.5"))
+		Expect(validate(`{"id": 1, "name": "Ada", "score": -0.5}`)).To(Equal("score: must be at least 0"))
+		Expect(validate(`{"id": 1, "name": "Ada", "email": "ada"}`)).To(Equal("email: must match @"))
+	})
+
+	It("checks nested structs", func() {
+		Expect(validate(`{"id": 1, "name": "Ada", "addresses": {"home": {"city": ""}}}`)).To(Equal(
+			`addresses["home"].city: length must be at least 1`,
+		))
+		Expect(validate(`{"id": 1, "name": "Ada", "addresses": {"home": {"city": "Paris", "zip": "123"}}}`)).To(Equal(
+			`addresses["home"].zip: must match ^[0-9]{5}$`,
+		))
+	})
+
+	It("checks required fields", func() {
+		value, err := dynamic.StructFromJSON(user, []byte(`{"id": 1, "name": "Ada"}`))
+		Expect(err).To(BeNil())
+		value.Fields = value.Fields[:1]
+
+		err = validator.Validate(value)
+		Expect(err).NotTo(BeNil())
+		Expect(err.(*Error).Path).To(Equal("name"))
+		Expect(err.(*Error).Message).To(Equal("required field is missing"))
+	})
+
+	It("checks enum values", func() {
+		value, err := dynamic.StructFromJSON(user, []byte(`{"id": 1, "name": "Ada", "role": "ADMIN"}`))
+		Expect(err).To(BeNil())
+		role := value.Field("role").Value.(*dynamic.Enum)
+		value.Field("role").Value = &dynamic.Enum{Node: role.Node, Value: 7}
+
+		Expect(validator.Validate(value).Error()).To(Equal("role: 7 is not a value of enum Role"))
+	})
+
+	It("checks value types", func() {
+		value, err := dynamic.StructFromJSON(user, []byte(`{"id": 1, "name": "Ada", "tags": ["a"]}`))
+		Expect(err).To(BeNil())
+		value.Field("tags").Value = []interface{}{"a", int32(1)}
+		Expect(validator.Validate(value).Error()).To(Equal("tags[1]: expected string"))
+
+		value.Field("tags").Unknown = true
+		Expect(validator.Validate(value)).NotTo(BeNil())
+	})
+
+	It("ignores annotations of structs that were not added", func() {
+		value, err := dynamic.StructFromJSON(user, []byte(`{"id": 0, "name": "Ada"}`))
+		Expect(err).To(BeNil())
+		Expect(NewValidator().Validate(value)).To(BeNil())
+	})
+
+	It("rejects malformed annotations", func() {
+		bad := map[string]string{
+			`struct S { 1: string s (min = 1) }`:           "S.s (line 1, col 24): min: only applies to numbers, not string",
+			`struct S { 1: i32 n (regex = "x") }`:          "S.n (line 1, col 21): regex: only applies to strings, not i32",
+			`struct S { 1: i32 n (max = "ten") }`:          `S.n (line 1, col 21): max: expected an integer, got "ten"`,
+			`struct S { 1: string s (max_length = "-1") }`: `S.s (line 1, col 24): max_length: expected a non-negative integer, got "-1"`,
+			`struct S { 1: string s (regex) }`:             "S.s (line 1, col 24): regex: expected a string",
+		}
+		for schema, message := range bad {
+			node, err := dynamic.FindStruct(sematest.CompileSchema(schema), "S")
+			Expect(err).To(BeNil())
+			err = NewValidator().AddStruct(node)
+			Expect(err).NotTo(BeNil(), schema)
+			Expect(err.Error()).To(Equal(message))
+		}
+
+		// Structs are checked through containers, too.
+		tree := sematest.CompileSchema(`
+			struct S { 1: string s (regex = "(") }
+			struct T { 1: map<string, list<S>> items }
+		`)
+		node, _ := dynamic.FindStruct(tree, "T")
+		Expect(NewValidator().AddStruct(node)).NotTo(BeNil())
+	})
+})